@@ -2,23 +2,39 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/text"
-	"github.com/scottdware/go-junos"
-	cache "github.com/victorspringer/http-cache"
-	"github.com/victorspringer/http-cache/adapter/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/httpx"
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/switch-monitoring/internal"
 	"github.com/m-lab/switch-monitoring/internal/collector"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	"github.com/m-lab/switch-monitoring/internal/gitsync"
+	"github.com/m-lab/switch-monitoring/internal/history"
+	"github.com/m-lab/switch-monitoring/internal/hostkey"
+	"github.com/m-lab/switch-monitoring/internal/inventory"
+	"github.com/m-lab/switch-monitoring/internal/metrics"
 	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/m-lab/switch-monitoring/internal/reload"
+	"github.com/m-lab/switch-monitoring/internal/resolver"
+	"github.com/m-lab/switch-monitoring/internal/state"
 )
 
 const (
@@ -26,10 +42,15 @@ const (
 	defaultProjectID  = "mlab-sandbox"
 	defaultSSHUser    = "switch-monitoring"
 
-	// TODO: use v2 hostnames once they are available.
-	// (https://github.com/m-lab/siteinfo/issues/134)
-	switchHostFormat  = "s1.%s.measurement-lab.org"
+	// Sessions are kept open for a short time only, since most checks for
+	// a given switch happen during the same scrape.
+	defaultSSHIdleTimeout     = time.Minute
+	defaultSSHSessionsPerHost = 2
+
+	// Switches in the inventory are identified by their v2 hostname.
+	switchHostFormat  = "s1-%s.measurement-lab.org"
 	siteinfoVersion   = "v1"
+	siteinfoURLFormat = "https://siteinfo.%s.measurementlab.net/%s/sites/switches.json"
 	httpClientTimeout = time.Second * 15
 
 	// The default cache capacity has been chosen based on the current amount
@@ -37,6 +58,52 @@ const (
 	// expansion.
 	defaultCacheCapacity = 250
 	defaultCacheTTL      = 24 * time.Hour
+
+	// Mismatches are expected to be fixed soon, while failures are usually
+	// transient. Switches that cannot be reached are retried less and less
+	// often, up to the maximum backoff.
+	defaultCacheMismatchTTL = time.Hour
+	defaultCacheErrorTTL    = time.Minute
+	defaultCacheMaxBackoff  = time.Hour
+
+	// Expected configurations change rarely, but a fixed switch should not
+	// be reported as drifted for long.
+	defaultCachePollInterval = 5 * time.Minute
+
+	// Checking the files for changes is cheap, and rotated credentials
+	// should be used soon.
+	defaultReloadInterval = time.Minute
+
+	// Drift is usually investigated within days of being fixed, but a
+	// quarter of history leaves room for audits. Pruning is cheap, since
+	// results are stored by day.
+	defaultHistoryRetention = 90 * 24 * time.Hour
+	historyPruneInterval    = time.Hour
+
+	// Host keys recorded on first use are persisted in the background, and
+	// given some time to complete when exiting.
+	hostKeysFlushTimeout = 30 * time.Second
+
+	// Batch checks are meant for dashboards and audits of a few sites, and
+	// must not exceed the timeout of a typical HTTP client.
+	defaultBatchConcurrency = 10
+	defaultBatchTimeout     = 2 * time.Minute
+
+	// The most recent commits usually explain a configuration drift.
+	defaultDiffCommits = 5
+
+	defaultGitRefresh = 5 * time.Minute
+	defaultGitPath    = "{site}.conf"
+
+	// Switches roll back a remediation unless it's confirmed within this
+	// time, which leaves room for the confirmation to reconnect.
+	defaultConfirmMinutes = 5
+
+	// Background checks are disabled by default, since Prometheus is
+	// expected to probe each switch through /v1/check.
+	defaultSchedulerConcurrency = 10
+	defaultSchedulerJitter      = 30 * time.Second
+	defaultSchedulerTimeout     = time.Minute
 )
 
 var (
@@ -47,14 +114,155 @@ var (
 	sshUsername = flag.String("ssh.username", defaultSSHUser,
 		"Username to use.")
 	sshKey = flag.String("ssh.key", "",
-		"Path to the SSH private key to use.")
+		"SSH private key to use: a path, env:NAME for an environment "+
+			"variable or secret:NAME for a secret in ssh.secrets-dir.")
 	sshPassphrase = flag.String("ssh.passphrase", "",
-		"Passphrase to decrypt the private key. Can be omitted.")
+		"Passphrase to decrypt the private key. Can be omitted. "+
+			"Prefer ssh.passphrase-secret.")
+	sshPassphraseSecret = flag.String("ssh.passphrase-secret", "",
+		"Passphrase to decrypt the private key, as a path, env:NAME or "+
+			"secret:NAME. Can be omitted.")
+	sshCertificate = flag.String("ssh.certificate", "",
+		"Certificate of the private key signed by a CA trusted by the "+
+			"switches, as a path, env:NAME or secret:NAME. Can be omitted.")
+	sshAgent = flag.Bool("ssh.agent", false,
+		"Authenticate with the keys held by the ssh-agent listening on "+
+			"$SSH_AUTH_SOCK")
+	sshPassword = flag.String("ssh.password", "",
+		"Password for password and keyboard-interactive authentication, "+
+			"tried after the keys, as a path, env:NAME or secret:NAME.")
+	sshSecretsDir = flag.String("ssh.secrets-dir", "",
+		"Directory containing a file for each secret, as mounted by a "+
+			"secret manager")
+	sshSiteCredentials = flag.String("ssh.site-credentials", "",
+		"Path of a JSON file overriding the SSH credentials of specific "+
+			"sites")
+	sshIdleTimeout = flag.Duration("ssh.idle-timeout", defaultSSHIdleTimeout,
+		"How long an idle NETCONF session is kept open for reuse. "+
+			"Zero disables session reuse.")
+	sshSessionsPerHost = flag.Int("ssh.max-sessions-per-host",
+		defaultSSHSessionsPerHost,
+		"Maximum number of concurrent NETCONF sessions to the same switch")
+	sshHostKeyPolicy = flagx.Enum{
+		Options: []string{hostkey.Insecure, hostkey.TOFU, hostkey.Strict},
+		Value:   hostkey.Insecure,
+	}
+	sshKnownHosts = flag.String("ssh.known-hosts", "",
+		"Path or gs:// URL of the known hosts file used by the tofu and "+
+			"strict host key policies")
+
+	adminToken = flag.String("admin.token", "",
+		"Bearer token required by the operator endpoints. If empty, they "+
+			"are disabled.")
 
 	cacheCapacity = flag.Int("collector.cache-capacity", defaultCacheCapacity,
 		"Maximum # of cached responses for the /check endpoint")
 	cacheTTL = flag.Duration("collector.cache-ttl", defaultCacheTTL,
-		"TTL of cached responses for the /check endpoint")
+		"TTL of cached responses for the /check endpoint when the "+
+			"configuration matches")
+	cacheMismatchTTL = flag.Duration("collector.cache-mismatch-ttl",
+		defaultCacheMismatchTTL,
+		"TTL of cached responses for the /check endpoint when the "+
+			"configuration does not match")
+	cacheErrorTTL = flag.Duration("collector.cache-error-ttl",
+		defaultCacheErrorTTL,
+		"TTL of cached responses for the /check endpoint when the check "+
+			"fails. It doubles after every consecutive failure to reach "+
+			"the switch.")
+	cacheMaxBackoff = flag.Duration("collector.cache-max-backoff",
+		defaultCacheMaxBackoff,
+		"Maximum TTL of cached responses for switches that cannot be reached")
+	cachePollInterval = flag.Duration("collector.cache-poll-interval",
+		defaultCachePollInterval,
+		"How often the expected configuration of every cached switch is "+
+			"checked for changes, invalidating the cached response. Zero "+
+			"disables the check.")
+
+	batchConcurrency = flag.Int("collector.batch-concurrency",
+		defaultBatchConcurrency,
+		"Maximum number of switches checked at the same time by a "+
+			"/v1/check/batch request")
+	batchTimeout = flag.Duration("collector.batch-timeout", defaultBatchTimeout,
+		"Maximum duration of a /v1/check/batch request")
+
+	diffCommits = flag.Int("collector.diff-commits", defaultDiffCommits,
+		"Number of recent commits on the switch included in /v1/diff")
+
+	sections  flagx.StringArray
+	rulesFile = flag.String("collector.rules", "",
+		"Path to a JSON file with the normalization rules to apply "+
+			"before comparing configurations. Can be omitted.")
+
+	schedulerInterval = flag.Duration("scheduler.interval", 0,
+		"How often every switch is checked in the background, serving the "+
+			"results from /metrics. Zero disables background checks.")
+	schedulerConcurrency = flag.Int("scheduler.concurrency",
+		defaultSchedulerConcurrency,
+		"Maximum number of switches checked in the background at the same time")
+	schedulerJitter = flag.Duration("scheduler.jitter", defaultSchedulerJitter,
+		"Maximum random delay before each background check")
+	schedulerTimeout = flag.Duration("scheduler.timeout",
+		defaultSchedulerTimeout, "Timeout of each background check")
+	schedulerTargets flagx.StringArray
+
+	driverDefault = flagx.Enum{
+		Options: []string{driver.Juniper, driver.Generic},
+		Value:   driver.Juniper,
+	}
+	driverVendors flagx.KeyValue
+
+	resolverRegexps   flagx.StringArray
+	resolverTemplates flagx.StringArray
+
+	reloadInterval = flag.Duration("reload.interval", defaultReloadInterval,
+		"How often the credential and rules files are checked for changes, "+
+			"to reload them. They are also reloaded on SIGHUP and through "+
+			"/v1/admin/reload. Zero disables the check.")
+
+	inventoryRefresh = flag.Duration("inventory.refresh-interval", 0,
+		"How often the list of switches is fetched from siteinfo. If not "+
+			"zero, only the listed switches can be checked and they are "+
+			"served for Prometheus service discovery on /v1/targets, "+
+			"e.g. 1h. Zero disables the inventory.")
+	siteinfoURL = flag.String("inventory.url", "",
+		"URL of siteinfo's switches.json. Defaults to the one for the "+
+			"current project.")
+
+	configURL = flag.String("collector.config-url", collector.DefaultConfigURL,
+		"URL template of the expected configuration of each switch. "+
+			"{project}, {site} and {hostname} are replaced with the GCP "+
+			"project, the switch's site and its hostname. Supports gs://, "+
+			"file:// and https://. A URL ending with / is a directory "+
+			"containing a <site>.conf file for each switch.")
+	gitRepo = flag.String("collector.git-repo", "",
+		"Git repository to read the expected configurations from, instead "+
+			"of collector.config-url. Can be omitted.")
+	gitRef = flag.String("collector.git-ref", "",
+		"Branch, tag or commit of collector.git-repo to check out. "+
+			"Defaults to the remote HEAD.")
+	gitPath = flag.String("collector.git-path", defaultGitPath,
+		"Path template of the expected configuration of each switch within "+
+			"collector.git-repo, with the same placeholders as "+
+			"collector.config-url")
+	gitDir = flag.String("collector.git-dir", "",
+		"Local directory for the checkout of collector.git-repo. Defaults "+
+			"to a temporary directory.")
+	gitRefresh = flag.Duration("collector.git-refresh", defaultGitRefresh,
+		"How often collector.git-repo is updated")
+
+	remediationEnabled = flag.Bool("remediation.enabled", false,
+		"Enable POST /v1/remediate, pushing the expected configuration to a "+
+			"switch. Requires admin.token.")
+	remediationConfirm = flag.Int("remediation.confirm-minutes",
+		defaultConfirmMinutes,
+		"Minutes after which a switch rolls back an unconfirmed remediation")
+
+	historyDir = flag.String("history.dir", "",
+		"Directory where the configurations read from the switches and "+
+			"the check results are archived. Empty disables the archive.")
+	historyRetention = flag.Duration("history.retention", defaultHistoryRetention,
+		"How long archived results and configurations are kept after they "+
+			"were last seen. Zero keeps them forever.")
 
 	debug = flag.Bool("debug", true, "Show debug messages.")
 
@@ -63,14 +271,58 @@ var (
 
 	osExit = os.Exit
 
-	newNetconf = func(auth *junos.AuthMethod) internal.NetconfClient {
-		return netconf.New(auth)
+	httpProvider internal.HTTPProvider = &http.Client{
+		Timeout: httpClientTimeout,
+	}
+
+	newNetconf = func(vendor string, auth credentials.Provider, opts ...netconf.Option) internal.NetconfClient {
+		if *sshIdleTimeout > 0 {
+			opts = append(opts, netconf.WithPool(netconf.PoolConfig{
+				MaxSessionsPerHost: *sshSessionsPerHost,
+				IdleTimeout:        *sshIdleTimeout,
+			}))
+		}
+		if vendor == driver.Generic {
+			return netconf.NewGeneric(auth, opts...)
+		}
+		return netconf.New(auth, opts...)
+	}
+
+	// formats are the configuration formats read by each driver.
+	formats = map[string]netconf.Format{
+		driver.Juniper: netconf.Text,
+		driver.Generic: netconf.XML,
 	}
 )
 
-func main() {
-	var collectorHandler http.Handler
+func init() {
+	flag.Var(&sections, "collector.sections",
+		"Top-level configuration sections to check individually, e.g. "+
+			"system,interfaces,protocols,firewall,snmp,vlans")
+	flag.Var(&sshHostKeyPolicy, "ssh.host-key-policy",
+		"How switch host keys are verified: insecure (accept any key), "+
+			"tofu (record keys on first use and report changes) or strict "+
+			"(refuse connections on key changes)")
+	flag.Var(&schedulerTargets, "scheduler.targets",
+		"Switches to check in the background, e.g. s1-abc01,s1-xyz01. "+
+			"Defaults to all the switches in the inventory.")
+	flag.Var(&driverDefault, "driver.default",
+		"Driver for switches whose vendor is unknown: juniper (JunOS "+
+			"NETCONF, text format) or generic (RFC 6241 NETCONF, XML)")
+	flag.Var(&driverVendors, "driver.vendors",
+		"Vendor of specific switches, overriding the inventory, e.g. "+
+			"s1-abc01.measurement-lab.org=generic")
+	flag.Var(&resolverRegexps, "resolver.regexp",
+		"Regular expressions extracting the site from the hostname of "+
+			"switches with a custom naming scheme, from the submatch "+
+			"named site or the first one, e.g. ^edge-([a-z0-9]+)\\.")
+	flag.Var(&resolverTemplates, "resolver.template",
+		"Templates of the hostname of switches with a custom naming "+
+			"scheme, where {site} is the site and {index} the number of "+
+			"the switch, e.g. sw{index}.{site}.example.org")
+}
 
+func main() {
 	flag.Parse()
 
 	if *debug {
@@ -80,54 +332,247 @@ func main() {
 
 	rtx.Must(flagx.ArgsFromEnv(flag.CommandLine), "Cannot parse env args")
 
-	// A private key must be provided.
-	if *sshKey == "" {
-		log.Error("The SSH private key must be provided.")
+	// At least one authentication method must be provided. The credentials
+	// are loaded once the sites of the switches can be resolved.
+	loader, err := newCredentials()
+	if err != nil {
+		log.WithError(err).Error("Invalid SSH credentials.")
+		osExit(1)
+	}
+	auth := &credentials.Reloadable{}
+
+	// Remediation changes the switches' configuration, so it's never
+	// exposed without authentication.
+	if *remediationEnabled && *adminToken == "" {
+		log.Error("The admin token must be provided to enable remediation.")
 		osExit(1)
 	}
 
 	// Initialize Siteinfo provider and the NETCONF client.
-	auth := &junos.AuthMethod{
-		Username:   *sshUsername,
-		PrivateKey: *sshKey,
-		Passphrase: *sshPassphrase,
-	}
-	netconf := newNetconf(auth)
-
-	collectorHandler = collector.NewHandler(*project, netconf)
-
-	// Create an in-memory cache to avoid connecting to a switch too often.
-	//
-	// Assuming we have enough capacity to keep all the responses in the cache,
-	// the choice of eviction algorithm does not really make a difference.
-	//
-	// If we don't have enough capacity to keep all the responses in memory:
-	//
-	//   - By using MRU, the first capacity - 1 responses will be re-used for
-	//     24 hours and switches - capacity + 1 new SSH connections will be
-	//     made.
-	//   - By using LRU, all the SSH connections will be made each time.
-	//
-	// Both conditions are very undesirable and we should make sure
-	// capacity > switches at all times. However, using MRU significantly
-	// limits the impact when this does not hold true anymore.
-
-	memcache, err := memory.NewAdapter(
-		memory.AdapterWithAlgorithm(memory.MRU),
-		memory.AdapterWithCapacity(*cacheCapacity),
-	)
-	rtx.Must(err, "Cannot initialize in-memory cache.")
-
-	cacheClient, err := cache.NewClient(
-		cache.ClientWithAdapter(memcache),
-		cache.ClientWithTTL(*cacheTTL),
-	)
-	rtx.Must(err, "Cannot initialize in-memory cache client.")
-
-	collectorHandler = cacheClient.Middleware(collectorHandler)
+	var opts []netconf.Option
+	var hostKeys *hostkey.Store
+	if sshHostKeyPolicy.Value != hostkey.Insecure {
+		if *sshKnownHosts == "" {
+			log.Error("The known hosts file must be provided.")
+			osExit(1)
+		}
+		backend, err := hostkey.NewBackend(ctx, *sshKnownHosts)
+		rtx.Must(err, "Cannot open known hosts %s", *sshKnownHosts)
+		hostKeys, err = hostkey.NewStore(ctx, backend,
+			sshHostKeyPolicy.Value == hostkey.Strict)
+		rtx.Must(err, "Cannot load known hosts %s", *sshKnownHosts)
+		opts = append(opts, netconf.WithHostKeyPolicy(hostKeys))
+	}
+	drivers := driver.NewRegistry(driverDefault.Value)
+	drivers.Overrides = driverVendors.Get()
+	for vendor, format := range formats {
+		drivers.Register(vendor, driver.Driver{
+			Netconf: newNetconf(vendor, auth, opts...),
+			Format:  format,
+		})
+	}
+	defer drivers.Close()
+	defaultDriver, err := drivers.ForTarget("")
+	rtx.Must(err, "Cannot initialize the default driver")
+
+	collectorHandler := collector.NewHandler(*project, defaultDriver.Netconf)
+	collectorHandler.Drivers = drivers
+	collectorHandler.Sections = sections
+	collectorHandler.ConfigURL = *configURL
+	collectorHandler.ConfirmMinutes = *remediationConfirm
+	collectorHandler.DiffCommits = *diffCommits
+	collectorHandler.BatchConcurrency = *batchConcurrency
+	collectorHandler.BatchTimeout = *batchTimeout
+
+	if *gitRepo != "" {
+		dir := *gitDir
+		if dir == "" {
+			var err error
+			dir, err = ioutil.TempDir("", "switch-config")
+			rtx.Must(err, "Cannot create directory for the git checkout")
+			defer os.RemoveAll(dir)
+		}
+		checkout := &gitsync.Checkout{Remote: *gitRepo, Ref: *gitRef, Dir: dir}
+		rtx.Must(checkout.Sync(ctx), "Cannot check out %s", *gitRepo)
+		go checkout.Run(ctx, *gitRefresh)
+		// The checkout can be relative to the working directory, while
+		// file:// URLs are always absolute.
+		abs, err := filepath.Abs(dir)
+		rtx.Must(err, "Cannot resolve the git checkout directory %s", dir)
+		collectorHandler.ConfigURL = "file://" + filepath.ToSlash(
+			filepath.Join(abs, filepath.FromSlash(*gitPath)))
+		collectorHandler.ConfigLock = checkout.RLocker()
+	}
+
+	var archive *history.Store
+	if *historyDir != "" {
+		archive, err = history.NewStore(*historyDir)
+		rtx.Must(err, "Cannot open history directory %s", *historyDir)
+		archive.Retention = *historyRetention
+		go archive.Run(ctx, historyPruneInterval)
+		collectorHandler.History = archive
+	}
+
+	// Cache the results of the checks to avoid connecting to a switch too
+	// often. The cached results are invalidated when the expected
+	// configuration changes, with ?refresh=true or through
+	// /v1/cache/invalidate.
+	resultCache := collector.NewCache(collector.CacheConfig{
+		Capacity:    *cacheCapacity,
+		MatchTTL:    *cacheTTL,
+		MismatchTTL: *cacheMismatchTTL,
+		ErrorTTL:    *cacheErrorTTL,
+		MaxBackoff:  *cacheMaxBackoff,
+	})
+	collectorHandler.Cache = resultCache
+	if *cachePollInterval > 0 {
+		resultCache.Version = collectorHandler.ConfigVersion
+		go resultCache.Run(ctx, *cachePollInterval)
+	}
+
+	stateHandler := state.NewHandler(drivers)
 
 	mux := http.NewServeMux()
-	mux.Handle("/v1/check", collectorHandler)
+	// handle registers a handler, reporting metrics about its requests.
+	handle := func(path string, h http.Handler) {
+		mux.Handle(path, metrics.Instrument(path, h))
+	}
+
+	// The credentials, the rules and the inventory are reloaded when their
+	// files change, on SIGHUP or through /v1/admin/reload.
+	reloader := reload.New()
+
+	// The site of a switch is looked up in the inventory first, then
+	// extracted from its hostname with the custom naming schemes and
+	// finally with the built-in ones.
+	var resolvers resolver.Chain
+	targets := staticTargets(schedulerTargets)
+	if *inventoryRefresh > 0 {
+		url := *siteinfoURL
+		if url == "" {
+			url = fmt.Sprintf(siteinfoURLFormat, *project, siteinfoVersion)
+		}
+		inv := inventory.New(httpProvider, url, switchHostFormat)
+		go inv.Run(ctx, *inventoryRefresh)
+		collectorHandler.Inventory = inv
+		collectorHandler.Targets = inv.Targets
+		stateHandler.Inventory = inv
+		drivers.Vendors = inv
+		reloader.Watch(reload.Component{
+			Name: "inventory",
+			// The inventory swaps the switches in itself.
+			Load: func(context.Context) (func(), error) {
+				return func() {}, inv.Refresh()
+			},
+		})
+		resolvers = append(resolvers, resolver.NewTable("inventory", inv))
+		if len(schedulerTargets) == 0 {
+			targets = inv.Targets
+		}
+		handle("/v1/targets", inv)
+	}
+
+	resolvers = append(resolvers, customResolvers()...)
+	collectorHandler.Resolver = append(resolvers, resolver.Default...)
+
+	// The files of the credentials are only known once they are loaded.
+	var credentialFiles []string
+	loadCredentials := func(ctx context.Context, loader credentials.Loader) (func(), error) {
+		if sites, ok := loader.(*credentials.Sites); ok {
+			sites.Resolver = collectorHandler.Resolver
+		}
+		loaded, err := loader.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			credentialFiles = nil
+			if *sshSiteCredentials != "" {
+				credentialFiles = append(credentialFiles, *sshSiteCredentials)
+			}
+			credentialFiles = append(credentialFiles, loader.Files()...)
+			auth.Store(loaded)
+		}, nil
+	}
+	// The switches cannot be checked without the credentials validated
+	// above, so they must be loaded at startup. Later, they are read again
+	// from the flags and the site credentials file.
+	swap, err := loadCredentials(ctx, loader)
+	if err != nil {
+		log.WithError(err).Error("Cannot load the SSH credentials")
+		osExit(1)
+	}
+	swap()
+	reloader.Watch(reload.Component{
+		Name:  "credentials",
+		Files: func() []string { return credentialFiles },
+		Load: func(ctx context.Context) (func(), error) {
+			loader, err := newCredentials()
+			if err != nil {
+				return nil, err
+			}
+			return loadCredentials(ctx, loader)
+		},
+	})
+	if *rulesFile != "" {
+		err := reloader.Add(ctx, reload.Component{
+			Name:  "rules",
+			Files: func() []string { return []string{*rulesFile} },
+			Load: func(context.Context) (func(), error) {
+				rules, err := netconf.LoadRules(*rulesFile)
+				if err != nil {
+					return nil, err
+				}
+				return func() { collectorHandler.SetRules(rules) }, nil
+			},
+		})
+		rtx.Must(err, "Cannot load rules file %s", *rulesFile)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go reloader.Run(ctx, *reloadInterval, hup)
+
+	handle("/v1/check", collectorHandler)
+	handle("/v1/check/batch", http.HandlerFunc(collectorHandler.ServeBatch))
+	// Reports are read by NOC staff, as JSON or as a web page.
+	handle("/v1/report", http.HandlerFunc(collectorHandler.ServeReport))
+	handle("/v1/cache/invalidate", requireToken(resultCache.ServeInvalidate))
+	// Diffs are requested by humans investigating a mismatch, so they are
+	// never cached.
+	handle("/v1/diff", http.HandlerFunc(collectorHandler.ServeDiff))
+	handle("/v1/state", stateHandler)
+	handle("/v1/admin/reload", requireToken(reloader.ServeReload))
+	if *remediationEnabled {
+		handle("/v1/remediate", requireToken(collectorHandler.ServeRemediate))
+	}
+	// The archived configurations are read from the switches as is,
+	// including their secrets.
+	if archive != nil {
+		handle("/v1/history", requireToken(archive.ServeHTTP))
+	}
+	// Accepting a host key trusts whoever answers for the switch, so it's
+	// only possible with the admin token.
+	if hostKeys != nil && *adminToken != "" {
+		handle("/v1/hostkeys/accept", requireToken(hostKeys.ServeAccept))
+	}
+
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+	if *schedulerInterval > 0 {
+		scheduler := collector.NewScheduler(collectorHandler,
+			collector.SchedulerConfig{
+				Interval:    *schedulerInterval,
+				Concurrency: *schedulerConcurrency,
+				Jitter:      *schedulerJitter,
+				Timeout:     *schedulerTimeout,
+				Targets:     targets,
+			})
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scheduler)
+		gatherers = append(gatherers, registry)
+		go scheduler.Run(ctx)
+	}
+	handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
 
 	s := makeHTTPServer(mux)
 
@@ -136,11 +581,129 @@ func main() {
 
 	// Keep serving until the context is canceled.
 	<-ctx.Done()
+
+	// Persist the host keys recorded in the background before exiting.
+	if hostKeys != nil {
+		flushCtx, cancel := context.WithTimeout(context.Background(), hostKeysFlushTimeout)
+		defer cancel()
+		if err := hostKeys.Flush(flushCtx); err != nil {
+			log.WithError(err).Warn("Cannot persist the known host keys")
+		}
+	}
+}
+
+// sshAgentClient is shared by the credentials returned by newCredentials,
+// so that reloading them does not open a new connection to ssh-agent.
+var sshAgentClient *credentials.Agent
+
+// newCredentials returns the SSH credentials configured with the ssh.*
+// flags.
+func newCredentials() (credentials.Loader, error) {
+	var store credentials.Store
+	if *sshSecretsDir != "" {
+		store = credentials.DirStore(*sshSecretsDir)
+	}
+	config := &credentials.Config{Username: *sshUsername}
+	if *sshAgent {
+		if sshAgentClient == nil {
+			sshAgentClient = credentials.NewAgent(os.Getenv("SSH_AUTH_SOCK"))
+		}
+		config.Agent = sshAgentClient
+	}
+
+	var err error
+	if *sshKey != "" {
+		var key credentials.Key
+		if key.Private, err = credentials.ParseSecret(*sshKey, store); err != nil {
+			return nil, err
+		}
+		switch {
+		case *sshPassphraseSecret != "":
+			key.Passphrase, err = credentials.ParseSecret(*sshPassphraseSecret, store)
+			if err != nil {
+				return nil, err
+			}
+		case *sshPassphrase != "":
+			key.Passphrase = credentials.Value(*sshPassphrase)
+		}
+		if *sshCertificate != "" {
+			key.Certificate, err = credentials.ParseSecret(*sshCertificate, store)
+			if err != nil {
+				return nil, err
+			}
+		}
+		config.Keys = append(config.Keys, key)
+	}
+	if *sshPassword != "" {
+		if config.Password, err = credentials.ParseSecret(*sshPassword, store); err != nil {
+			return nil, err
+		}
+	}
+	if len(config.Keys) == 0 && config.Agent == nil && config.Password == nil {
+		return nil, credentials.ErrNoMethod
+	}
+	if *sshSiteCredentials == "" {
+		return config, nil
+	}
+
+	sites, err := credentials.LoadSites(*sshSiteCredentials, config, store)
+	if err != nil {
+		return nil, err
+	}
+	return &credentials.Sites{Sites: sites, Default: config}, nil
+}
+
+// customResolvers returns the resolvers for the naming schemes configured
+// with the resolver.regexp and resolver.template flags.
+func customResolvers() resolver.Chain {
+	var resolvers resolver.Chain
+	for _, expr := range resolverRegexps {
+		r, err := resolver.NewRegexp(fmt.Sprintf("regexp %q", expr), expr)
+		rtx.Must(err, "Cannot create resolver")
+		resolvers = append(resolvers, r)
+	}
+	for _, template := range resolverTemplates {
+		r, err := resolver.NewTemplate(fmt.Sprintf("template %q", template), template)
+		rtx.Must(err, "Cannot create resolver")
+		resolvers = append(resolvers, r)
+	}
+	return resolvers
+}
+
+// staticTargets returns a function listing a fixed set of targets.
+func staticTargets(targets []string) func(context.Context) ([]string, error) {
+	return func(context.Context) ([]string, error) {
+		return targets, nil
+	}
+}
+
+// requireToken wraps an operator endpoint so that it can only be called with
+// the configured bearer token. If no token is configured, the endpoint is
+// disabled and always fails with 403.
+func requireToken(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *adminToken == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		want := "Bearer " + *adminToken
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	})
 }
 
 func makeHTTPServer(h http.Handler) *http.Server {
 	return &http.Server{
 		Addr:    *listenAddr,
 		Handler: h,
+		// Requests are canceled, together with any pending connection to a
+		// switch, when the program's context is canceled.
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
 }
@@ -1,16 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/m-lab/go/osx"
 	"github.com/m-lab/go/rtx"
 	"github.com/m-lab/switch-monitoring/internal"
-	"github.com/scottdware/go-junos"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+	ncfg "github.com/m-lab/switch-monitoring/internal/netconf"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,7 +37,7 @@ type mockNetconf struct {
 	configFile      string
 }
 
-func (n *mockNetconf) GetConfig(hostname string, section ...string) (string, error) {
+func (n *mockNetconf) GetConfig(ctx context.Context, hostname string, section ...string) (string, error) {
 	n.getConfigCalled++
 	if n.mustFail {
 		return "", fmt.Errorf("error")
@@ -35,6 +47,15 @@ func (n *mockNetconf) GetConfig(hostname string, section ...string) (string, err
 	return string(config), nil
 }
 
+type mockHTTPProvider struct{}
+
+func (mockHTTPProvider) Get(string) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(`{"abc01": {}}`)),
+	}, nil
+}
+
 //
 // Tests.
 //
@@ -46,7 +67,7 @@ func Test_main(t *testing.T) {
 	}
 
 	oldNewNetconf := newNetconf
-	newNetconf = func(auth *junos.AuthMethod) internal.NetconfClient {
+	newNetconf = func(vendor string, auth credentials.Provider, opts ...ncfg.Option) internal.NetconfClient {
 		return netconf
 	}
 
@@ -69,20 +90,138 @@ func Test_main(t *testing.T) {
 
 	restoreKey := osx.MustSetenv("SSH_KEY", "/path/to/key")
 	restorePort := osx.MustSetenv("LISTENADDR", ":0")
+	restoreRules := osx.MustSetenv("COLLECTOR_RULES", "testdata/rules.json")
 
-	go main()
+	// Remediation cannot be enabled without an admin token.
+	restoreRemediation := osx.MustSetenv("REMEDIATION_ENABLED", "true")
+	assert.PanicsWithValue("os.Exit called", main,
+		"os.Exit was not called")
+	restoreToken := osx.MustSetenv("ADMIN_TOKEN", "secret")
+
+	// With a host key policy other than insecure, main() fails if no known
+	// hosts file is provided.
+	restorePolicy := osx.MustSetenv("SSH_HOST_KEY_POLICY", "tofu")
+	assert.PanicsWithValue("os.Exit called", main,
+		"os.Exit was not called")
+
+	dir, err := ioutil.TempDir("", "switch-monitoring")
+	rtx.Must(err, "Cannot create temporary directory")
+	defer os.RemoveAll(dir)
+	restoreKnownHosts := osx.MustSetenv("SSH_KNOWN_HOSTS",
+		filepath.Join(dir, "known_hosts"))
+
+	// Expected configurations are read from a local git repository.
+	repo := filepath.Join(dir, "configs")
+	rtx.Must(os.Mkdir(repo, 0755), "Cannot create repository")
+	rtx.Must(ioutil.WriteFile(filepath.Join(repo, "abc01.conf"),
+		[]byte("system {}"), 0644), "Cannot write config")
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "--quiet", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		rtx.Must(cmd.Run(), "Cannot create repository")
+	}
+	restoreGitRepo := osx.MustSetenv("COLLECTOR_GIT_REPO", repo)
+
+	restoreHistory := osx.MustSetenv("HISTORY_DIR", filepath.Join(dir, "history"))
+	restoreInterval := osx.MustSetenv("SCHEDULER_INTERVAL", "1h")
+	restoreInventory := osx.MustSetenv("INVENTORY_REFRESH_INTERVAL", "1h")
+	restoreResolver := osx.MustSetenv("RESOLVER_TEMPLATE", "sw{index}.{site}.example.org")
+	restoreSiteCredentials := osx.MustSetenv("SSH_SITE_CREDENTIALS",
+		"testdata/credentials.json")
+	oldHTTPProvider := httpProvider
+	httpProvider = mockHTTPProvider{}
+
+	// main() fails if the credentials cannot be loaded, e.g. because the
+	// key file does not exist.
+	assert.PanicsWithValue("os.Exit called", main,
+		"os.Exit was not called")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtx.Must(err, "Cannot generate key")
+	der, err := x509.MarshalECPrivateKey(key)
+	rtx.Must(err, "Cannot marshal key")
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	rtx.Must(ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}),
+		0600), "Cannot write key")
+	restoreKeyFile := osx.MustSetenv("SSH_KEY", keyFile)
+	restorePassword := osx.MustSetenv("ABC01_PASSWORD", "secret")
+
+	done := make(chan struct{})
+	go func() {
+		main()
+		close(done)
+	}()
 
 	time.Sleep(500 * time.Millisecond)
 	cancel()
-
+	<-done
+
+	httpProvider = oldHTTPProvider
+	restorePassword()
+	restoreKeyFile()
+	restoreInventory()
+	restoreResolver()
+	restoreSiteCredentials()
+	restoreHistory()
+	restoreToken()
+	restoreRemediation()
+	restoreInterval()
+	restoreGitRepo()
+	restoreKnownHosts()
+	restorePolicy()
+	restoreRules()
 	restorePort()
 	restoreKey()
 	newNetconf = oldNewNetconf
 }
 
 func Test_newNetconf(t *testing.T) {
-	netconf := newNetconf(&junos.AuthMethod{})
-	if netconf == nil {
-		t.Errorf("newNetconf() returned nil.")
+	for vendor := range formats {
+		netconf := newNetconf(vendor, &credentials.Config{})
+		if netconf == nil {
+			t.Errorf("newNetconf(%s) returned nil.", vendor)
+		}
+		if c, ok := netconf.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
+
+func Test_requireToken(t *testing.T) {
+	h := requireToken(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{name: "no-token-configured", want: http.StatusForbidden},
+		{name: "no-token-configured-empty-header", header: "Bearer ", want: http.StatusForbidden},
+		{name: "valid-token", token: "secret", header: "Bearer secret", want: http.StatusOK},
+		{name: "invalid-token", token: "secret", header: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "missing-token", token: "secret", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*adminToken = tt.token
+			defer func() { *adminToken = "" }()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Errorf("requireToken() returned %d, want %d", rw.Code, tt.want)
+			}
+		})
 	}
 }
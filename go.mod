@@ -3,20 +3,21 @@ module github.com/m-lab/switch-monitoring
 go 1.20
 
 require (
+	cloud.google.com/go/storage v1.6.0
 	github.com/Juniper/go-netconf v0.1.1
 	github.com/apex/log v1.1.2
+	github.com/googleapis/google-cloud-go-testing v0.0.0-20191008195207-8e1d251e947d
 	github.com/kylelemons/godebug v1.1.0
 	github.com/m-lab/go v0.1.66
 	github.com/prometheus/client_golang v1.7.1
 	github.com/scottdware/go-junos v0.0.0-20191101184514-da1ec4631b03
 	github.com/stretchr/testify v1.5.1
-	github.com/victorspringer/http-cache v0.0.0-20190721184638-fe78e97af707
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	google.golang.org/api v0.22.0
 )
 
 require (
 	cloud.google.com/go v0.56.0 // indirect
-	cloud.google.com/go/storage v1.6.0 // indirect
 	github.com/BurntSushi/toml v0.3.1 // indirect
 	github.com/araddon/dateparse v0.0.0-20200409225146-d820a6159ab1 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
@@ -25,7 +26,6 @@ require (
 	github.com/golang/groupcache v0.0.0-20200121045136-8c9f03a8e57e // indirect
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/googleapis/gax-go/v2 v2.0.5 // indirect
-	github.com/googleapis/google-cloud-go-testing v0.0.0-20191008195207-8e1d251e947d // indirect
 	github.com/jstemmer/go-junit-report v0.9.1 // indirect
 	github.com/m-lab/uuid-annotator v0.4.1 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
@@ -45,7 +45,6 @@ require (
 	golang.org/x/text v0.3.2 // indirect
 	golang.org/x/tools v0.0.0-20200422205258-72e4a01eba43 // indirect
 	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
-	google.golang.org/api v0.22.0 // indirect
 	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/genproto v0.0.0-20200420144010-e5e8543f8aeb // indirect
 	google.golang.org/grpc v1.29.0 // indirect
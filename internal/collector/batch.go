@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// maxBatchRequestSize is the maximum size of the body of a batch request.
+const maxBatchRequestSize = 1 << 20
+
+// batchRequest is the JSON document accepted by the /check/batch endpoint.
+type batchRequest struct {
+	// Targets are the hostnames of the switches to check.
+	Targets []string `json:"targets"`
+	// Site is a glob matching the sites whose switches are checked, e.g.
+	// "lga*", as an alternative to Targets.
+	Site string `json:"site"`
+	// Refresh bypasses the Cache, if any.
+	Refresh bool `json:"refresh"`
+}
+
+// batchResult is the result of the check of a single target, streamed as a
+// line of the /check/batch response.
+type batchResult struct {
+	Target string `json:"target"`
+	// Status is the status of the whole configuration, e.g. "ok". It's
+	// empty if the target could not be checked.
+	Status string `json:"status,omitempty"`
+	Match  bool   `json:"match"`
+	// Time is when the target was checked.
+	Time *time.Time `json:"time,omitempty"`
+	// Cache is the result of the lookup in the Cache, if any.
+	Cache string `json:"cache,omitempty"`
+	// Error describes why the target could not be checked.
+	Error string `json:"error,omitempty"`
+}
+
+// ServeBatch handles POST requests to the /check/batch endpoint. The body is
+// a JSON object with either a "targets" list of hostnames or a "site" glob
+// matching the sites of the switches listed by Targets. The switches are
+// checked concurrently, up to BatchConcurrency at a time, within
+// BatchTimeout. The result of each check is written as a line of JSON as
+// soon as it's available.
+func (h *Handler) ServeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req batchRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBatchRequestSize)).Decode(&req)
+	if err != nil {
+		writeError(w, fmt.Errorf("invalid batch request: %w", err),
+			http.StatusBadRequest)
+		return
+	}
+	targets, status, err := h.batchTargets(r.Context(), req)
+	if err != nil {
+		writeError(w, err, status)
+		return
+	}
+
+	ctx := r.Context()
+	if h.BatchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.BatchTimeout)
+		defer cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(res batchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(res)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	concurrency := h.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		if ctx.Err() == nil {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		// The remaining targets are reported as not checked.
+		if ctx.Err() != nil {
+			write(batchResult{Target: t, Error: ctx.Err().Error()})
+			continue
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			write(h.batchCheck(ctx, target, req.Refresh))
+		}(t)
+	}
+	wg.Wait()
+}
+
+// batchTargets returns the deduplicated list of targets of a batch request.
+// If it fails, the returned status is the HTTP status code describing the
+// failure.
+func (h *Handler) batchTargets(ctx context.Context, req batchRequest) ([]string, int, error) {
+	switch {
+	case len(req.Targets) > 0 && req.Site != "":
+		return nil, http.StatusBadRequest,
+			errors.New("only one of targets and site can be provided")
+	case req.Site != "":
+		if _, err := path.Match(req.Site, ""); err != nil {
+			return nil, http.StatusBadRequest,
+				fmt.Errorf("invalid site glob %q: %w", req.Site, err)
+		}
+		if h.Targets == nil {
+			return nil, http.StatusNotImplemented,
+				errors.New("the list of switches is not available")
+		}
+		all, err := h.Targets(ctx)
+		if err != nil {
+			return nil, http.StatusServiceUnavailable, err
+		}
+		var targets []string
+		for _, t := range all {
+			site, err := h.site(t)
+			if err != nil {
+				continue
+			}
+			if ok, _ := path.Match(req.Site, site); ok {
+				targets = append(targets, t)
+			}
+		}
+		return targets, http.StatusOK, nil
+	case len(req.Targets) > 0:
+		seen := make(map[string]bool, len(req.Targets))
+		var targets []string
+		for _, t := range req.Targets {
+			if !seen[t] {
+				seen[t] = true
+				targets = append(targets, t)
+			}
+		}
+		return targets, http.StatusOK, nil
+	}
+	return nil, http.StatusBadRequest, errors.New("no targets provided")
+}
+
+// batchCheck checks a single target of a batch request.
+func (h *Handler) batchCheck(ctx context.Context, target string, refresh bool) batchResult {
+	result, lookup, err := h.check(ctx, target, refresh)
+	if err != nil {
+		log.WithField("target", target).WithError(err).Error(
+			"Cannot check switch")
+		return batchResult{Target: target, Cache: lookup, Error: err.Error()}
+	}
+	return batchResult{
+		Target: target,
+		Status: result.Status,
+		Match:  result.Status == configMatches,
+		Time:   &result.Time,
+		Cache:  lookup,
+	}
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestHandler_ServeBatch(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	rtx.Must(err, "Cannot get testdata path")
+	known := []string{"s1-abc01", "s1-abc02", "s1-xyz01"}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		targets  func(context.Context) ([]string, error)
+		canceled bool
+		status   int
+		want     []batchResult
+	}{
+		{
+			name:   "targets",
+			method: http.MethodPost,
+			body:   `{"targets": ["s1-abc01", "s1-xyz01", "invalid", "s1-abc01"]}`,
+			status: http.StatusOK,
+			want: []batchResult{
+				{Target: "invalid", Error: "cannot extract site from hostname: invalid (v2: no match; v1: no match)"},
+				{Target: "s1-abc01", Status: configMatches, Match: true},
+				{Target: "s1-xyz01", Status: configNotFoundGCS},
+			},
+		},
+		{
+			name:    "site",
+			method:  http.MethodPost,
+			body:    `{"site": "abc*"}`,
+			targets: func(context.Context) ([]string, error) { return known, nil },
+			status:  http.StatusOK,
+			want: []batchResult{
+				{Target: "s1-abc01", Status: configMatches, Match: true},
+				{Target: "s1-abc02", Status: configMismatch},
+			},
+		},
+		{
+			name:     "canceled",
+			method:   http.MethodPost,
+			body:     `{"targets": ["s1-abc01"]}`,
+			canceled: true,
+			status:   http.StatusOK,
+			want: []batchResult{
+				{Target: "s1-abc01", Error: context.Canceled.Error()},
+			},
+		},
+		{
+			name:   "method-not-allowed",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "invalid-json",
+			method: http.MethodPost,
+			body:   `{"targets": "s1-abc01"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no-targets",
+			method: http.MethodPost,
+			body:   `{}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "targets-and-site",
+			method: http.MethodPost,
+			body:   `{"targets": ["s1-abc01"], "site": "abc*"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:    "invalid-glob",
+			method:  http.MethodPost,
+			body:    `{"site": "[abc"}`,
+			targets: func(context.Context) ([]string, error) { return known, nil },
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:   "site-without-inventory",
+			method: http.MethodPost,
+			body:   `{"site": "abc*"}`,
+			status: http.StatusNotImplemented,
+		},
+		{
+			name:   "inventory-error",
+			method: http.MethodPost,
+			body:   `{"site": "abc*"}`,
+			targets: func(context.Context) ([]string, error) {
+				return nil, errors.New("inventory error")
+			},
+			status: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+			h.ConfigURL = "file://" + dir + "/"
+			h.Targets = tt.targets
+			h.BatchConcurrency = 2
+			h.BatchTimeout = time.Minute
+
+			r := httptest.NewRequest(tt.method, "/v1/check/batch",
+				strings.NewReader(tt.body))
+			if tt.canceled {
+				ctx, cancel := context.WithCancel(r.Context())
+				cancel()
+				r = r.WithContext(ctx)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeBatch(rr, r)
+			if rr.Code != tt.status {
+				t.Fatalf("ServeBatch() status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("ServeBatch() Content-Type = %q", ct)
+			}
+
+			var got []batchResult
+			scanner := bufio.NewScanner(rr.Body)
+			for scanner.Scan() {
+				var res batchResult
+				rtx.Must(json.Unmarshal(scanner.Bytes(), &res), "Cannot parse %s", scanner.Text())
+				// The check time is not predictable.
+				if res.Status != "" && res.Time == nil {
+					t.Errorf("ServeBatch() result without time: %s", scanner.Text())
+				}
+				res.Time = nil
+				got = append(got, res)
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].Target < got[j].Target })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServeBatch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
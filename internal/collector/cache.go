@@ -0,0 +1,278 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Headers added to the responses served through a Cache.
+const (
+	// CacheHeader is "HIT" if the response was served from the cache,
+	// "SHARED" if it was checked for a concurrent request and "MISS"
+	// otherwise.
+	CacheHeader = "X-Cache"
+	// CheckTimeHeader is the time the target was checked, in RFC 3339
+	// format.
+	CheckTimeHeader = "X-Check-Time"
+)
+
+// Results of a lookup in a Cache.
+const (
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+	cacheShared = "shared"
+)
+
+// unreachable are the statuses of the checks that could not reach the
+// switch, whose results are cached with an exponential backoff.
+var unreachable = map[string]bool{
+	"dns_failure" + switchStatusSuffix:        true,
+	"timeout" + switchStatusSuffix:            true,
+	"connection_failure" + switchStatusSuffix: true,
+}
+
+var (
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "switch_monitoring_cache_requests_total",
+		Help: "Number of cached requests, by whether they were served from the cache",
+	}, []string{"result"})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "switch_monitoring_cache_evictions_total",
+		Help: "Number of cached responses evicted to make room for new ones",
+	})
+	cacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "switch_monitoring_cache_entries",
+		Help: "Number of responses in the cache",
+	})
+)
+
+// CacheConfig configures how long the results of the checks are cached,
+// depending on their outcome.
+type CacheConfig struct {
+	// Capacity is the maximum number of targets whose result is cached.
+	Capacity int
+
+	// MatchTTL is how long a matching configuration is cached.
+	MatchTTL time.Duration
+
+	// MismatchTTL is how long a mismatching configuration is cached.
+	MismatchTTL time.Duration
+
+	// ErrorTTL is how long a failed check is cached. For switches that
+	// cannot be reached, it doubles after every consecutive failure, up to
+	// MaxBackoff.
+	ErrorTTL time.Duration
+
+	// MaxBackoff is the maximum time a switch that cannot be reached is
+	// not checked again. If zero, the backoff is not limited.
+	MaxBackoff time.Duration
+}
+
+// Result is the outcome of the check of a single target.
+type Result struct {
+	// Metrics are the metrics collected by the check.
+	Metrics []prometheus.Metric
+	// Status is the status of the whole configuration, e.g. "ok".
+	Status string
+	// Time is when the check started.
+	Time time.Time
+	// Error describes why the configurations could not be compared, if
+	// the check failed.
+	Error string
+	// Diff is the difference between the expected and the actual
+	// configuration, if they don't match.
+	Diff *netconf.Diff
+}
+
+// Cache keeps the result of the last check of each target for a time that
+// depends on its outcome. Concurrent checks of the same target are
+// deduplicated.
+//
+// When the cache is full, expired results are evicted first. Otherwise, the
+// most recently used result is evicted: if there are more switches than the
+// cache can hold, capacity - 1 results are still reused, while evicting the
+// least recently used ones would make every check reach the switch.
+type Cache struct {
+	// Version, if set, returns the current version of the expected
+	// configuration of a target. It's read before every check, and Run
+	// polls it for every cached target.
+	Version func(ctx context.Context, target string) (string, error)
+
+	config CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	calls   map[string]*cacheCall
+
+	now func() time.Time
+}
+
+// cacheEntry is the state of a cached target.
+type cacheEntry struct {
+	// result is the last result, or nil if it was invalidated.
+	result     *Result
+	expires    time.Time
+	lastAccess time.Time
+	// failures is the number of consecutive checks that could not reach
+	// the switch.
+	failures int
+	// version is the version of the expected configuration when the
+	// result was checked, or empty if it's unknown.
+	version string
+}
+
+// cacheCall is a check in progress, whose result is shared with all the
+// concurrent requests for the same target.
+type cacheCall struct {
+	done   chan struct{}
+	result *Result
+	err    error
+}
+
+// NewCache returns an empty Cache with the specified configuration.
+func NewCache(config CacheConfig) *Cache {
+	if config.Capacity < 1 {
+		config.Capacity = 1
+	}
+	return &Cache{
+		config:  config,
+		entries: map[string]*cacheEntry{},
+		calls:   map[string]*cacheCall{},
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached result for the target, unless it has expired or
+// refresh is true. Otherwise, it calls check, sharing the result with the
+// concurrent calls for the same target, and caches it. The returned string
+// tells whether the result was a cache hit, miss or shared. Errors returned
+// by check are not cached.
+func (c *Cache) Get(ctx context.Context, target string, refresh bool,
+	check func(context.Context) (*Result, error)) (*Result, string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[target]
+	if ok && !refresh && e.result != nil && c.now().Before(e.expires) {
+		e.lastAccess = c.now()
+		c.mu.Unlock()
+		cacheRequests.WithLabelValues(cacheHit).Inc()
+		return e.result, cacheHit, nil
+	}
+
+	if call, ok := c.calls[target]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, cacheShared, ctx.Err()
+		}
+		cacheRequests.WithLabelValues(cacheShared).Inc()
+		return call.result, cacheShared, call.err
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[target] = call
+	c.mu.Unlock()
+
+	// The version is read before checking, so that any change made during
+	// the check invalidates the result.
+	version := c.version(ctx, target)
+	call.result, call.err = check(ctx)
+
+	c.mu.Lock()
+	delete(c.calls, target)
+	// A check interrupted by the caller does not describe the switch.
+	if call.err == nil && ctx.Err() == nil {
+		c.store(target, call.result, version)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	cacheRequests.WithLabelValues(cacheMiss).Inc()
+	return call.result, cacheMiss, call.err
+}
+
+// Results returns the last result of every cached target, including the
+// expired ones.
+func (c *Cache) Results() map[string]*Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make(map[string]*Result, len(c.entries))
+	for target, e := range c.entries {
+		if e.result != nil {
+			results[target] = e.result
+		}
+	}
+	return results
+}
+
+// store caches the result of a check of the specified version of the
+// expected configuration. c.mu must be held.
+func (c *Cache) store(target string, r *Result, version string) {
+	e, ok := c.entries[target]
+	if !ok {
+		c.makeRoom()
+		e = &cacheEntry{}
+		c.entries[target] = e
+	}
+	e.result = r
+	e.version = version
+	e.lastAccess = c.now()
+	e.expires = c.now().Add(c.ttl(e, r.Status))
+	cacheEntries.Set(float64(len(c.entries)))
+}
+
+// ttl returns how long a result with the specified status is cached, and
+// updates the number of consecutive failures of the entry.
+func (c *Cache) ttl(e *cacheEntry, status string) time.Duration {
+	switch {
+	case status == configMatches:
+		e.failures = 0
+		return c.config.MatchTTL
+	case status == configMismatch:
+		e.failures = 0
+		return c.config.MismatchTTL
+	case !unreachable[status]:
+		e.failures = 0
+		return c.config.ErrorTTL
+	}
+
+	ttl := c.config.ErrorTTL
+	for i := 0; i < e.failures && (c.config.MaxBackoff == 0 || ttl < c.config.MaxBackoff); i++ {
+		ttl *= 2
+	}
+	if c.config.MaxBackoff > 0 && ttl > c.config.MaxBackoff {
+		ttl = c.config.MaxBackoff
+	}
+	e.failures++
+	return ttl
+}
+
+// makeRoom removes the expired entries if the cache is full. If it's still
+// full, it evicts the most recently used entry. c.mu must be held.
+func (c *Cache) makeRoom() {
+	if len(c.entries) < c.config.Capacity {
+		return
+	}
+	for target, e := range c.entries {
+		if e.result == nil || !c.now().Before(e.expires) {
+			delete(c.entries, target)
+		}
+	}
+	if len(c.entries) < c.config.Capacity {
+		return
+	}
+
+	var mru string
+	var last time.Time
+	for target, e := range c.entries {
+		if mru == "" || e.lastAccess.After(last) {
+			mru, last = target, e.lastAccess
+		}
+	}
+	delete(c.entries, mru)
+	cacheEvictions.Inc()
+}
@@ -0,0 +1,249 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeCheck returns a check function reporting the provided statuses in
+// order, counting how many times it's called.
+type fakeCheck struct {
+	statuses []string
+	calls    int
+}
+
+func (f *fakeCheck) check(context.Context) (*Result, error) {
+	status := f.statuses[f.calls%len(f.statuses)]
+	f.calls++
+	return &Result{Status: status}, nil
+}
+
+func TestCache_Get(t *testing.T) {
+	const target = "s1-abc01.measurement-lab.org"
+	config := CacheConfig{
+		Capacity:    10,
+		MatchTTL:    time.Hour,
+		MismatchTTL: 10 * time.Minute,
+		ErrorTTL:    time.Minute,
+		MaxBackoff:  5 * time.Minute,
+	}
+
+	// Each step advances the clock and checks whether the result is served
+	// from the cache.
+	type step struct {
+		after   time.Duration
+		refresh bool
+		want    string
+	}
+	tests := []struct {
+		name     string
+		statuses []string
+		steps    []step
+	}{
+		{
+			name:     "match",
+			statuses: []string{configMatches},
+			steps: []step{
+				{want: cacheMiss},
+				{after: 59 * time.Minute, want: cacheHit},
+				{after: time.Minute, want: cacheMiss},
+				{refresh: true, want: cacheMiss},
+				{want: cacheHit},
+			},
+		},
+		{
+			name:     "mismatch",
+			statuses: []string{configMismatch},
+			steps: []step{
+				{want: cacheMiss},
+				{after: 9 * time.Minute, want: cacheHit},
+				{after: time.Minute, want: cacheMiss},
+			},
+		},
+		{
+			name:     "error",
+			statuses: []string{"auth_failure_switch"},
+			steps: []step{
+				{want: cacheMiss},
+				{after: 59 * time.Second, want: cacheHit},
+				{after: time.Second, want: cacheMiss},
+				// Errors other than unreachable switches are not backed
+				// off.
+				{after: time.Minute, want: cacheMiss},
+			},
+		},
+		{
+			name:     "unreachable-backoff",
+			statuses: []string{"timeout_switch"},
+			steps: []step{
+				{want: cacheMiss},
+				{after: time.Minute, want: cacheMiss},
+				{after: time.Minute, want: cacheHit},
+				{after: time.Minute, want: cacheMiss},
+				{after: 3 * time.Minute, want: cacheHit},
+				{after: time.Minute, want: cacheMiss},
+				// The backoff is capped at MaxBackoff.
+				{after: 5 * time.Minute, want: cacheMiss},
+			},
+		},
+		{
+			name:     "backoff-reset",
+			statuses: []string{"timeout_switch", "timeout_switch", configMatches, "timeout_switch"},
+			steps: []step{
+				{want: cacheMiss},
+				{after: time.Minute, want: cacheMiss},
+				{after: 2 * time.Minute, want: cacheMiss},
+				{refresh: true, want: cacheMiss},
+				{after: time.Minute, want: cacheMiss},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			c := NewCache(config)
+			c.now = func() time.Time { return now }
+			f := &fakeCheck{statuses: tt.statuses}
+
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				_, got, err := c.Get(context.Background(), target, s.refresh, f.check)
+				if err != nil {
+					t.Fatalf("step %d: Get() returned error: %v", i, err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: Get() = %s, want %s", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_GetNotCached(t *testing.T) {
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	calls := 0
+
+	// Errors are not cached.
+	failing := func(context.Context) (*Result, error) {
+		calls++
+		return nil, errors.New("invalid target")
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Get(context.Background(), "target", false, failing); err == nil {
+			t.Errorf("Get() didn't return the expected error")
+		}
+	}
+
+	// Neither are the checks interrupted by the caller.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := func(context.Context) (*Result, error) {
+		calls++
+		cancel()
+		return &Result{Status: "timeout_switch"}, nil
+	}
+	c.Get(ctx, "target", false, canceled)
+	f := &fakeCheck{statuses: []string{configMatches}}
+	if _, got, _ := c.Get(context.Background(), "target", false, f.check); got != cacheMiss {
+		t.Errorf("Get() = %s, want %s", got, cacheMiss)
+	}
+	if calls != 3 {
+		t.Errorf("check called %d times, want 3", calls)
+	}
+}
+
+func TestCache_GetShared(t *testing.T) {
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := 0
+	check := func(context.Context) (*Result, error) {
+		calls++
+		close(started)
+		<-release
+		return &Result{Status: configMatches}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, results[0], _ = c.Get(context.Background(), "target", false, check)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i], _ = c.Get(context.Background(), "target", true, check)
+		}(i)
+	}
+	// Give the other requests time to join the check in progress.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("check called %d times, want 1", calls)
+	}
+	if results[0] != cacheMiss {
+		t.Errorf("first Get() = %s, want %s", results[0], cacheMiss)
+	}
+	for _, r := range results[1:] {
+		if r != cacheShared {
+			t.Errorf("concurrent Get() = %s, want %s", r, cacheShared)
+		}
+	}
+
+	// A request giving up waits no longer for the check in progress.
+	c.calls["target"] = &cacheCall{done: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := c.Get(ctx, "target", true, check); err != context.Canceled {
+		t.Errorf("Get() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCache_makeRoom(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache(CacheConfig{Capacity: 3, MatchTTL: time.Hour, ErrorTTL: time.Minute})
+	c.now = func() time.Time { return now }
+	get := func(target, status string) {
+		f := &fakeCheck{statuses: []string{status}}
+		c.Get(context.Background(), target, false, f.check)
+		now = now.Add(time.Second)
+	}
+
+	evictions := testutil.ToFloat64(cacheEvictions)
+	get("a", configMatches)
+	get("b", "timeout_switch")
+	get("c", configMatches)
+	get("a", configMatches)
+
+	// b has expired, so it's removed without evicting anything.
+	now = now.Add(time.Minute)
+	get("d", configMatches)
+	if _, ok := c.entries["b"]; ok {
+		t.Errorf("expired entry was not removed")
+	}
+	if v := testutil.ToFloat64(cacheEvictions) - evictions; v != 0 {
+		t.Errorf("evictions = %v, want 0", v)
+	}
+
+	// d is the most recently used entry.
+	get("e", configMatches)
+	if _, ok := c.entries["d"]; ok {
+		t.Errorf("most recently used entry was not evicted")
+	}
+	if v := testutil.ToFloat64(cacheEvictions) - evictions; v != 1 {
+		t.Errorf("evictions = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(cacheEntries); v != 3 {
+		t.Errorf("entries = %v, want 3", v)
+	}
+}
@@ -2,6 +2,8 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/m-lab/go/content"
@@ -10,6 +12,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Phases of a check, as reported by
+// switch_monitoring_check_duration_seconds.
+const (
+	phaseGCSFetch = "gcs_fetch"
+	phaseConnect  = "ssh_connect"
+	phaseRPC      = "rpc"
+	phaseCompare  = "compare"
+)
+
 const (
 	configNotFoundGCS    = "config_not_found_gcs"
 	configNotFoundSwitch = "config_not_found_switch"
@@ -21,58 +32,316 @@ type Config struct {
 	ProjectID string
 	Provider  content.Provider
 	Netconf   internal.NetconfClient
+
+	// Sections is the list of top-level configuration sections (e.g.
+	// "interfaces") to check individually, in addition to the whole
+	// configuration.
+	Sections []string
+
+	// Rules are the normalization rules applied before comparing the
+	// configurations. If nil, the default rules are applied.
+	Rules *netconf.Rules
+
+	// Format is the syntax of the configurations, as read by Netconf. The
+	// zero value is the JunOS text format.
+	Format netconf.Format
+
+	// History, if set, archives the result of every comparison.
+	History Recorder
+
+	// Commits, if set, reads the commit history of the switch, so that
+	// changes can be attributed to the user who made them.
+	Commits CommitLister
 }
 
+// CommitLister reads the commit history of a switch.
+type CommitLister interface {
+	// Commits returns the commits made on the switch, most recent first.
+	Commits(ctx context.Context, hostname string) ([]netconf.Commit, error)
+}
+
+// Recorder archives the configurations read from the switches and whether
+// they matched the expected ones.
+type Recorder interface {
+	Record(target, config string, match bool) error
+}
+
+// ConfigCheckerCollector checks the configuration of a single target. Since
+// the prometheus.Collector interface does not support contexts, the context
+// for every call to the switch is provided when creating the collector,
+// which is meant to be used for a single request.
 type ConfigCheckerCollector struct {
-	target string
-	config Config
-	result *prometheus.Desc
+	ctx              context.Context
+	target           string
+	config           Config
+	result           *prometheus.Desc
+	sectionResult    *prometheus.Desc
+	sectionDiffLines *prometheus.Desc
+	lastCommit       *prometheus.Desc
+	duration         *prometheus.Desc
+	lastCheck        *prometheus.Desc
+
+	// status is the status of the last check made by Collect.
+	status string
+	// err is the error that prevented the last check from comparing the
+	// configurations, if any.
+	err error
+	// diff is the difference found by the last check, if the configurations
+	// don't match.
+	diff *netconf.Diff
+	// counted is true once a failure of the current check was counted.
+	counted bool
+
+	now func() time.Time
 }
 
-func New(target string, config Config) *ConfigCheckerCollector {
+// phases maps the phases of a check to their duration.
+type phases map[string]time.Duration
+
+func New(ctx context.Context, target string, config Config) *ConfigCheckerCollector {
 	return &ConfigCheckerCollector{
+		ctx:    ctx,
 		target: target,
 		config: config,
 		result: prometheus.NewDesc("switch_monitoring_config_match",
 			"Configuration check result for this target",
 			[]string{"target", "status"}, nil),
+		sectionResult: prometheus.NewDesc("switch_monitoring_section_match",
+			"Configuration check result for a section of this target",
+			[]string{"target", "section", "status"}, nil),
+		sectionDiffLines: prometheus.NewDesc(
+			"switch_monitoring_section_diff_lines",
+			"Number of differing lines in a section of this target",
+			[]string{"target", "section"}, nil),
+		lastCommit: prometheus.NewDesc(
+			"switch_monitoring_last_commit_timestamp_seconds",
+			"Time of the most recent commit on this target",
+			[]string{"target", "user", "client"}, nil),
+		duration: prometheus.NewDesc(
+			"switch_monitoring_check_duration_seconds",
+			"Duration of each phase of the configuration check of this target",
+			[]string{"target", "phase"}, nil),
+		lastCheck: prometheus.NewDesc(
+			"switch_monitoring_last_check_timestamp_seconds",
+			"Time when this target was checked",
+			[]string{"target"}, nil),
+		now: time.Now,
 	}
 }
 
 func (c *ConfigCheckerCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.result
+	ch <- c.sectionResult
+	ch <- c.sectionDiffLines
+	ch <- c.lastCommit
+	ch <- c.duration
+	ch <- c.lastCheck
 }
 
 func (c *ConfigCheckerCollector) Collect(ch chan<- prometheus.Metric) {
-	// Fetch the latest config from GCS for this target.
-	expected, err := c.config.Provider.Get(context.Background())
+	start := c.now()
+	c.counted = false
+	c.diff = nil
+	d := phases{}
+	expected, actual, status := c.fetch(d)
+	if status == configMatches {
+		compareStart := c.now()
+		match := c.config.Format.Compare(expected, actual, c.config.Rules)
+		d[phaseCompare] = c.now().Sub(compareStart)
+		if !match {
+			log.WithFields(log.Fields{"target": c.target}).Warn(
+				"Switch configuration is different than the archived one.")
+			status = configMismatch
+			c.diff = c.config.Format.Diff(expected, actual, c.config.Rules)
+		}
+	}
+	if status == configMatches || status == configMismatch {
+		c.record(actual, status == configMatches)
+		c.collectLastCommit(ch)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.result, prometheus.GaugeValue, 1,
+		c.target, status)
+	ch <- prometheus.MustNewConstMetric(c.lastCheck, prometheus.GaugeValue,
+		float64(start.UnixNano())/float64(time.Second), c.target)
+	for phase, duration := range d {
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.GaugeValue,
+			duration.Seconds(), c.target, phase)
+	}
+
+	for _, section := range c.config.Sections {
+		c.collectSection(ch, section, expected, status)
+	}
+	c.status = status
+}
+
+// Status returns the status of the whole configuration found by the last
+// call to Collect, e.g. "ok" or "timeout_switch".
+func (c *ConfigCheckerCollector) Status() string {
+	return c.status
+}
+
+// Err returns the error that prevented the last call to Collect from
+// comparing the configurations, if any.
+func (c *ConfigCheckerCollector) Err() error {
+	return c.err
+}
+
+// Mismatch returns the differences found by the last call to Collect, or nil
+// if the configurations matched or could not be compared.
+func (c *ConfigCheckerCollector) Mismatch() *netconf.Diff {
+	return c.diff
+}
+
+// record archives the result of the comparison, if a Recorder is configured.
+// Failures are logged but do not affect the check.
+func (c *ConfigCheckerCollector) record(actual string, match bool) {
+	if c.config.History == nil {
+		return
+	}
+	if err := c.config.History.Record(c.target, actual, match); err != nil {
+		log.WithFields(log.Fields{"target": c.target}).WithError(err).Error(
+			"Cannot archive the check result")
+	}
+}
+
+// collectLastCommit reports the most recent commit on the switch, if a
+// CommitLister is configured.
+func (c *ConfigCheckerCollector) collectLastCommit(ch chan<- prometheus.Metric) {
+	commits, err := c.Commits(1)
 	if err != nil {
 		log.WithFields(log.Fields{"target": c.target}).WithError(err).Error(
-			"Cannot fetch latest config from GCS")
-		ch <- prometheus.MustNewConstMetric(c.result, prometheus.GaugeValue, 1,
-			c.target, configNotFoundGCS)
+			"Cannot fetch the commit history from the switch")
+		return
+	}
+	if len(commits) == 0 {
 		return
 	}
+	last := commits[0]
+	ch <- prometheus.MustNewConstMetric(c.lastCommit, prometheus.GaugeValue,
+		float64(last.Time.Unix()), c.target, last.User, last.Client)
+}
+
+// Commits returns up to n of the most recent commits on the switch. It
+// returns no commits if no CommitLister is configured.
+func (c *ConfigCheckerCollector) Commits(n int) ([]netconf.Commit, error) {
+	if c.config.Commits == nil {
+		return nil, nil
+	}
+	commits, err := c.config.Commits.Commits(c.ctx, c.target)
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) > n {
+		commits = commits[:n]
+	}
+	return commits, nil
+}
+
+// collectSection fetches a single section of the configuration from the
+// switch and compares it with the same section of the expected
+// configuration. If the whole configuration could not be fetched, the
+// section is not fetched either and the same status is reported.
+func (c *ConfigCheckerCollector) collectSection(ch chan<- prometheus.Metric,
+	section, expected, status string) {
+	if status == configMatches || status == configMismatch {
+		var actual string
+		actual, status = c.fetchSection(section)
+		if status == configMatches {
+			d := c.config.Format.SectionDiff(expected, actual, section,
+				c.config.Rules)
+			if !d.Empty() {
+				log.WithFields(log.Fields{
+					"target":  c.target,
+					"section": section,
+				}).Warn("Switch configuration section is different than the archived one.")
+				status = configMismatch
+			}
+			ch <- prometheus.MustNewConstMetric(c.sectionDiffLines,
+				prometheus.GaugeValue, float64(d.Lines()), c.target, section)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.sectionResult, prometheus.GaugeValue,
+		1, c.target, section, status)
+}
+
+// Diff fetches the expected and the actual configuration for this target and
+// returns the differences between them.
+func (c *ConfigCheckerCollector) Diff() (*netconf.Diff, error) {
+	c.counted = false
+	expected, actual, status := c.fetch(phases{})
+	if status != configMatches {
+		return nil, fmt.Errorf("cannot compare configurations: %s", status)
+	}
+	return c.config.Format.Diff(expected, actual, c.config.Rules), nil
+}
+
+// fetch reads the expected configuration from GCS and the actual
+// configuration from the switch, recording the duration of each phase in d.
+// If any of these fails, the returned status describes the failure.
+// Otherwise, it is configMatches.
+func (c *ConfigCheckerCollector) fetch(d phases) (string, string, string) {
+	// Fetch the latest config from GCS for this target.
+	start := c.now()
+	expected, err := c.config.Provider.Get(c.ctx)
+	d[phaseGCSFetch] = c.now().Sub(start)
+	if err != nil {
+		c.err = err
+		status := c.failed(providerStatus(err))
+		log.WithFields(log.Fields{
+			"target": c.target,
+			"status": status,
+		}).WithError(err).Error("Cannot fetch latest config from GCS")
+		return "", "", status
+	}
 
 	// Fetch the actual config from the switch.
-	actual, err := c.config.Netconf.GetConfig(c.target)
+	var t netconf.Timing
+	actual, err := c.config.Netconf.GetConfig(netconf.WithTiming(c.ctx, &t),
+		c.target)
+	// Clients that do not support netconf.Timing do not report these
+	// phases.
+	if t != (netconf.Timing{}) {
+		d[phaseConnect] = t.Connect
+		d[phaseRPC] = t.RPC
+	}
 	if err != nil {
-		log.WithFields(log.Fields{"target": c.target}).WithError(err).Error(
-			"Cannot fetch config from the switch")
-		ch <- prometheus.MustNewConstMetric(c.result, prometheus.GaugeValue, 1,
-			c.target, configNotFoundSwitch)
-		return
+		c.err = err
+		status := c.failed(switchStatus(err))
+		log.WithFields(log.Fields{
+			"target": c.target,
+			"status": status,
+		}).WithError(err).Error("Cannot fetch config from the switch")
+		return "", "", status
 	}
 
-	// Compare them.
-	if !netconf.Compare(string(expected), actual) {
-		log.WithFields(log.Fields{"target": c.target}).Warn(
-			"Switch configuration is different than the archived one.")
-		ch <- prometheus.MustNewConstMetric(c.result, prometheus.GaugeValue, 1,
-			c.target, configMismatch)
-		return
+	return string(expected), actual, configMatches
+}
+
+// fetchSection reads a single section of the actual configuration from the
+// switch.
+func (c *ConfigCheckerCollector) fetchSection(section string) (string, string) {
+	actual, err := c.config.Netconf.GetConfig(c.ctx, c.target, section)
+	if err != nil {
+		status := c.failed(switchStatus(err))
+		log.WithFields(log.Fields{
+			"target":  c.target,
+			"section": section,
+			"status":  status,
+		}).WithError(err).Error("Cannot fetch config section from the switch")
+		return "", status
 	}
+	return actual, configMatches
+}
 
-	ch <- prometheus.MustNewConstMetric(c.result, prometheus.GaugeValue, 1,
-		c.target, configMatches)
+// failed counts a failure to read a configuration and returns its status.
+// Only the first failure of a check is counted, e.g. so that a switch that
+// becomes unreachable while its sections are read counts once.
+func (c *ConfigCheckerCollector) failed(status string) string {
+	if !c.counted {
+		checkErrors.WithLabelValues(c.target, status).Inc()
+		c.counted = true
+	}
+	return status
 }
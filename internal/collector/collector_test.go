@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/m-lab/go/rtx"
+	ncfg "github.com/m-lab/switch-monitoring/internal/netconf"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
@@ -31,7 +34,7 @@ type netconfProvider struct {
 	fail     bool
 }
 
-func (n *netconfProvider) GetConfig(hostname string, sections ...string) (string, error) {
+func (n *netconfProvider) GetConfig(ctx context.Context, hostname string, sections ...string) (string, error) {
 	if n.fail {
 		return "", fmt.Errorf("GetConfig error")
 	}
@@ -41,7 +44,7 @@ func (n *netconfProvider) GetConfig(hostname string, sections ...string) (string
 }
 
 func TestNew(t *testing.T) {
-	if New("s1.abc01.measurement-lab.org", Config{
+	if New(context.Background(), "s1.abc01.measurement-lab.org", Config{
 		ProjectID: "test",
 		Netconf:   nil,
 		Provider:  nil,
@@ -68,35 +71,47 @@ func TestConfigCheckerCollector_Collect(t *testing.T) {
 		Provider:  provider,
 	}
 
-	collector := New("s1.abc01.measurement-lab.org", config)
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", config)
 
 	expected := metadata + `
 switch_monitoring_config_match{status="ok",target="s1.abc01.measurement-lab.org"} 1
 `
-	err := testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_config_match")
 	if err != nil {
 		t.Errorf("Collect() returned err: %v", err)
 	}
+	if collector.Mismatch() != nil {
+		t.Errorf("Mismatch() = %v, want nil", collector.Mismatch())
+	}
 
 	// Compare two different configs.
 	expected = metadata + `
 switch_monitoring_config_match{status="config_mismatch",target="s1.abc01.measurement-lab.org"} 1
 `
 	provider.filepath = "testdata/abc02.conf"
-	err = testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_config_match")
 	if err != nil {
 		t.Errorf("Collect() returned err: %v", err)
 	}
+	if d := collector.Mismatch(); d == nil || d.Empty() {
+		t.Errorf("Mismatch() did not return any differences")
+	}
 
 	// Make the content provider fail.
 	expected = metadata + `
 switch_monitoring_config_match{status="config_not_found_gcs",target="s1.abc01.measurement-lab.org"} 1
 `
 	provider.fail = true
-	err = testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_config_match")
 	if err != nil {
 		t.Errorf("Collect() returned err: %v", err)
 	}
+	if collector.Mismatch() != nil {
+		t.Errorf("Mismatch() = %v, want nil", collector.Mismatch())
+	}
 	provider.fail = false
 
 	// Make netconf fail.
@@ -104,9 +119,257 @@ switch_monitoring_config_match{status="config_not_found_gcs",target="s1.abc01.me
 switch_monitoring_config_match{status="config_not_found_switch",target="s1.abc01.measurement-lab.org"} 1
 `
 	netconf.fail = true
-	err = testutil.CollectAndCompare(collector, strings.NewReader(expected))
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_config_match")
 	if err != nil {
 		t.Errorf("Collect() returned err: %v", err)
 	}
 	netconf.fail = false
 }
+
+// mockRecorder records the results in memory.
+type mockRecorder struct {
+	matches []bool
+	err     error
+}
+
+func (r *mockRecorder) Record(target, config string, match bool) error {
+	r.matches = append(r.matches, match)
+	return r.err
+}
+
+func TestConfigCheckerCollector_History(t *testing.T) {
+	netconf := &netconfProvider{filepath: "testdata/abc01.conf"}
+	provider := &contentProvider{filepath: "testdata/abc01.conf"}
+	recorder := &mockRecorder{}
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", Config{
+		ProjectID: "test",
+		Netconf:   netconf,
+		Provider:  provider,
+		History:   recorder,
+	})
+
+	testutil.CollectAndCount(collector)
+	provider.filepath = "testdata/abc02.conf"
+	testutil.CollectAndCount(collector)
+
+	// Failed fetches are not comparisons and are not recorded.
+	netconf.fail = true
+	testutil.CollectAndCount(collector)
+
+	// Errors while recording do not affect the check.
+	netconf.fail = false
+	recorder.err = fmt.Errorf("disk full")
+	if n := testutil.CollectAndCount(collector, "switch_monitoring_config_match"); n != 1 {
+		t.Errorf("Collect() returned %d metrics, want 1", n)
+	}
+
+	want := []bool{true, false, false}
+	if !reflect.DeepEqual(recorder.matches, want) {
+		t.Errorf("Record() called with %v, want %v", recorder.matches, want)
+	}
+}
+
+// mockCommitLister returns a fixed commit history.
+type mockCommitLister struct {
+	commits []ncfg.Commit
+	err     error
+}
+
+func (l *mockCommitLister) Commits(context.Context, string) ([]ncfg.Commit, error) {
+	return l.commits, l.err
+}
+
+func TestConfigCheckerCollector_Commits(t *testing.T) {
+	lister := &mockCommitLister{commits: []ncfg.Commit{
+		{Sequence: 0, User: "alice", Client: "cli", Time: time.Unix(1600086400, 0)},
+		{Sequence: 1, User: "root", Client: "netconf", Time: time.Unix(1600000000, 0)},
+	}}
+	provider := &contentProvider{filepath: "testdata/abc01.conf"}
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", Config{
+		ProjectID: "test",
+		Netconf:   &netconfProvider{filepath: "testdata/abc01.conf"},
+		Provider:  provider,
+		Commits:   lister,
+	})
+
+	expected := `
+# HELP switch_monitoring_last_commit_timestamp_seconds Time of the most recent commit on this target
+# TYPE switch_monitoring_last_commit_timestamp_seconds gauge
+switch_monitoring_last_commit_timestamp_seconds{client="cli",target="s1.abc01.measurement-lab.org",user="alice"} 1.6000864e+09
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_last_commit_timestamp_seconds")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+
+	if commits, err := collector.Commits(1); len(commits) != 1 || err != nil {
+		t.Errorf("Commits(1) = %v, %v, want 1 commit", commits, err)
+	}
+	if commits, err := collector.Commits(5); len(commits) != 2 || err != nil {
+		t.Errorf("Commits(5) = %v, %v, want 2 commits", commits, err)
+	}
+
+	// The commit history is not reported if it cannot be read, or if the
+	// configuration could not be compared.
+	for _, setup := range []func(){
+		func() { lister.err = fmt.Errorf("error") },
+		func() { lister.commits = nil; lister.err = nil },
+		func() { lister.err = nil; provider.fail = true },
+	} {
+		setup()
+		n := testutil.CollectAndCount(collector,
+			"switch_monitoring_last_commit_timestamp_seconds")
+		if n != 0 {
+			t.Errorf("Collect() returned %d commit metrics, want 0", n)
+		}
+	}
+
+	// Without a CommitLister, there are no commits.
+	collector.config.Commits = nil
+	if commits, err := collector.Commits(1); commits != nil || err != nil {
+		t.Errorf("Commits() = %v, %v, want nil", commits, err)
+	}
+}
+
+func TestConfigCheckerCollector_Durations(t *testing.T) {
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", Config{
+		ProjectID: "test",
+		Netconf:   &netconfProvider{filepath: "testdata/abc01.conf"},
+		Provider:  &contentProvider{filepath: "testdata/abc01.conf"},
+	})
+	// Every reading of the clock advances it by one second.
+	now := time.Unix(1600000000, 0)
+	collector.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	expected := `
+# HELP switch_monitoring_check_duration_seconds Duration of each phase of the configuration check of this target
+# TYPE switch_monitoring_check_duration_seconds gauge
+switch_monitoring_check_duration_seconds{phase="compare",target="s1.abc01.measurement-lab.org"} 1
+switch_monitoring_check_duration_seconds{phase="gcs_fetch",target="s1.abc01.measurement-lab.org"} 1
+# HELP switch_monitoring_last_check_timestamp_seconds Time when this target was checked
+# TYPE switch_monitoring_last_check_timestamp_seconds gauge
+switch_monitoring_last_check_timestamp_seconds{target="s1.abc01.measurement-lab.org"} 1.600000001e+09
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_check_duration_seconds",
+		"switch_monitoring_last_check_timestamp_seconds")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+}
+
+func TestConfigCheckerCollector_Diff(t *testing.T) {
+	netconf := &netconfProvider{
+		filepath: "testdata/abc01.conf",
+	}
+	provider := &contentProvider{
+		filepath: "testdata/abc01.conf",
+	}
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", Config{
+		ProjectID: "test",
+		Netconf:   netconf,
+		Provider:  provider,
+	})
+
+	d, err := collector.Diff()
+	if err != nil {
+		t.Fatalf("Diff() returned err: %v", err)
+	}
+	if !d.Empty() {
+		t.Errorf("Diff() returned unexpected differences:\n%s", d.Unified)
+	}
+
+	provider.filepath = "testdata/abc02.conf"
+	d, err = collector.Diff()
+	if err != nil {
+		t.Fatalf("Diff() returned err: %v", err)
+	}
+	if d.Empty() {
+		t.Errorf("Diff() did not return any differences")
+	}
+
+	// Make the content provider fail.
+	provider.fail = true
+	if _, err = collector.Diff(); err == nil {
+		t.Errorf("Diff(): expected err, got nil.")
+	}
+	provider.fail = false
+
+	// Make netconf fail.
+	netconf.fail = true
+	if _, err = collector.Diff(); err == nil {
+		t.Errorf("Diff(): expected err, got nil.")
+	}
+}
+
+func TestConfigCheckerCollector_CollectSections(t *testing.T) {
+	metadata := `# HELP switch_monitoring_section_diff_lines Number of differing lines in a section of this target
+# TYPE switch_monitoring_section_diff_lines gauge
+`
+	netconf := &netconfProvider{
+		filepath: "testdata/abc01.conf",
+	}
+	provider := &contentProvider{
+		filepath: "testdata/abc02.conf",
+	}
+	collector := New(context.Background(), "s1.abc01.measurement-lab.org", Config{
+		ProjectID: "test",
+		Netconf:   netconf,
+		Provider:  provider,
+		Sections:  []string{"system", "interfaces"},
+	})
+
+	// Only the host-name in the system section is different.
+	expected := metadata + `
+switch_monitoring_section_diff_lines{section="interfaces",target="s1.abc01.measurement-lab.org"} 0
+switch_monitoring_section_diff_lines{section="system",target="s1.abc01.measurement-lab.org"} 2
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_section_diff_lines")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+
+	metadata = `# HELP switch_monitoring_section_match Configuration check result for a section of this target
+# TYPE switch_monitoring_section_match gauge
+`
+	expected = metadata + `
+switch_monitoring_section_match{section="interfaces",status="ok",target="s1.abc01.measurement-lab.org"} 1
+switch_monitoring_section_match{section="system",status="config_mismatch",target="s1.abc01.measurement-lab.org"} 1
+`
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_section_match")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+
+	// Make the content provider fail.
+	expected = metadata + `
+switch_monitoring_section_match{section="interfaces",status="config_not_found_gcs",target="s1.abc01.measurement-lab.org"} 1
+switch_monitoring_section_match{section="system",status="config_not_found_gcs",target="s1.abc01.measurement-lab.org"} 1
+`
+	provider.fail = true
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_section_match")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+	provider.fail = false
+
+	// Make netconf fail.
+	expected = metadata + `
+switch_monitoring_section_match{section="interfaces",status="config_not_found_switch",target="s1.abc01.measurement-lab.org"} 1
+switch_monitoring_section_match{section="system",status="config_not_found_switch",target="s1.abc01.measurement-lab.org"} 1
+`
+	netconf.fail = true
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"switch_monitoring_section_match")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+}
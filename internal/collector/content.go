@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/m-lab/go/content"
+)
+
+// httpsTimeout is the maximum time allowed to download an expected
+// configuration over HTTPS.
+const httpsTimeout = time.Minute
+
+// fromURL returns a content.Provider for the URL. Unlike content.FromURL, the
+// HTTPS provider fails if the server doesn't reply with 200, instead of
+// returning e.g. an error page as the expected configuration.
+func fromURL(ctx context.Context, u *url.URL) (content.Provider, error) {
+	if u.Scheme != "https" {
+		return content.FromURL(ctx, u)
+	}
+	return &httpsProvider{url: u.String(), client: http.DefaultClient}, nil
+}
+
+// httpsProvider downloads a file from a public HTTPS URL.
+type httpsProvider struct {
+	url    string
+	client *http.Client
+}
+
+func (p *httpsProvider) Get(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download %s: %s", p.url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// lockedProvider holds a lock while reading from a content.Provider, e.g. so
+// that a git checkout isn't updated while it's read.
+type lockedProvider struct {
+	content.Provider
+	lock sync.Locker
+}
+
+func (p *lockedProvider) Get(ctx context.Context) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.Provider.Get(ctx)
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/rtx"
+)
+
+// providerFunc is a content.Provider calling itself.
+type providerFunc func(context.Context) ([]byte, error)
+
+func (f providerFunc) Get(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+func Test_fromURL(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/abc01.conf" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("system {}"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{name: "ok", url: srv.URL + "/abc01.conf", want: "system {}"},
+		{name: "not-found", url: srv.URL + "/abc02.conf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			rtx.Must(err, "Cannot parse URL")
+			p, err := fromURL(context.Background(), u)
+			rtx.Must(err, "Cannot create provider")
+			p.(*httpsProvider).client = srv.Client()
+			got, err := p.Get(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Other schemes are handled by the content package.
+	u, err := url.Parse("file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "abc01.conf")))
+	rtx.Must(err, "Cannot parse URL")
+	if _, err := fromURL(context.Background(), u); err != nil {
+		t.Errorf("fromURL(file://) error = %v", err)
+	}
+	if _, err := fromURL(context.Background(), &url.URL{Scheme: "ftp"}); err != content.ErrUnsupportedURLScheme {
+		t.Errorf("fromURL(ftp://) error = %v, want %v", err, content.ErrUnsupportedURLScheme)
+	}
+}
+
+func Test_lockedProvider(t *testing.T) {
+	var mu sync.Mutex
+	p := &lockedProvider{
+		Provider: providerFunc(func(context.Context) ([]byte, error) {
+			// The lock is held while reading.
+			if mu.TryLock() {
+				mu.Unlock()
+				t.Errorf("Get() called without holding the lock")
+			}
+			return []byte("system {}"), nil
+		}),
+		lock: &mu,
+	}
+	if got, err := p.Get(context.Background()); err != nil || string(got) != "system {}" {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+	if !mu.TryLock() {
+		t.Errorf("Get() did not release the lock")
+	}
+}
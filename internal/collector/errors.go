@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/api/googleapi"
+)
+
+// Statuses for the failures to read the expected configuration, in addition
+// to configNotFoundGCS.
+const (
+	configPermissionDeniedGCS = "permission_denied_gcs"
+)
+
+// switchStatusSuffix is appended to the reason of a failed call to a switch
+// to build the status, e.g. "timeout_switch".
+const switchStatusSuffix = "_switch"
+
+var checkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "switch_monitoring_check_errors_total",
+	Help: "Number of checks that failed to read a configuration, by reason",
+}, []string{"target", "reason"})
+
+// providerStatus returns the status describing why the expected
+// configuration could not be read.
+func providerStatus(err error) string {
+	var apiErr *googleapi.Error
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist), errors.Is(err, fs.ErrNotExist):
+		return configNotFoundGCS
+	case errors.Is(err, fs.ErrPermission):
+		return configPermissionDeniedGCS
+	case errors.As(err, &apiErr):
+		switch apiErr.Code {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return configPermissionDeniedGCS
+		}
+	}
+	return configNotFoundGCS
+}
+
+// switchStatus returns the status describing why the configuration could
+// not be read from the switch. Errors not classified by the netconf package
+// are reported as configNotFoundSwitch.
+func switchStatus(err error) string {
+	reason := netconf.Reason(err)
+	if reason == netconf.ReasonUnknown {
+		return configNotFoundSwitch
+	}
+	return reason + switchStatusSuffix
+}
@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/api/googleapi"
+)
+
+func Test_providerStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "gcs-not-found",
+			err:  storage.ErrObjectNotExist,
+			want: configNotFoundGCS,
+		},
+		{
+			name: "file-not-found",
+			err:  fmt.Errorf("Could not os.Stat(%q): %w", "x.conf", fs.ErrNotExist),
+			want: configNotFoundGCS,
+		},
+		{
+			name: "gcs-forbidden",
+			err:  &googleapi.Error{Code: http.StatusForbidden},
+			want: configPermissionDeniedGCS,
+		},
+		{
+			name: "gcs-unauthorized",
+			err:  &googleapi.Error{Code: http.StatusUnauthorized},
+			want: configPermissionDeniedGCS,
+		},
+		{
+			name: "file-permission",
+			err:  &fs.PathError{Op: "open", Path: "x.conf", Err: fs.ErrPermission},
+			want: configPermissionDeniedGCS,
+		},
+		{
+			name: "gcs-other",
+			err:  &googleapi.Error{Code: http.StatusInternalServerError},
+			want: configNotFoundGCS,
+		},
+		{
+			name: "unknown",
+			err:  errors.New("error"),
+			want: configNotFoundGCS,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := providerStatus(tt.err); got != tt.want {
+				t.Errorf("providerStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_switchStatus(t *testing.T) {
+	err := &netconf.Error{Reason: netconf.ReasonAuth, Err: errors.New("error")}
+	if got := switchStatus(err); got != "auth_failure_switch" {
+		t.Errorf("switchStatus() = %q, want auth_failure_switch", got)
+	}
+	if got := switchStatus(errors.New("error")); got != configNotFoundSwitch {
+		t.Errorf("switchStatus() = %q, want %q", got, configNotFoundSwitch)
+	}
+}
+
+// failingNetconf is a NetconfClient always failing with the same error.
+type failingNetconf struct {
+	err error
+}
+
+func (n *failingNetconf) GetConfig(context.Context, string, ...string) (string, error) {
+	return "", n.err
+}
+
+func TestConfigCheckerCollector_errors(t *testing.T) {
+	const target = "s1-err01.measurement-lab.org"
+	c := New(context.Background(), target, Config{
+		Netconf: &failingNetconf{err: &netconf.Error{
+			Reason: netconf.ReasonTimeout,
+			Err:    context.DeadlineExceeded,
+		}},
+		Provider: &contentProvider{filepath: "testdata/abc01.conf"},
+		Sections: []string{"system"},
+	})
+
+	expected := `
+# HELP switch_monitoring_config_match Configuration check result for this target
+# TYPE switch_monitoring_config_match gauge
+switch_monitoring_config_match{status="timeout_switch",target="s1-err01.measurement-lab.org"} 1
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"switch_monitoring_config_match")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+	if v := testutil.ToFloat64(checkErrors.WithLabelValues(target, "timeout_switch")); v != 1 {
+		t.Errorf("check_errors_total = %v, want 1", v)
+	}
+}
+
+// sectionFailingNetconf reads the whole configuration, but fails to read
+// any section.
+type sectionFailingNetconf struct {
+	netconfProvider
+	err error
+}
+
+func (n *sectionFailingNetconf) GetConfig(ctx context.Context, target string, section ...string) (string, error) {
+	if len(section) > 0 && section[0] != "" {
+		return "", n.err
+	}
+	return n.netconfProvider.GetConfig(ctx, target)
+}
+
+func TestConfigCheckerCollector_sectionErrors(t *testing.T) {
+	const target = "s1-err02.measurement-lab.org"
+	c := New(context.Background(), target, Config{
+		Netconf: &sectionFailingNetconf{
+			netconfProvider: netconfProvider{filepath: "testdata/abc01.conf"},
+			err:             &netconf.Error{Reason: netconf.ReasonTimeout, Err: context.DeadlineExceeded},
+		},
+		Provider: &contentProvider{filepath: "testdata/abc01.conf"},
+		Sections: []string{"system", "interfaces", "snmp"},
+	})
+
+	// Every section fails, but the check is counted once.
+	testutil.CollectAndCount(c)
+	if v := testutil.ToFloat64(checkErrors.WithLabelValues(target, "timeout_switch")); v != 1 {
+		t.Errorf("check_errors_total = %v, want 1", v)
+	}
+	testutil.CollectAndCount(c)
+	if v := testutil.ToFloat64(checkErrors.WithLabelValues(target, "timeout_switch")); v != 2 {
+		t.Errorf("check_errors_total after two checks = %v, want 2", v)
+	}
+}
@@ -2,42 +2,337 @@ package collector
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"regexp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/apex/log"
 	"github.com/m-lab/go/content"
 	"github.com/m-lab/switch-monitoring/internal"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/m-lab/switch-monitoring/internal/resolver"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// scrapeTimeoutOffset is subtracted from Prometheus' scrape timeout, so
+// that a response can be written before Prometheus gives up.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// DefaultConfigURL is the default location of the expected configuration of
+// each switch. See Handler.ConfigURL.
+const DefaultConfigURL = "gs://switch-config-{project}/configs/current/{site}.conf"
+
 var parseURL = url.Parse
 
-// Handler is the HTTP handler for /check
+// Handler is the HTTP handler for /check, /check/batch, /diff and /remediate
 type Handler struct {
+	// Sections is the list of top-level configuration sections to check
+	// individually. See Config.Sections.
+	Sections []string
+
+	// Rules are the normalization rules loaded from the rules file. The
+	// rules for the target's site are applied to every check. Use SetRules
+	// to replace them while the Handler is serving.
+	Rules   *netconf.Rules
+	rulesMu sync.RWMutex
+
+	// Inventory, if set, restricts the checks to the switches it contains.
+	Inventory Inventory
+
+	// Drivers, if set, selects how to read and compare the configuration of
+	// each switch depending on its vendor. Otherwise, every switch is read
+	// with the Handler's NetconfClient in the JunOS text format.
+	Drivers *driver.Registry
+
+	// ConfigURL is the URL template of the expected configuration of a
+	// switch. The {project}, {site} and {hostname} placeholders are replaced
+	// with the GCP project, the switch's site and its hostname. Supported
+	// schemes are gs://, file:// and https://. A URL ending with "/" is a
+	// directory containing a {site}.conf file for each switch. If empty,
+	// DefaultConfigURL is used.
+	ConfigURL string
+
+	// ConfigLock, if set, is held while reading an expected configuration,
+	// e.g. so that a local git checkout is not updated meanwhile. See
+	// gitsync.Checkout.RLocker.
+	ConfigLock sync.Locker
+
+	// Resolver finds the site of each switch from its hostname. If nil,
+	// resolver.Default is used.
+	Resolver resolver.Resolver
+
+	// History, if set, archives the result of every check.
+	History Recorder
+
+	// DiffCommits is the number of recent commits on the switch included
+	// in the /diff response.
+	DiffCommits int
+
+	// Cache, if set, caches the result of the checks served by ServeHTTP.
+	Cache *Cache
+
+	// Targets, if set, lists the known switches, so that batch checks can
+	// select them by site.
+	Targets func(context.Context) ([]string, error)
+
+	// BatchConcurrency is the maximum number of switches checked at the
+	// same time by a batch check.
+	BatchConcurrency int
+
+	// BatchTimeout, if not zero, is the maximum duration of a batch check.
+	BatchTimeout time.Duration
+
+	// ConfirmMinutes is the timeout of the "commit confirmed" issued when
+	// remediating a switch. See netconf.RemediateOptions.
+	ConfirmMinutes int
+
 	projectID     string
 	netconf       internal.NetconfClient
 	getConfigFunc func(context.Context, *url.URL) (content.Provider, error)
 }
 
+// Inventory knows which switches exist on the platform.
+type Inventory interface {
+	// Contains returns true if target is a known switch. It returns an
+	// error if the list of switches is not available.
+	Contains(target string) (bool, error)
+}
+
 // NewHandler returns a Handler with the specified configuration.
 func NewHandler(projectID string, netconf internal.NetconfClient) *Handler {
 	return &Handler{
 		projectID:     projectID,
 		netconf:       netconf,
-		getConfigFunc: content.FromURL,
+		getConfigFunc: fromURL,
 	}
 }
 
+// SetRules replaces the normalization rules while the Handler is serving.
+// The cached results, compared with the previous rules, are invalidated.
+func (h *Handler) SetRules(rules *netconf.Rules) {
+	h.rulesMu.Lock()
+	h.Rules = rules
+	h.rulesMu.Unlock()
+	if h.Cache != nil {
+		h.Cache.invalidate("", invalidatedRules)
+	}
+}
+
+// rules returns the current normalization rules.
+func (h *Handler) rules() *netconf.Rules {
+	h.rulesMu.RLock()
+	defer h.rulesMu.RUnlock()
+	return h.Rules
+}
+
 // ServeHTTP handles GET requests to the /check endpoint, parsing the target
-// parameter and delegating writing the actual response to promhttp.
+// parameter and delegating writing the actual response to promhttp. If a
+// Cache is configured, the result is served from it unless the refresh
+// parameter is "true".
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := h.parseTarget(w, r)
+	if !ok {
+		return
+	}
+
+	// This collector depends on external parameters (target) and only returns
+	// one metric. This is not how custom collectors usually work.
+	// To make it possible we create a new Collector that only collects metrics
+	// for the requested targets every time, and we create a new Registry,
+	// register this temporary Collector and let Prometheus write the response.
+	//
+	// A possible alternative approach that was considered is having a single
+	// /metrics endpoint that, when called, checked every switch on the
+	// platform and returned metrics for all of them. However, this would easily
+	// exceed Prometheus' scraping time, would imply an additional dependency on
+	// siteinfo (to fetch the current switches.json) and would not benefit from
+	// the randomized scraping interval Prometheus implements. The Scheduler
+	// provides such an endpoint by checking the switches in the background
+	// instead.
+
+	ctx, cancel := ScrapeContext(r)
+	defer cancel()
+
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get(RefreshParam))
+	result, lookup, err := h.check(ctx, target, refresh)
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		var se *statusError
+		if errors.As(err, &se) {
+			status = se.status
+		}
+		writeError(w, err, status)
+		return
+	}
+
+	if lookup != "" {
+		setCacheHeaders(w, lookup, result.Time)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metricsCollector(result.Metrics))
+
+	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	promHandler.ServeHTTP(w, r)
+}
+
+// check checks a single target, through the Cache if one is configured,
+// unless refresh is true. The returned string is the result of the lookup in
+// the Cache, or empty if there is no Cache. Errors preventing the check are of
+// type *statusError.
+func (h *Handler) check(ctx context.Context, target string, refresh bool) (*Result, string, error) {
+	check := func(ctx context.Context) (*Result, error) {
+		config, status, err := h.configFor(target)
+		if err != nil {
+			return nil, &statusError{status: status, err: err}
+		}
+		start := time.Now()
+		collector := New(ctx, target, config)
+		metrics := gather(collector)
+		result := &Result{
+			Metrics: metrics,
+			Status:  collector.Status(),
+			Time:    start,
+			Diff:    collector.Mismatch(),
+		}
+		if err := collector.Err(); err != nil {
+			result.Error = err.Error()
+		}
+		return result, nil
+	}
+
+	if h.Cache == nil {
+		result, err := check(ctx)
+		return result, "", err
+	}
+	if refresh {
+		cacheInvalidations.WithLabelValues(invalidatedRefresh).Inc()
+	}
+	return h.Cache.Get(ctx, target, refresh, check)
+}
+
+// setCacheHeaders reports whether the response was served from the cache
+// and when the target was checked.
+func setCacheHeaders(w http.ResponseWriter, lookup string, checked time.Time) {
+	w.Header().Set(CacheHeader, strings.ToUpper(lookup))
+	w.Header().Set(CheckTimeHeader, checked.UTC().Format(time.RFC3339))
+	age := int64(time.Since(checked) / time.Second)
+	if age < 0 {
+		age = 0
+	}
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+}
+
+// statusError is an error with the HTTP status code describing it.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// metricsCollector is a prometheus.Collector sending a fixed set of metrics.
+type metricsCollector []prometheus.Metric
+
+// Describe sends no descriptors, making it an unchecked collector.
+func (metricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (m metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range m {
+		ch <- metric
+	}
+}
+
+// ServeDiff handles GET requests to the /diff endpoint. It returns the
+// differences between the expected and the actual configuration of the
+// target as JSON, along with the last DiffCommits commits on the switch, or,
+// if the format parameter is "text", as a unified diff.
+func (h *Handler) ServeDiff(w http.ResponseWriter, r *http.Request) {
+	target, config, ok := h.parseRequest(w, r)
+	if !ok {
+		return
+	}
+
+	c := New(r.Context(), target, config)
+	d, err := c.Diff()
+	if err != nil {
+		writeError(w, err, http.StatusBadGateway)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(d.Unified))
+		return
+	}
+
+	resp := diffResponse{
+		Target: target,
+		Match:  d.Empty(),
+		Diff:   d,
+	}
+	if h.DiffCommits > 0 {
+		// The diff is still useful without the commits.
+		resp.Commits, err = c.Commits(h.DiffCommits)
+		if err != nil {
+			log.WithFields(log.Fields{"target": target}).WithError(err).Warn(
+				"Cannot fetch the commit history from the switch")
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// diffResponse is the JSON document returned by the /diff endpoint.
+type diffResponse struct {
+	Target string `json:"target"`
+	Match  bool   `json:"match"`
+	*netconf.Diff
+	// Commits are the most recent commits on the switch, which may explain
+	// the differences.
+	Commits []netconf.Commit `json:"commits,omitempty"`
+}
+
+// parseRequest validates a GET request for a single target and builds the
+// collector configuration for it. If the request is not valid, it writes an
+// error on the provided ResponseWriter and returns false.
+func (h *Handler) parseRequest(w http.ResponseWriter, r *http.Request) (string, Config, bool) {
+	target, ok := h.parseTarget(w, r)
+	if !ok {
+		return "", Config{}, false
+	}
+
+	config, status, err := h.configFor(target)
+	if err != nil {
+		writeError(w, err, status)
+		return "", Config{}, false
+	}
+	return target, config, true
+}
+
+// parseTarget validates a GET request for a single target and returns the
+// target. If the request is not valid, it writes an error on the provided
+// ResponseWriter and returns false.
+func (h *Handler) parseTarget(w http.ResponseWriter, r *http.Request) (string, bool) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+		return "", false
 	}
 
 	target := r.URL.Query().Get("target")
@@ -45,54 +340,87 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("URL parameter 'target' is missing"))
 		log.Info("URL parameter 'target' is missing")
-		return
+		return "", false
 	}
+	return target, true
+}
 
-	site, err := getSite(target)
+// configFor builds the collector configuration for a target. If it fails,
+// the returned status is the HTTP status code describing the failure.
+func (h *Handler) configFor(target string) (Config, int, error) {
+	if h.Inventory != nil {
+		ok, err := h.Inventory.Contains(target)
+		if err != nil {
+			return Config{}, http.StatusServiceUnavailable, err
+		}
+		if !ok {
+			return Config{}, http.StatusNotFound,
+				fmt.Errorf("unknown target: %s", target)
+		}
+	}
+
+	site, err := h.site(target)
 	if err != nil {
-		writeError(w, err, http.StatusBadRequest)
-		return
+		return Config{}, http.StatusBadRequest, err
 	}
 
-	provider, err := h.getProviderForConfig(site)
+	provider, err := h.getProviderForConfig(target, site)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
-		return
+		return Config{}, http.StatusInternalServerError, err
+	}
+
+	d := driver.Driver{Netconf: h.netconf, Format: netconf.Text}
+	vendor := driver.Juniper
+	if h.Drivers != nil {
+		d, err = h.Drivers.ForTarget(target)
+		if err != nil {
+			return Config{}, http.StatusInternalServerError, err
+		}
+		vendor = h.Drivers.Vendor(target)
 	}
 
 	config := Config{
 		ProjectID: h.projectID,
-		Netconf:   h.netconf,
+		Netconf:   d.Netconf,
 		Provider:  provider,
+		Sections:  h.Sections,
+		Rules:     h.rules().ForSite(site),
+		Format:    d.Format,
+		History:   h.History,
 	}
+	// The commit history is only available on JunOS.
+	if commits, ok := d.Netconf.(CommitLister); ok && vendor == driver.Juniper {
+		config.Commits = commits
+	}
+	return config, http.StatusOK, nil
+}
 
-	// This collector depends on external parameters (target) and only returns
-	// one metric. This is not how custom collectors usually work.
-	// To make it possible we create a new Collector that only collects metrics
-	// for the requested targets every time, and we create a new Registry,
-	// register this temporary Collector and let Prometheus write the response.
-	//
-	// A possible alternative approach that was considered is having a single
-	// /metrics endpoint that, when called, checked every switch on the
-	// platform and returned metrics for all of them. However, this would easily
-	// exceed Prometheus' scraping time, would imply an additional dependency on
-	// siteinfo (to fetch the current switches.json) and would not benefit from
-	// the randomized scraping interval Prometheus implements.
-
-	registry := prometheus.NewRegistry()
-	collector := New(target, config)
-	registry.MustRegister(collector)
+// ScrapeContext returns a context for the request that expires shortly before
+// Prometheus gives up on the scrape, as specified by the
+// X-Prometheus-Scrape-Timeout-Seconds header. If the header is missing or
+// invalid, the request's context is returned.
+func ScrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		log.WithError(err).Warn("Invalid scrape timeout header")
+		return context.WithCancel(r.Context())
+	}
 
-	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	promHandler.ServeHTTP(w, r)
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
 }
 
-// getProviderForConfig initializes a content.Provider for the specified site.
-func (h *Handler) getProviderForConfig(site string) (content.Provider, error) {
-	url, err := parseURL(
-		fmt.Sprintf("gs://switch-config-%s/configs/current/%s.conf",
-			h.projectID, site),
-	)
+// getProviderForConfig initializes a content.Provider for the specified
+// target.
+func (h *Handler) getProviderForConfig(target, site string) (content.Provider, error) {
+	url, err := parseURL(h.configURL(target, site))
 	if err != nil {
 		return nil, err
 	}
@@ -101,21 +429,106 @@ func (h *Handler) getProviderForConfig(site string) (content.Provider, error) {
 	if err != nil {
 		return nil, err
 	}
+	if h.ConfigLock != nil {
+		provider = &lockedProvider{Provider: provider, lock: h.ConfigLock}
+	}
 
 	return provider, nil
 }
 
-// getSite returns the site name from a FQDN like
-// s1.<site>.measurement-lab.org.
-func getSite(hostname string) (string, error) {
-	re := regexp.MustCompile(`s1-([a-z]{3}[0-9ct]{2}).*`)
-	res := re.FindStringSubmatch(hostname)
-	if len(res) != 2 {
-		return "", fmt.Errorf("cannot extract site from hostname: %s",
-			hostname)
+// ConfigVersion returns the version of the expected configuration of a
+// target: the generation of a gs:// object or the modification time of a
+// file. It returns an empty version for other schemes, whose changes are not
+// detected.
+func (h *Handler) ConfigVersion(ctx context.Context, target string) (string, error) {
+	site, err := h.site(target)
+	if err != nil {
+		return "", err
+	}
+	u, err := parseURL(h.configURL(target, site))
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "gs":
+		generation, err := objectGeneration(ctx, u.Host,
+			strings.TrimPrefix(u.Path, "/"))
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(generation, 10), nil
+	case "file":
+		path := u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		return fi.ModTime().UTC().Format(time.RFC3339Nano), nil
+	}
+	return "", nil
+}
+
+// objectGeneration returns the generation of a GCS object.
+var objectGeneration = func(ctx context.Context, bucket, object string) (int64, error) {
+	client, err := storageClient()
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := client.Bucket(bucket).Object(object).Attrs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Generation, nil
+}
+
+// gcs is the client reading the generation of GCS objects, created on first
+// use and shared by every poll, since creating one is expensive.
+var gcs struct {
+	sync.Mutex
+	client *storage.Client
+}
+
+// storageClient returns the shared GCS client, creating it if needed. It's
+// not bound to the context of a request, since it outlives it.
+func storageClient() (*storage.Client, error) {
+	gcs.Lock()
+	defer gcs.Unlock()
+	if gcs.client == nil {
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		gcs.client = client
 	}
+	return gcs.client, nil
+}
 
-	return res[1], nil
+// configURL expands the ConfigURL template for the specified target.
+func (h *Handler) configURL(target, site string) string {
+	template := h.ConfigURL
+	if template == "" {
+		template = DefaultConfigURL
+	}
+	if strings.HasSuffix(template, "/") {
+		template += "{site}.conf"
+	}
+	return strings.NewReplacer(
+		"{project}", h.projectID,
+		"{site}", site,
+		"{hostname}", target,
+	).Replace(template)
+}
+
+// site returns the site of the target, as found by the Resolver.
+func (h *Handler) site(target string) (string, error) {
+	if h.Resolver == nil {
+		return resolver.Default.Site(target)
+	}
+	return h.Resolver.Site(target)
 }
 
 // writeError writes an error on the provided ResponseWriter and logs it.
@@ -2,14 +2,23 @@ package collector
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/m-lab/go/content"
+	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	ncfg "github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/m-lab/switch-monitoring/internal/resolver"
 )
 
 func TestNewHandler(t *testing.T) {
@@ -99,7 +108,8 @@ func TestHandler_ServeHTTP(t *testing.T) {
 			if err != nil {
 				t.Errorf("ServeHTTP() - cannot read response: %v", err)
 			}
-			if string(body) != test.body {
+			// The response also contains the duration of the check.
+			if !strings.Contains(string(body), test.body) {
 				t.Errorf("ServeHTTP() - unexpected response: \n%s", string(body))
 			}
 		}
@@ -112,7 +122,7 @@ func TestHandler_getProviderForConfig(t *testing.T) {
 	parseURL = func(rawurl string) (*url.URL, error) {
 		return nil, fmt.Errorf("parseURL() error")
 	}
-	_, err := h.getProviderForConfig("://")
+	_, err := h.getProviderForConfig("s1-abc01", "://")
 	if err == nil {
 		t.Errorf("getProviderForConfig(): expected err, got nil.")
 	}
@@ -120,3 +130,584 @@ func TestHandler_getProviderForConfig(t *testing.T) {
 	parseURL = oldParseURL
 
 }
+
+func TestHandler_configURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		configURL string
+		want      string
+	}{
+		{
+			name: "default",
+			want: "gs://switch-config-test/configs/current/abc01.conf",
+		},
+		{
+			name:      "all-placeholders",
+			configURL: "https://configs/{project}/{site}/{hostname}.conf",
+			want:      "https://configs/test/abc01/s1-abc01.measurement-lab.org.conf",
+		},
+		{
+			name:      "directory",
+			configURL: "file:///srv/configs/",
+			want:      "file:///srv/configs/abc01.conf",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler("test", &netconfProvider{})
+			h.ConfigURL = tt.configURL
+			if got := h.configURL("s1-abc01.measurement-lab.org", "abc01"); got != tt.want {
+				t.Errorf("configURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeHTTPWithLocalConfigs(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	rtx.Must(err, "Cannot get testdata path")
+	h := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	h.ConfigURL = "file://" + dir + "/"
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/check?target=s1-abc01", nil))
+	want := `switch_monitoring_config_match{status="ok",target="s1-abc01"} 1`
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("ServeHTTP() = %d %s, want %s", rr.Code, rr.Body.String(), want)
+	}
+}
+
+func TestHandler_ServeHTTPWithCache(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	rtx.Must(err, "Cannot get testdata path")
+	netconf := &netconfProvider{filepath: "testdata/abc01.conf"}
+	h := NewHandler("test", netconf)
+	h.ConfigURL = "file://" + dir + "/"
+	h.Cache = NewCache(CacheConfig{
+		Capacity: 10,
+		MatchTTL: time.Hour,
+		ErrorTTL: time.Minute,
+	})
+
+	tests := []struct {
+		name   string
+		url    string
+		fail   bool
+		status int
+		cache  string
+		body   string
+	}{
+		{
+			name:   "first-check",
+			url:    "/v1/check?target=s1-abc01",
+			status: http.StatusOK,
+			cache:  "MISS",
+			body:   `switch_monitoring_config_match{status="ok",target="s1-abc01"} 1`,
+		},
+		{
+			name:   "cached",
+			url:    "/v1/check?target=s1-abc01",
+			fail:   true,
+			status: http.StatusOK,
+			cache:  "HIT",
+			body:   `switch_monitoring_config_match{status="ok",target="s1-abc01"} 1`,
+		},
+		{
+			name:   "refresh",
+			url:    "/v1/check?target=s1-abc01&refresh=true",
+			fail:   true,
+			status: http.StatusOK,
+			cache:  "MISS",
+			body:   `switch_monitoring_config_match{status="config_not_found_switch",target="s1-abc01"} 1`,
+		},
+		{
+			name:   "error-cached",
+			url:    "/v1/check?target=s1-abc01&refresh=false",
+			status: http.StatusOK,
+			cache:  "HIT",
+			body:   `switch_monitoring_config_match{status="config_not_found_switch",target="s1-abc01"} 1`,
+		},
+		{
+			name:   "invalid-target",
+			url:    "/v1/check?target=invalid",
+			status: http.StatusBadRequest,
+			body:   "cannot extract site from hostname: invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			netconf.fail = tt.fail
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rr.Code != tt.status {
+				t.Errorf("ServeHTTP() status = %d, want %d", rr.Code, tt.status)
+			}
+			if got := rr.Header().Get(CacheHeader); got != tt.cache {
+				t.Errorf("ServeHTTP() %s = %q, want %q", CacheHeader, got, tt.cache)
+			}
+			if tt.cache != "" {
+				if _, err := time.Parse(time.RFC3339, rr.Header().Get(CheckTimeHeader)); err != nil {
+					t.Errorf("ServeHTTP() invalid %s: %v", CheckTimeHeader, err)
+				}
+				if rr.Header().Get("Age") == "" {
+					t.Errorf("ServeHTTP() didn't set the Age header")
+				}
+			}
+			if !strings.Contains(rr.Body.String(), tt.body) {
+				t.Errorf("ServeHTTP() body = %s, want %s", rr.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestHandler_Resolver(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	rtx.Must(err, "Cannot get testdata path")
+	h := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	h.ConfigURL = "file://" + dir + "/"
+	h.Resolver = resolver.Chain{resolver.MustRegexp("edge", `^edge-([a-z]{3}[0-9]{2})$`)}
+
+	tests := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{
+			target: "edge-abc01",
+			status: http.StatusOK,
+			body:   `switch_monitoring_config_match{status="ok",target="edge-abc01"} 1`,
+		},
+		{
+			target: "s1-abc01",
+			status: http.StatusBadRequest,
+			body:   "cannot extract site from hostname: s1-abc01 (edge: no match)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/check?target="+tt.target, nil))
+			if rr.Code != tt.status || !strings.Contains(rr.Body.String(), tt.body) {
+				t.Errorf("ServeHTTP() = %d %s, want %d %s", rr.Code, rr.Body.String(),
+					tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        *http.Request
+		status   int
+		body     string
+		expected string
+		fail     bool
+	}{
+		{
+			name: "ok-no-differences",
+			r: httptest.NewRequest("GET",
+				"/v1/diff?target=s1-abc01.measurement-lab.org", nil),
+			status:   http.StatusOK,
+			expected: "testdata/abc01.conf",
+			body: `{"target":"s1-abc01.measurement-lab.org","match":true,` +
+				`"unified":"","hunks":[],"mismatches":[]}
+`,
+		},
+		{
+			name: "ok-differences-text",
+			r: httptest.NewRequest("GET",
+				"/v1/diff?target=s1-abc01.measurement-lab.org&format=text", nil),
+			status:   http.StatusOK,
+			expected: "testdata/abc02.conf",
+			body: `--- expected
++++ actual
+@@ -227,7 +227,7 @@
+     }
+ }
+ system {
+-    host-name s1.abc02.measurement-lab.org;
++    host-name s1.lga0t.measurement-lab.org;
+     login {
+         class rancid {
+             permissions [ view view-configuration ];
+`,
+		},
+		{
+			name:   "method-not-allowed",
+			r:      httptest.NewRequest("POST", "/v1/diff", nil),
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "target-not-provided",
+			r:      httptest.NewRequest("GET", "/v1/diff", nil),
+			status: http.StatusBadRequest,
+			body:   "URL parameter 'target' is missing",
+		},
+		{
+			name: "netconf-failure",
+			r: httptest.NewRequest("GET",
+				"/v1/diff?target=s1-abc01.measurement-lab.org", nil),
+			status:   http.StatusBadGateway,
+			expected: "testdata/abc01.conf",
+			fail:     true,
+			body:     "cannot compare configurations: config_not_found_switch",
+		},
+	}
+
+	netconf := &netconfProvider{
+		filepath: "testdata/abc01.conf",
+	}
+
+	handler := NewHandler("test", netconf)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			netconf.fail = test.fail
+			handler.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+				return &contentProvider{filepath: test.expected}, nil
+			}
+			handler.ServeDiff(rr, test.r)
+
+			resp := rr.Result()
+			if resp.StatusCode != test.status {
+				t.Errorf("ServeDiff - expected %d, got %d", test.status,
+					resp.StatusCode)
+			}
+
+			if test.body != "" {
+				body, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Errorf("ServeDiff() - cannot read response: %v", err)
+				}
+				if string(body) != test.body {
+					t.Errorf("ServeDiff() - unexpected response: \n%s", string(body))
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_ServeDiffWithRules(t *testing.T) {
+	netconf := &netconfProvider{
+		filepath: "testdata/abc01.conf",
+	}
+	handler := NewHandler("test", netconf)
+	handler.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc02.conf"}, nil
+	}
+
+	// The host-name is the only difference, but it's ignored for abc01 only.
+	rules, err := ncfg.ParseRules([]byte(
+		`{"sites": {"abc01": {"ignore": ["system host-name"]}}}`))
+	if err != nil {
+		t.Fatalf("ParseRules() returned err: %v", err)
+	}
+	handler.Rules = rules
+
+	for target, match := range map[string]bool{
+		"s1-abc01.measurement-lab.org": true,
+		"s1-abc02.measurement-lab.org": false,
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeDiff(rr, httptest.NewRequest("GET",
+			"/v1/diff?target="+target, nil))
+		var resp diffResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("ServeDiff() returned invalid JSON: %v", err)
+		}
+		if resp.Match != match {
+			t.Errorf("ServeDiff(%s) match = %v, want %v", target, resp.Match,
+				match)
+		}
+	}
+}
+
+func TestHandler_SetRules(t *testing.T) {
+	handler := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	handler.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc02.conf"}, nil
+	}
+	handler.Cache = NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour,
+		MismatchTTL: time.Hour})
+
+	ctx := context.Background()
+	target := "s1-abc01.measurement-lab.org"
+	result, _, err := handler.check(ctx, target, false)
+	if err != nil || result.Status != configMismatch {
+		t.Fatalf("check() = %+v, %v, want %s", result, err, configMismatch)
+	}
+
+	// The cached result was compared with the previous rules, so it must
+	// not be reused.
+	rules, err := ncfg.ParseRules([]byte(`{"ignore": ["system host-name"]}`))
+	rtx.Must(err, "Cannot parse rules")
+	handler.SetRules(rules)
+	result, lookup, err := handler.check(ctx, target, false)
+	if err != nil || result.Status != configMatches || lookup != cacheMiss {
+		t.Errorf("check() after SetRules() = %+v, %s, %v, want %s from a miss",
+			result, lookup, err, configMatches)
+	}
+}
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantDeadline bool
+		wantTimeout  time.Duration
+	}{
+		{
+			name: "no-header",
+		},
+		{
+			name:   "invalid-header",
+			header: "invalid",
+		},
+		{
+			name:         "offset-subtracted",
+			header:       "10",
+			wantDeadline: true,
+			wantTimeout:  10*time.Second - scrapeTimeoutOffset,
+		},
+		{
+			name:         "short-timeout",
+			header:       "0.5",
+			wantDeadline: true,
+			wantTimeout:  500 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/check", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+			start := time.Now()
+			ctx, cancel := ScrapeContext(r)
+			defer cancel()
+
+			end := time.Now()
+
+			deadline, ok := ctx.Deadline()
+			if ok != tt.wantDeadline {
+				t.Fatalf("ScrapeContext() deadline set = %v, want %v", ok,
+					tt.wantDeadline)
+			}
+			if ok && (deadline.Before(start.Add(tt.wantTimeout)) ||
+				deadline.After(end.Add(tt.wantTimeout))) {
+				t.Errorf("ScrapeContext() timeout = %v, want %v",
+					deadline.Sub(start), tt.wantTimeout)
+			}
+		})
+	}
+}
+
+type mockInventory struct {
+	targets map[string]bool
+	err     error
+}
+
+func (i *mockInventory) Contains(target string) (bool, error) {
+	return i.targets[target], i.err
+}
+
+func TestHandler_Inventory(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		inventory *mockInventory
+		status    int
+	}{
+		{
+			name:      "known-target",
+			target:    "s1-abc01",
+			inventory: &mockInventory{targets: map[string]bool{"s1-abc01": true}},
+			status:    http.StatusOK,
+		},
+		{
+			name:      "unknown-target",
+			target:    "s1-xyz01",
+			inventory: &mockInventory{targets: map[string]bool{"s1-abc01": true}},
+			status:    http.StatusNotFound,
+		},
+		{
+			name:      "inventory-not-loaded",
+			target:    "s1-abc01",
+			inventory: &mockInventory{err: fmt.Errorf("not loaded")},
+			status:    http.StatusServiceUnavailable,
+		},
+	}
+
+	handler := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	handler.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc01.conf"}, nil
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler.Inventory = tt.inventory
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET",
+				"/v1/check?target="+tt.target, nil))
+			if rr.Code != tt.status {
+				t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestHandler_Drivers(t *testing.T) {
+	drivers := driver.NewRegistry(driver.Generic)
+	drivers.Register(driver.Generic, driver.Driver{
+		Netconf: &netconfProvider{filepath: "testdata/abc01.xml"},
+		Format:  ncfg.XML,
+	})
+	drivers.Overrides = map[string]string{"s1-abc02": "unknown"}
+
+	handler := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	handler.Drivers = drivers
+	handler.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc01.xml"}, nil
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/check?target=s1-abc01", nil))
+	want := `switch_monitoring_config_match{status="ok",target="s1-abc01"} 1`
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), want) {
+		t.Errorf("ServeHTTP() = %d %s, want %s", rr.Code, rr.Body.String(), want)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/check?target=s1-abc02", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code,
+			http.StatusInternalServerError)
+	}
+}
+
+// committingProvider is a mock NetconfClient that can list commits.
+type committingProvider struct {
+	netconfProvider
+	mockCommitLister
+}
+
+func TestHandler_ServeDiffWithCommits(t *testing.T) {
+	netconf := &committingProvider{
+		netconfProvider: netconfProvider{filepath: "testdata/abc01.conf"},
+		mockCommitLister: mockCommitLister{commits: []ncfg.Commit{
+			{Sequence: 0, User: "alice", Client: "cli", Time: time.Unix(1600086400, 0).UTC()},
+			{Sequence: 1, User: "root", Client: "netconf", Time: time.Unix(1600000000, 0).UTC()},
+		}},
+	}
+	drivers := driver.NewRegistry(driver.Juniper)
+	drivers.Register(driver.Juniper, driver.Driver{Netconf: netconf})
+	drivers.Register(driver.Generic, driver.Driver{Netconf: netconf})
+	drivers.Overrides = map[string]string{"s1-abc02": driver.Generic}
+
+	h := NewHandler("test", netconf)
+	h.Drivers = drivers
+	h.DiffCommits = 1
+	h.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc01.conf"}, nil
+	}
+
+	tests := []struct {
+		target string
+		body   string
+	}{
+		{
+			target: "s1-abc01",
+			body: `{"target":"s1-abc01","match":true,"unified":"","hunks":[],` +
+				`"mismatches":[],"commits":[{"sequence":0,"user":"alice",` +
+				`"client":"cli","time":"2020-09-14T12:26:40Z"}]}` + "\n",
+		},
+		{
+			// The commit history is only read from JunOS switches.
+			target: "s1-abc02",
+			body: `{"target":"s1-abc02","match":true,"unified":"","hunks":[],` +
+				`"mismatches":[]}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		h.ServeDiff(rr, httptest.NewRequest("GET", "/v1/diff?target="+tt.target, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != tt.body {
+			t.Errorf("ServeDiff() = %d %s, want %s", rr.Code, rr.Body.String(), tt.body)
+		}
+	}
+
+	// Failing to read the commit history does not fail the request.
+	netconf.err = fmt.Errorf("error")
+	rr := httptest.NewRecorder()
+	h.ServeDiff(rr, httptest.NewRequest("GET", "/v1/diff?target=s1-abc01", nil))
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "commits") {
+		t.Errorf("ServeDiff() = %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandler_ConfigVersion(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "abc01.conf")
+	rtx.Must(ioutil.WriteFile(path, []byte("config"), 0644), "Cannot write config")
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rtx.Must(os.Chtimes(path, mtime, mtime), "Cannot set mtime")
+
+	oldGeneration := objectGeneration
+	defer func() { objectGeneration = oldGeneration }()
+	objectGeneration = func(_ context.Context, bucket, object string) (int64, error) {
+		if bucket != "switch-config-test" || object != "configs/current/abc01.conf" {
+			return 0, fmt.Errorf("unexpected object gs://%s/%s", bucket, object)
+		}
+		return 42, nil
+	}
+
+	tests := []struct {
+		name      string
+		configURL string
+		target    string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:   "gcs",
+			target: "s1-abc01.measurement-lab.org",
+			want:   "42",
+		},
+		{
+			name:      "file",
+			configURL: "file://" + dir + "/",
+			target:    "s1-abc01.measurement-lab.org",
+			want:      "2020-01-02T03:04:05Z",
+		},
+		{
+			name:      "https",
+			configURL: "https://example.com/{site}.conf",
+			target:    "s1-abc01.measurement-lab.org",
+		},
+		{
+			name:      "missing-file",
+			configURL: "file://" + dir + "/{hostname}",
+			target:    "s1-abc01.measurement-lab.org",
+			wantErr:   true,
+		},
+		{
+			name:    "invalid-target",
+			target:  "invalid",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler("test", &netconfProvider{})
+			h.ConfigURL = tt.configURL
+			got, err := h.ConfigVersion(context.Background(), tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConfigVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ConfigVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RefreshParam is the query parameter forcing a fresh check of the target
+// when it's "true".
+const RefreshParam = "refresh"
+
+// Reasons a cached check is invalidated.
+const (
+	invalidatedRefresh = "refresh"
+	invalidatedAPI     = "api"
+	invalidatedConfig  = "config_changed"
+	invalidatedRules   = "rules_changed"
+)
+
+var cacheInvalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "switch_monitoring_cache_invalidations_total",
+	Help: "Number of cached checks invalidated, by reason",
+}, []string{"reason"})
+
+// Invalidate removes the cached result of a target, or of every target if
+// target is empty, so that they are checked again on the next request. It
+// returns the number of results removed.
+func (c *Cache) Invalidate(target string) int {
+	return c.invalidate(target, invalidatedAPI)
+}
+
+// invalidate removes the cached results like Invalidate, counting them with
+// the provided reason.
+func (c *Cache) invalidate(target, reason string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for t, e := range c.entries {
+		if (target == "" || t == target) && e.result != nil {
+			e.result = nil
+			n++
+		}
+	}
+	cacheInvalidations.WithLabelValues(reason).Add(float64(n))
+	return n
+}
+
+// ServeInvalidate handles POST requests to the /cache/invalidate endpoint,
+// invalidating the cached result of the target parameter, or every cached
+// result if it's omitted.
+func (c *Cache) ServeInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	n := c.Invalidate(target)
+	log.WithFields(log.Fields{
+		"target":      target,
+		"invalidated": n,
+	}).Info("Invalidated cached checks")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Invalidated int `json:"invalidated"`
+	}{n})
+}
+
+// Run polls the version of the expected configuration of every cached
+// target at the specified interval, invalidating the targets whose version
+// changed, until the context is canceled. It's a no-op if Version is nil.
+func (c *Cache) Run(ctx context.Context, interval time.Duration) {
+	if c.Version == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.poll(ctx)
+	}
+}
+
+// version returns the current version of the expected configuration of the
+// target, or an empty string if it's unknown.
+func (c *Cache) version(ctx context.Context, target string) string {
+	if c.Version == nil {
+		return ""
+	}
+	version, err := c.Version(ctx, target)
+	if err != nil {
+		log.WithField("target", target).WithError(err).Warn(
+			"Cannot get the version of the expected configuration")
+		return ""
+	}
+	return version
+}
+
+// poll fetches the version of the expected configuration of every cached
+// target and invalidates the results checked with another version, or with
+// an unknown one.
+func (c *Cache) poll(ctx context.Context) {
+	c.mu.Lock()
+	targets := make([]string, 0, len(c.entries))
+	for target := range c.entries {
+		targets = append(targets, target)
+	}
+	c.mu.Unlock()
+
+	for _, target := range targets {
+		version, err := c.Version(ctx, target)
+		if err != nil {
+			log.WithField("target", target).WithError(err).Warn(
+				"Cannot get the version of the expected configuration")
+			continue
+		}
+
+		c.mu.Lock()
+		e, ok := c.entries[target]
+		if ok && e.result != nil && e.version != version {
+			e.result = nil
+			cacheInvalidations.WithLabelValues(invalidatedConfig).Inc()
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCache_Invalidate(t *testing.T) {
+	const abc, xyz = "s1-abc01.measurement-lab.org", "s1-xyz01.measurement-lab.org"
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	f := &fakeCheck{statuses: []string{configMatches}}
+	get := func(target string) string {
+		_, lookup, _ := c.Get(context.Background(), target, false, f.check)
+		return lookup
+	}
+	get(abc)
+	get(xyz)
+
+	if n := c.Invalidate(abc); n != 1 {
+		t.Errorf("Invalidate() = %d, want 1", n)
+	}
+	if got := get(abc); got != cacheMiss {
+		t.Errorf("Get() after Invalidate() = %s, want %s", got, cacheMiss)
+	}
+	if got := get(xyz); got != cacheHit {
+		t.Errorf("Get() for another target = %s, want %s", got, cacheHit)
+	}
+
+	c.Invalidate(abc)
+	if n := c.Invalidate(""); n != 1 {
+		t.Errorf("Invalidate() = %d, want 1", n)
+	}
+	for _, target := range []string{abc, xyz} {
+		if got := get(target); got != cacheMiss {
+			t.Errorf("Get() after Invalidate() = %s, want %s", got, cacheMiss)
+		}
+	}
+}
+
+func TestCache_ServeInvalidate(t *testing.T) {
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	f := &fakeCheck{statuses: []string{configMatches}}
+	c.Get(context.Background(), "s1-abc01.measurement-lab.org", false, f.check)
+
+	before := testutil.ToFloat64(cacheInvalidations.WithLabelValues(invalidatedAPI))
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{
+			name:   "wrong-method",
+			method: http.MethodGet,
+			url:    "/v1/cache/invalidate?target=s1-abc01.measurement-lab.org",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "unknown-target",
+			method: http.MethodPost,
+			url:    "/v1/cache/invalidate?target=s1-xyz01.measurement-lab.org",
+			status: http.StatusOK,
+			body:   `{"invalidated":0}`,
+		},
+		{
+			name:   "target",
+			method: http.MethodPost,
+			url:    "/v1/cache/invalidate?target=s1-abc01.measurement-lab.org",
+			status: http.StatusOK,
+			body:   `{"invalidated":1}`,
+		},
+		{
+			name:   "already-invalidated",
+			method: http.MethodPost,
+			url:    "/v1/cache/invalidate",
+			status: http.StatusOK,
+			body:   `{"invalidated":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c.ServeInvalidate(rec, httptest.NewRequest(tt.method, tt.url, nil))
+			if rec.Code != tt.status {
+				t.Errorf("ServeInvalidate() status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.body {
+				t.Errorf("ServeInvalidate() body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+	after := testutil.ToFloat64(cacheInvalidations.WithLabelValues(invalidatedAPI))
+	if after-before != 1 {
+		t.Errorf("invalidations = %v, want 1", after-before)
+	}
+}
+
+func TestCache_poll(t *testing.T) {
+	const abc, xyz = "s1-abc01.measurement-lab.org", "s1-xyz01.measurement-lab.org"
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	version := "1"
+	c.Version = func(_ context.Context, target string) (string, error) {
+		if target == xyz {
+			return "", errors.New("version error")
+		}
+		return version, nil
+	}
+	f := &fakeCheck{statuses: []string{configMatches}}
+	get := func(target string) string {
+		_, lookup, _ := c.Get(context.Background(), target, false, f.check)
+		return lookup
+	}
+	get(abc)
+	get(xyz)
+
+	// The version is recorded when checking.
+	c.poll(context.Background())
+	if got := get(abc); got != cacheHit {
+		t.Errorf("Get() = %s, want %s", got, cacheHit)
+	}
+
+	version = "2"
+	c.poll(context.Background())
+	if got := get(abc); got != cacheMiss {
+		t.Errorf("Get() after a version change = %s, want %s", got, cacheMiss)
+	}
+	if got := get(abc); got != cacheHit {
+		t.Errorf("Get() = %s, want %s", got, cacheHit)
+	}
+	if got := get(xyz); got != cacheHit {
+		t.Errorf("Get() without a version = %s, want %s", got, cacheHit)
+	}
+
+	// A version changing between the check and the first poll invalidates
+	// the result.
+	const def = "s1-def01.measurement-lab.org"
+	get(def)
+	version = "3"
+	c.poll(context.Background())
+	if got := get(def); got != cacheMiss {
+		t.Errorf("Get() after a version change before polling = %s, want %s",
+			got, cacheMiss)
+	}
+}
+
+func TestCache_Run(t *testing.T) {
+	c := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour})
+	// Without Version, Run returns immediately.
+	c.Run(context.Background(), time.Millisecond)
+
+	f := &fakeCheck{statuses: []string{configMatches}}
+	c.Get(context.Background(), "s1-abc01.measurement-lab.org", false, f.check)
+	polled := make(chan struct{}, 1)
+	c.Version = func(context.Context, string) (string, error) {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+		return "1", nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+	<-polled
+	cancel()
+	<-done
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Remediation outcomes.
+const (
+	remediationDryRun      = "dry_run"
+	remediationNoChanges   = "no_changes"
+	remediationCommitted   = "committed"
+	remediationUnconfirmed = "unconfirmed"
+	remediationFailed      = "failed"
+)
+
+// remediationComment is the log message of the commits made by remediation.
+const remediationComment = "switch-monitoring: restore the archived configuration"
+
+// remediationTimeout is the maximum duration of a remediation, from reading
+// the expected configuration to confirming the commit.
+const remediationTimeout = 5 * time.Minute
+
+var remediations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "switch_monitoring_remediations_total",
+	Help: "Number of attempts to restore the expected configuration of a switch",
+}, []string{"target", "outcome"})
+
+// Remediator pushes a configuration to a switch.
+type Remediator interface {
+	Remediate(ctx context.Context, hostname, config string,
+		opts netconf.RemediateOptions) (*netconf.Remediation, error)
+}
+
+// remediateResponse is the JSON document returned by the /remediate
+// endpoint.
+type remediateResponse struct {
+	Target string `json:"target"`
+	DryRun bool   `json:"dry_run"`
+	Error  string `json:"error,omitempty"`
+	*netconf.Remediation
+}
+
+// ServeRemediate handles POST requests to the /remediate endpoint. It pushes
+// the expected configuration to the target, or only returns the differences
+// the push would make if the dry_run parameter is true. The statements
+// ignored or masked by the rules of the target's site are left as they are.
+// Only JunOS switches are supported.
+func (h *Handler) ServeRemediate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if len(target) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("URL parameter 'target' is missing"))
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeError(w, fmt.Errorf("invalid dry_run: %v", err),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	config, status, err := h.configFor(target)
+	if err != nil {
+		writeError(w, err, status)
+		return
+	}
+	remediator, ok := config.Netconf.(Remediator)
+	if !ok || (h.Drivers != nil && h.Drivers.Vendor(target) != driver.Juniper) {
+		writeError(w, fmt.Errorf("remediation is not supported for %s", target),
+			http.StatusNotImplemented)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), remediationTimeout)
+	defer cancel()
+	expected, err := config.Provider.Get(ctx)
+	if err != nil {
+		writeError(w, err, http.StatusBadGateway)
+		return
+	}
+
+	result, err := remediator.Remediate(ctx, target, string(expected),
+		netconf.RemediateOptions{
+			DryRun:         dryRun,
+			ConfirmMinutes: h.ConfirmMinutes,
+			Comment:        remediationComment,
+			Rules:          config.Rules,
+		})
+	outcome := remediationOutcome(dryRun, result, err)
+	remediations.WithLabelValues(target, outcome).Inc()
+
+	resp := remediateResponse{
+		Target:      target,
+		DryRun:      dryRun,
+		Remediation: result,
+	}
+	fields := log.Fields{"target": target, "outcome": outcome}
+	status = http.StatusOK
+	if err != nil {
+		log.WithFields(fields).WithError(err).Error("Remediation failed")
+		resp.Error = err.Error()
+		status = http.StatusBadGateway
+	} else {
+		log.WithFields(fields).Info("Remediation completed")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func remediationOutcome(dryRun bool, result *netconf.Remediation, err error) string {
+	switch {
+	case result != nil && result.Committed && !result.Confirmed:
+		return remediationUnconfirmed
+	case err != nil:
+		return remediationFailed
+	case dryRun:
+		return remediationDryRun
+	case !result.Committed:
+		return remediationNoChanges
+	default:
+		return remediationCommitted
+	}
+}
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/m-lab/go/content"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	ncfg "github.com/m-lab/switch-monitoring/internal/netconf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// remediatingProvider is a mock NetconfClient that can push configurations.
+type remediatingProvider struct {
+	netconfProvider
+	result *ncfg.Remediation
+	err    error
+	config string
+	opts   ncfg.RemediateOptions
+	// deadline is true if the context had a deadline.
+	deadline bool
+}
+
+func (p *remediatingProvider) Remediate(ctx context.Context, hostname, config string,
+	opts ncfg.RemediateOptions) (*ncfg.Remediation, error) {
+	p.config = config
+	p.opts = opts
+	_, p.deadline = ctx.Deadline()
+	return p.result, p.err
+}
+
+func TestHandler_ServeRemediate(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		netconf *remediatingProvider
+		vendor  string
+		status  int
+		outcome string
+		dryRun  bool
+	}{
+		{
+			name:    "committed",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc01",
+			netconf: &remediatingProvider{result: &ncfg.Remediation{Diff: "+x", Committed: true, Confirmed: true}},
+			status:  http.StatusOK,
+			outcome: remediationCommitted,
+		},
+		{
+			name:    "dry-run",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc02&dry_run=true",
+			netconf: &remediatingProvider{result: &ncfg.Remediation{Diff: "+x"}},
+			status:  http.StatusOK,
+			outcome: remediationDryRun,
+			dryRun:  true,
+		},
+		{
+			name:    "no-changes",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc03",
+			netconf: &remediatingProvider{result: &ncfg.Remediation{}},
+			status:  http.StatusOK,
+			outcome: remediationNoChanges,
+		},
+		{
+			name:    "failed",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc04",
+			netconf: &remediatingProvider{err: fmt.Errorf("commit check failed")},
+			status:  http.StatusBadGateway,
+			outcome: remediationFailed,
+		},
+		{
+			name:   "unconfirmed",
+			method: "POST",
+			url:    "/v1/remediate?target=s1-abc05",
+			netconf: &remediatingProvider{
+				result: &ncfg.Remediation{Committed: true},
+				err:    fmt.Errorf("cannot confirm commit"),
+			},
+			status:  http.StatusBadGateway,
+			outcome: remediationUnconfirmed,
+		},
+		{
+			name:    "unsupported-vendor",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc06",
+			netconf: &remediatingProvider{},
+			vendor:  driver.Generic,
+			status:  http.StatusNotImplemented,
+		},
+		{
+			name:    "invalid-dry-run",
+			method:  "POST",
+			url:     "/v1/remediate?target=s1-abc01&dry_run=maybe",
+			netconf: &remediatingProvider{},
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "missing-target",
+			method:  "POST",
+			url:     "/v1/remediate",
+			netconf: &remediatingProvider{},
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "method-not-allowed",
+			method:  "GET",
+			url:     "/v1/remediate?target=s1-abc01",
+			netconf: &remediatingProvider{},
+			status:  http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drivers := driver.NewRegistry(driver.Juniper)
+			drivers.Register(driver.Juniper, driver.Driver{Netconf: tt.netconf})
+			drivers.Register(driver.Generic, driver.Driver{Netconf: tt.netconf})
+			if tt.vendor != "" {
+				drivers.Default = tt.vendor
+			}
+			h := NewHandler("test", tt.netconf)
+			h.Drivers = drivers
+			h.ConfirmMinutes = 5
+			h.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+				return &contentProvider{filepath: "testdata/abc01.conf"}, nil
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeRemediate(rr, httptest.NewRequest(tt.method, tt.url, nil))
+			if rr.Code != tt.status {
+				t.Errorf("ServeRemediate() returned %d, want %d: %s", rr.Code,
+					tt.status, rr.Body.String())
+			}
+			if tt.outcome == "" {
+				return
+			}
+
+			var resp remediateResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("ServeRemediate() returned invalid JSON: %v", err)
+			}
+			if resp.DryRun != tt.dryRun || (resp.Error != "") != (tt.netconf.err != nil) {
+				t.Errorf("ServeRemediate() = %+v", resp)
+			}
+			if tt.netconf.config == "" || tt.netconf.opts.DryRun != tt.dryRun ||
+				tt.netconf.opts.ConfirmMinutes != 5 || tt.netconf.opts.Rules == nil ||
+				!tt.netconf.deadline {
+				t.Errorf("Remediate() called with %+v", tt.netconf.opts)
+			}
+			target := resp.Target
+			if v := testutil.ToFloat64(remediations.WithLabelValues(target, tt.outcome)); v != 1 {
+				t.Errorf("remediations{%s, %s} = %v, want 1", target, tt.outcome, v)
+			}
+		})
+	}
+}
+
+func TestHandler_ServeRemediateWithoutRemediator(t *testing.T) {
+	h := NewHandler("test", &netconfProvider{filepath: "testdata/abc01.conf"})
+	h.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc01.conf"}, nil
+	}
+	rr := httptest.NewRecorder()
+	h.ServeRemediate(rr, httptest.NewRequest("POST", "/v1/remediate?target=s1-abc01", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("ServeRemediate() returned %d, want %d", rr.Code,
+			http.StatusNotImplemented)
+	}
+
+	// The expected configuration cannot be read.
+	h = NewHandler("test", &remediatingProvider{})
+	h.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{fail: true}, nil
+	}
+	rr = httptest.NewRecorder()
+	h.ServeRemediate(rr, httptest.NewRequest("POST", "/v1/remediate?target=s1-abc01", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("ServeRemediate() returned %d, want %d", rr.Code,
+			http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,275 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+)
+
+// notChecked is the status reported for the switches without a cached
+// result.
+const notChecked = "not_checked"
+
+// targetReport is the report on the last check of a single target.
+type targetReport struct {
+	Target string `json:"target"`
+	Status string `json:"status"`
+	Match  bool   `json:"match"`
+	// Time is when the target was checked.
+	Time *time.Time `json:"time,omitempty"`
+	// Error describes why the configurations could not be compared.
+	Error string `json:"error,omitempty"`
+	// Diff is the difference between the expected and the actual
+	// configuration, if they don't match.
+	Diff *netconf.Diff `json:"diff,omitempty"`
+}
+
+// fleetReport is the report on every known or cached target.
+type fleetReport struct {
+	// Summary is the number of targets by status.
+	Summary map[string]int `json:"summary"`
+	Targets []targetReport `json:"targets"`
+}
+
+// ServeReport handles GET requests to the /report endpoint. With a target
+// parameter, it checks the target, unless its result is cached, and reports
+// its status along with the differences from the expected configuration.
+// Otherwise, it reports the cached status of every switch listed by Targets
+// or in the Cache.
+//
+// The report is a JSON document if the format parameter is "json" or if the
+// request accepts application/json. Otherwise, it's an HTML page.
+func (h *Handler) ServeReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var report interface{}
+	var page *template.Template
+	var status int
+	var err error
+	if target := r.URL.Query().Get("target"); target != "" {
+		report, status, err = h.targetReport(r, target)
+		page = targetPage
+	} else {
+		report, status, err = h.fleetReport(r.Context())
+		page = fleetPage
+	}
+	if err != nil {
+		writeError(w, err, status)
+		return
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := page.Execute(w, report); err != nil {
+		log.WithError(err).Error("Cannot render the report")
+	}
+}
+
+// targetReport checks a single target, unless its result is cached, and
+// reports the differences found by the check. If it fails, the returned
+// status is the HTTP status code describing the failure.
+func (h *Handler) targetReport(r *http.Request, target string) (*targetReport, int, error) {
+	ctx, cancel := ScrapeContext(r)
+	defer cancel()
+
+	result, _, err := h.check(ctx, target, false)
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		var se *statusError
+		if errors.As(err, &se) {
+			status = se.status
+		}
+		return nil, status, err
+	}
+
+	report := newTargetReport(target, result)
+	report.Diff = result.Diff
+	return &report, http.StatusOK, nil
+}
+
+// fleetReport returns the cached status of every target. If it fails, the
+// returned status is the HTTP status code describing the failure.
+func (h *Handler) fleetReport(ctx context.Context) (*fleetReport, int, error) {
+	if h.Cache == nil {
+		return nil, http.StatusNotImplemented,
+			errors.New("the fleet report requires the cache")
+	}
+
+	results := h.Cache.Results()
+	targets := make(map[string]bool, len(results))
+	for target := range results {
+		targets[target] = true
+	}
+	if h.Targets != nil {
+		known, err := h.Targets(ctx)
+		if err != nil {
+			return nil, http.StatusServiceUnavailable, err
+		}
+		for _, target := range known {
+			targets[target] = true
+		}
+	}
+
+	report := &fleetReport{
+		Summary: map[string]int{},
+		Targets: make([]targetReport, 0, len(targets)),
+	}
+	for target := range targets {
+		t := targetReport{Target: target, Status: notChecked}
+		if result, ok := results[target]; ok {
+			t = newTargetReport(target, result)
+		}
+		report.Summary[t.Status]++
+		report.Targets = append(report.Targets, t)
+	}
+	sort.Slice(report.Targets, func(i, j int) bool {
+		return report.Targets[i].Target < report.Targets[j].Target
+	})
+	return report, http.StatusOK, nil
+}
+
+func newTargetReport(target string, result *Result) targetReport {
+	t := result.Time
+	return targetReport{
+		Target: target,
+		Status: result.Status,
+		Match:  result.Status == configMatches,
+		Time:   &t,
+		Error:  result.Error,
+	}
+}
+
+// wantsJSON returns true if the report must be returned as JSON rather than
+// HTML.
+func wantsJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		switch mediaType {
+		case "text/html":
+			return false
+		case "application/json":
+			return true
+		}
+	}
+	return false
+}
+
+// diffLine is a line of a unified diff, with the CSS class highlighting it.
+type diffLine struct {
+	Class string
+	Text  string
+}
+
+// diffLines splits a unified diff into lines, classifying them as added,
+// removed or hunk headers.
+func diffLines(unified string) []diffLine {
+	var lines []diffLine
+	for _, text := range strings.Split(strings.TrimRight(unified, "\n"), "\n") {
+		class := ""
+		switch {
+		case strings.HasPrefix(text, "+++"), strings.HasPrefix(text, "---"):
+			class = "file"
+		case strings.HasPrefix(text, "+"):
+			class = "added"
+		case strings.HasPrefix(text, "-"):
+			class = "removed"
+		case strings.HasPrefix(text, "@@"):
+			class = "hunk"
+		}
+		lines = append(lines, diffLine{Class: class, Text: text})
+	}
+	return lines
+}
+
+// statusClass returns the CSS class of a status.
+func statusClass(status string) string {
+	switch status {
+	case configMatches:
+		return "ok"
+	case configMismatch:
+		return "mismatch"
+	case notChecked:
+		return "unknown"
+	}
+	return "error"
+}
+
+var reportFuncs = template.FuncMap{
+	"diffLines":   diffLines,
+	"statusClass": statusClass,
+	"rfc3339": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+}
+
+const reportStyle = `<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.ok { color: #1a7f37; }
+.mismatch { color: #bf8700; }
+.error { color: #cf222e; }
+.unknown { color: #6e7781; }
+pre { background: #f6f8fa; padding: 1em; }
+pre .added { color: #1a7f37; }
+pre .removed { color: #cf222e; }
+pre .hunk { color: #0969da; }
+pre .file { font-weight: bold; }
+</style>`
+
+var fleetPage = template.Must(template.New("fleet").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Switch configuration report</title>` + reportStyle + `</head>
+<body>
+<h1>Switch configuration report</h1>
+<p>{{range $status, $n := .Summary}}<span class="{{statusClass $status}}">{{$status}}: {{$n}}</span> {{end}}</p>
+<table>
+<tr><th>Switch</th><th>Status</th><th>Checked</th><th>Error</th></tr>
+{{range .Targets}}<tr>
+<td><a href="?target={{.Target}}">{{.Target}}</a></td>
+<td class="{{statusClass .Status}}">{{.Status}}</td>
+<td>{{rfc3339 .Time}}</td>
+<td>{{.Error}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var targetPage = template.Must(template.New("target").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Target}}</title>` + reportStyle + `</head>
+<body>
+<h1>{{.Target}}</h1>
+<p>Status: <span class="{{statusClass .Status}}">{{.Status}}</span></p>
+<p>Checked: {{rfc3339 .Time}}</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Diff}}<pre>{{range diffLines .Diff.Unified}}<span class="{{.Class}}">{{.Text}}</span>
+{{end}}</pre>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestHandler_ServeReport(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	rtx.Must(err, "Cannot get testdata path")
+	known := func(context.Context) ([]string, error) {
+		return []string{"s1-abc01", "s1-abc02", "s1-def01"}, nil
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		accept  string
+		method  string
+		noCache bool
+		// offline makes the switch unreachable.
+		offline  bool
+		targets  func(context.Context) ([]string, error)
+		status   int
+		wantType string
+		want     []string
+	}{
+		{
+			name:     "target-match-json",
+			url:      "/v1/report?target=s1-abc01",
+			accept:   "application/json",
+			status:   http.StatusOK,
+			wantType: "application/json",
+			want:     []string{`"target":"s1-abc01"`, `"status":"ok"`, `"match":true`},
+		},
+		{
+			name:     "target-mismatch-html",
+			url:      "/v1/report?target=s1-abc02",
+			accept:   "text/html,application/xhtml+xml,application/json;q=0.9",
+			status:   http.StatusOK,
+			wantType: "text/html; charset=utf-8",
+			want: []string{
+				`<span class="mismatch">config_mismatch</span>`,
+				`<span class="removed">-    host-name s1.abc02.measurement-lab.org;</span>`,
+				`<span class="added">&#43;    host-name s1.lga0t.measurement-lab.org;</span>`,
+			},
+		},
+		{
+			name:     "target-mismatch-cached",
+			url:      "/v1/report?target=s1-abc02&format=json",
+			offline:  true,
+			status:   http.StatusOK,
+			wantType: "application/json",
+			want: []string{
+				`"status":"config_mismatch"`,
+				`+    host-name s1.lga0t.measurement-lab.org;`,
+			},
+		},
+		{
+			name:     "target-error-format-param",
+			url:      "/v1/report?target=s1-xyz01&format=json",
+			accept:   "text/html",
+			status:   http.StatusOK,
+			wantType: "application/json",
+			want:     []string{`"status":"config_not_found_gcs"`, `"error":"Could not os.Stat`},
+		},
+		{
+			name:     "fleet-json",
+			url:      "/v1/report?format=json",
+			targets:  known,
+			status:   http.StatusOK,
+			wantType: "application/json",
+			want: []string{
+				`"summary":{"config_mismatch":1,"config_not_found_gcs":1,"not_checked":1,"ok":1}`,
+				`{"target":"s1-def01","status":"not_checked","match":false}`,
+			},
+		},
+		{
+			name:     "fleet-html",
+			url:      "/v1/report",
+			status:   http.StatusOK,
+			wantType: "text/html; charset=utf-8",
+			want: []string{
+				`<a href="?target=s1-abc01">s1-abc01</a>`,
+				`<td class="mismatch">config_mismatch</td>`,
+			},
+		},
+		{
+			name:    "fleet-without-cache",
+			url:     "/v1/report",
+			noCache: true,
+			status:  http.StatusNotImplemented,
+		},
+		{
+			name: "fleet-inventory-error",
+			url:  "/v1/report",
+			targets: func(context.Context) ([]string, error) {
+				return nil, errors.New("inventory error")
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "invalid-target",
+			url:    "/v1/report?target=invalid",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "method-not-allowed",
+			url:    "/v1/report",
+			method: http.MethodPost,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+
+	// The cache is shared, so that the fleet report contains the targets
+	// checked before.
+	cache := NewCache(CacheConfig{Capacity: 10, MatchTTL: time.Hour,
+		MismatchTTL: time.Hour})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler("test", &netconfProvider{
+				filepath: "testdata/abc01.conf",
+				fail:     tt.offline,
+			})
+			h.ConfigURL = "file://" + dir + "/"
+			h.Targets = tt.targets
+			if !tt.noCache {
+				h.Cache = cache
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.url, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeReport(rr, r)
+			if rr.Code != tt.status {
+				t.Fatalf("ServeReport() status = %d, want %d: %s", rr.Code,
+					tt.status, rr.Body.String())
+			}
+			if tt.wantType != "" && rr.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("ServeReport() Content-Type = %q, want %q",
+					rr.Header().Get("Content-Type"), tt.wantType)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rr.Body.String(), want) {
+					t.Errorf("ServeReport() body does not contain %s:\n%s", want,
+						rr.Body.String())
+				}
+			}
+			if tt.wantType == "application/json" {
+				var v interface{}
+				if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil {
+					t.Errorf("ServeReport() returned invalid JSON: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func Test_diffLines(t *testing.T) {
+	unified := "--- expected\n+++ actual\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n"
+	want := []diffLine{
+		{Class: "file", Text: "--- expected"},
+		{Class: "file", Text: "+++ actual"},
+		{Class: "hunk", Text: "@@ -1,2 +1,2 @@"},
+		{Text: " a"},
+		{Class: "removed", Text: "-b"},
+		{Class: "added", Text: "+c"},
+	}
+	if got := diffLines(unified); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SchedulerConfig configures how often and how many switches are checked by
+// a Scheduler.
+type SchedulerConfig struct {
+	// Interval is the time between the start of two consecutive rounds of
+	// checks. A round that takes longer than Interval delays the next one.
+	Interval time.Duration
+
+	// Concurrency is the maximum number of switches checked at the same
+	// time.
+	Concurrency int
+
+	// Jitter is the maximum random delay before each check, so that the
+	// checks are spread over time rather than starting all together.
+	Jitter time.Duration
+
+	// Timeout is the maximum duration of a single check. If zero, Interval
+	// is used.
+	Timeout time.Duration
+
+	// Targets returns the switches to check. It's called at the beginning of
+	// every round.
+	Targets func(context.Context) ([]string, error)
+}
+
+// Scheduler periodically checks every switch in the background and keeps the
+// last result for each of them in memory. It implements prometheus.Collector
+// so that all the results can be served from a single /metrics endpoint
+// without waiting for the switches.
+type Scheduler struct {
+	handler *Handler
+	config  SchedulerConfig
+
+	mu      sync.RWMutex
+	results map[string][]prometheus.Metric
+
+	// jitter returns the delay before checking a switch.
+	jitter func() time.Duration
+}
+
+// NewScheduler returns a Scheduler checking switches with the same
+// configuration as the provided Handler.
+func NewScheduler(h *Handler, config SchedulerConfig) *Scheduler {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = config.Interval
+	}
+	s := &Scheduler{
+		handler: h,
+		config:  config,
+		results: map[string][]prometheus.Metric{},
+	}
+	s.jitter = func() time.Duration {
+		if s.config.Jitter <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(s.config.Jitter)))
+	}
+	return s
+}
+
+// Run checks all the switches every Interval until the context is canceled.
+// The first round starts immediately.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		s.round(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// round checks every target once, with bounded concurrency. Results for
+// targets that are not returned by Targets anymore are removed.
+func (s *Scheduler) round(ctx context.Context) {
+	targets, err := s.config.Targets(ctx)
+	if err != nil {
+		log.WithError(err).Error("Cannot list the switches to check")
+		return
+	}
+	s.prune(targets)
+
+	slots := make(chan struct{}, s.config.Concurrency)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.check(ctx, target)
+		}(t)
+	}
+	wg.Wait()
+}
+
+// check waits for a random delay, then checks a single target and stores
+// the resulting metrics. If the context is canceled in the meantime, the
+// previous result is kept.
+func (s *Scheduler) check(ctx context.Context, target string) {
+	select {
+	case <-time.After(s.jitter()):
+	case <-ctx.Done():
+		return
+	}
+
+	config, _, err := s.handler.configFor(target)
+	if err != nil {
+		log.WithField("target", target).WithError(err).Error(
+			"Cannot check switch")
+		return
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	metrics := gather(New(checkCtx, target, config))
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.results[target] = metrics
+	s.mu.Unlock()
+}
+
+// prune removes the results for targets not in the provided list.
+func (s *Scheduler) prune(targets []string) {
+	keep := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		keep[t] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.results {
+		if !keep[t] {
+			delete(s.results, t)
+		}
+	}
+}
+
+// Describe sends no descriptors, making the Scheduler an unchecked
+// collector, since the metrics depend on the checked switches.
+func (s *Scheduler) Describe(chan<- *prometheus.Desc) {}
+
+// Collect sends the last stored result for every switch.
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, metrics := range s.results {
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+}
+
+// gather collects all the metrics from a collector.
+func gather(c prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
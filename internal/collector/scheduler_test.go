@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/content"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// concurrentNetconf is a netconfProvider recording the maximum number of
+// concurrent calls.
+type concurrentNetconf struct {
+	netconfProvider
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (n *concurrentNetconf) GetConfig(ctx context.Context, hostname string, sections ...string) (string, error) {
+	n.mu.Lock()
+	n.inFlight++
+	if n.inFlight > n.max {
+		n.max = n.inFlight
+	}
+	n.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	n.mu.Lock()
+	n.inFlight--
+	n.mu.Unlock()
+	return n.netconfProvider.GetConfig(ctx, hostname, sections...)
+}
+
+func newTestScheduler(netconf *concurrentNetconf, targets *[]string) *Scheduler {
+	h := NewHandler("test", netconf)
+	h.getConfigFunc = func(context.Context, *url.URL) (content.Provider, error) {
+		return &contentProvider{filepath: "testdata/abc01.conf"}, nil
+	}
+	return NewScheduler(h, SchedulerConfig{
+		Interval:    time.Hour,
+		Concurrency: 2,
+		Targets: func(context.Context) ([]string, error) {
+			if targets == nil {
+				return nil, errors.New("error")
+			}
+			return *targets, nil
+		},
+	})
+}
+
+func TestScheduler_round(t *testing.T) {
+	metadata := `# HELP switch_monitoring_config_match Configuration check result for this target
+# TYPE switch_monitoring_config_match gauge
+`
+	netconf := &concurrentNetconf{
+		netconfProvider: netconfProvider{filepath: "testdata/abc01.conf"},
+	}
+	targets := []string{"s1-abc01", "s1-abc02", "s1-abc03", "invalid"}
+	s := newTestScheduler(netconf, &targets)
+
+	s.round(context.Background())
+	expected := metadata + `
+switch_monitoring_config_match{status="ok",target="s1-abc01"} 1
+switch_monitoring_config_match{status="ok",target="s1-abc02"} 1
+switch_monitoring_config_match{status="ok",target="s1-abc03"} 1
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(expected),
+		"switch_monitoring_config_match"); err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+	if netconf.max != 2 {
+		t.Errorf("max concurrent checks = %d, want 2", netconf.max)
+	}
+
+	// Targets that are not listed anymore are removed.
+	targets = []string{"s1-abc01"}
+	s.round(context.Background())
+	expected = metadata + `
+switch_monitoring_config_match{status="ok",target="s1-abc01"} 1
+`
+	if err := testutil.CollectAndCompare(s, strings.NewReader(expected),
+		"switch_monitoring_config_match"); err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+
+	// If the targets cannot be listed, the previous results are kept.
+	s.config.Targets = newTestScheduler(netconf, nil).config.Targets
+	s.round(context.Background())
+	if err := testutil.CollectAndCompare(s, strings.NewReader(expected),
+		"switch_monitoring_config_match"); err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	netconf := &concurrentNetconf{
+		netconfProvider: netconfProvider{filepath: "testdata/abc01.conf"},
+	}
+	targets := []string{"s1-abc01", "s1-abc02", "s1-abc03"}
+	s := newTestScheduler(netconf, &targets)
+	s.jitter = func() time.Duration { return time.Hour }
+
+	// When the context is canceled, Run returns without waiting for the
+	// pending checks and no results are stored.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Run() did not return after the context was canceled")
+	}
+	if n := testutil.CollectAndCount(s); n != 0 {
+		t.Errorf("Collect() returned %d metrics, want 0", n)
+	}
+}
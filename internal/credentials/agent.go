@@ -0,0 +1,61 @@
+package credentials
+
+import (
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Agent gets the keys from a running ssh-agent, e.g. one holding keys stored
+// on a hardware token. The connection to the agent is kept open, and it's
+// established again if the agent fails.
+type Agent struct {
+	socket string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	client agent.Agent
+}
+
+// NewAgent returns an Agent connecting to the agent listening on the
+// provided socket, usually $SSH_AUTH_SOCK.
+func NewAgent(socket string) *Agent {
+	return &Agent{socket: socket}
+}
+
+// Signers returns the keys held by the agent, including their certificates.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.client == nil {
+		conn, err := net.Dial("unix", a.socket)
+		if err != nil {
+			return nil, err
+		}
+		a.conn = conn
+		a.client = agent.NewClient(conn)
+	}
+	signers, err := a.client.Signers()
+	if err != nil {
+		a.close()
+	}
+	return signers, err
+}
+
+// Close closes the connection to the agent.
+func (a *Agent) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.close()
+}
+
+// close closes the connection to the agent. a.mu must be held.
+func (a *Agent) close() {
+	if a.conn != nil {
+		a.conn.Close()
+	}
+	a.conn = nil
+	a.client = nil
+}
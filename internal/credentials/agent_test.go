@@ -0,0 +1,69 @@
+package credentials
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveAgent serves a keyring holding the key on a Unix socket, until the
+// returned listener is closed.
+func serveAgent(t *testing.T, key interface{}) (string, net.Listener) {
+	keyring := agent.NewKeyring()
+	rtx.Must(keyring.Add(agent.AddedKey{PrivateKey: key}), "Cannot add key")
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	rtx.Must(err, "Cannot listen on %s", socket)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	return socket, l
+}
+
+func TestAgent(t *testing.T) {
+	key, _ := newKey("")
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	rtx.Must(err, "Cannot get public key")
+	socket, l := serveAgent(t, key)
+	defer l.Close()
+
+	a := NewAgent(socket)
+	defer a.Close()
+	signers, err := a.Signers()
+	if err != nil || len(signers) != 1 {
+		t.Fatalf("Signers() = %v, %v, want 1 signer", signers, err)
+	}
+
+	config := Config{Username: testUser, Agent: a}
+	creds, err := config.Credentials(context.Background(), "s1-abc01")
+	rtx.Must(err, "Cannot get credentials")
+	if err := (testServer{key: pub}).handshake(t, creds); err != nil {
+		t.Errorf("handshake() with the agent's key error = %v", err)
+	}
+
+	// After the agent fails, the connection is established again.
+	a.mu.Lock()
+	a.conn.Close()
+	a.mu.Unlock()
+	if _, err := a.Signers(); err == nil {
+		t.Errorf("Signers() on a closed connection succeeded")
+	}
+	if signers, err := a.Signers(); err != nil || len(signers) != 1 {
+		t.Errorf("Signers() after reconnecting = %v, %v, want 1 signer", signers, err)
+	}
+
+	if _, err := NewAgent(filepath.Join(t.TempDir(), "missing.sock")).Signers(); err == nil {
+		t.Errorf("Signers() without an agent succeeded")
+	}
+}
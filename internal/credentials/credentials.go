@@ -0,0 +1,153 @@
+// Package credentials provides the SSH credentials used to connect to the
+// switches: private keys, with or without a certificate signed by a CA, keys
+// held by ssh-agent and passwords, read from files, the environment or a
+// secret store.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/apex/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNoMethod is returned when no authentication method is configured.
+var ErrNoMethod = errors.New("no SSH authentication method configured")
+
+// Credentials are the username and the methods used to authenticate to a
+// switch, tried in order.
+type Credentials struct {
+	Username string
+	Methods  []ssh.AuthMethod
+}
+
+// Provider returns the credentials used to connect to a switch.
+type Provider interface {
+	Credentials(ctx context.Context, host string) (*Credentials, error)
+}
+
+// Key is a private key, optionally encrypted or with a certificate signed by
+// a CA trusted by the switches.
+type Key struct {
+	// Private is the private key, in PEM format.
+	Private Secret
+	// Passphrase decrypts the private key. If nil, the key must not be
+	// encrypted.
+	Passphrase Secret
+	// Certificate, if set, is the certificate of the key in the
+	// authorized_keys format, as written by ssh-keygen -s.
+	Certificate Secret
+}
+
+// Signer reads the key and returns a signer authenticating with it, or with
+// its certificate if there is one.
+func (k Key) Signer(ctx context.Context) (ssh.Signer, error) {
+	if k.Private == nil {
+		return nil, errors.New("no private key specified")
+	}
+	pem, err := k.Private.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var signer ssh.Signer
+	if k.Passphrase != nil {
+		passphrase, err := readPassword(ctx, k.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the passphrase: %w", err)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	} else if signer, err = ssh.ParsePrivateKey(pem); err != nil {
+		return nil, err
+	}
+	if k.Certificate == nil {
+		return signer, nil
+	}
+
+	data, err := k.Certificate.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the certificate: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("not an SSH certificate")
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// Config is a Provider returning the same credentials for every switch. The
+// keys are tried first, then the ones held by the agent and finally the
+// password.
+type Config struct {
+	Username string
+	Keys     []Key
+	// Agent, if set, provides additional keys.
+	Agent *Agent
+	// Password, if set, is used for password and keyboard-interactive
+	// authentication.
+	Password Secret
+}
+
+// Credentials returns the credentials configured for all the switches,
+// reading the secrets. The Config returned by Load holds the values read
+// then, so that rotated secrets are only used once the credentials are
+// reloaded.
+func (c *Config) Credentials(ctx context.Context, _ string) (*Credentials, error) {
+	var methods []ssh.AuthMethod
+	if len(c.Keys) > 0 || c.Agent != nil {
+		signers := make([]ssh.Signer, 0, len(c.Keys))
+		for _, k := range c.Keys {
+			s, err := k.Signer(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load SSH key: %w", err)
+			}
+			signers = append(signers, s)
+		}
+		// The SSH client tries each kind of method only once, so the
+		// keys and the agent must share the same public key method.
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if c.Agent == nil {
+				return signers, nil
+			}
+			// If the agent is not available, the password can still
+			// be tried.
+			agentSigners, err := c.Agent.Signers()
+			if err != nil {
+				log.WithError(err).Warn("Cannot get the keys from ssh-agent")
+			}
+			return append(signers[:len(signers):len(signers)], agentSigners...), nil
+		}))
+	}
+	if c.Password != nil {
+		password, err := readPassword(ctx, c.Password)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the SSH password: %w", err)
+		}
+		methods = append(methods, ssh.Password(string(password)),
+			ssh.KeyboardInteractive(answerAll(string(password))))
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoMethod
+	}
+	return &Credentials{Username: c.Username, Methods: methods}, nil
+}
+
+// answerAll answers every keyboard-interactive question with the password,
+// since switches only ask for it.
+func answerAll(password string) ssh.KeyboardInteractiveChallenge {
+	return func(_, _ string, questions []string, _ []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range answers {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
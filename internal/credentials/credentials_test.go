@@ -0,0 +1,274 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/crypto/ssh"
+)
+
+// testUser is the only user accepted by testServer.
+const testUser = "admin"
+
+// newKey returns a new private key and its PEM encoding, encrypted if a
+// passphrase is provided.
+func newKey(passphrase string) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rtx.Must(err, "Cannot generate key")
+	der, err := x509.MarshalECPrivateKey(key)
+	rtx.Must(err, "Cannot marshal key")
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der,
+			[]byte(passphrase), x509.PEMCipherAES128)
+		rtx.Must(err, "Cannot encrypt key")
+	}
+	return key, pem.EncodeToMemory(block)
+}
+
+// newCertificate returns a user certificate for the key, signed by the CA, in
+// the authorized_keys format.
+func newCertificate(key *ecdsa.PrivateKey, ca ssh.Signer) []byte {
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	rtx.Must(err, "Cannot get public key")
+	cert := &ssh.Certificate{
+		Key:             pub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{testUser},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	rtx.Must(cert.SignCert(rand.Reader, ca), "Cannot sign certificate")
+	return ssh.MarshalAuthorizedKey(cert)
+}
+
+// newSigner returns a signer for a new key.
+func newSigner() ssh.Signer {
+	key, _ := newKey("")
+	signer, err := ssh.NewSignerFromKey(key)
+	rtx.Must(err, "Cannot create signer")
+	return signer
+}
+
+// testServer is an SSH server accepting a single key, the keys certified by
+// a CA and a password.
+type testServer struct {
+	key      ssh.PublicKey
+	ca       ssh.PublicKey
+	password string
+	// keyboard makes the server ask for the password with
+	// keyboard-interactive authentication.
+	keyboard bool
+}
+
+// handshake runs an SSH handshake with the server and returns the error of
+// the client.
+func (s testServer) handshake(t *testing.T, creds *Credentials) error {
+	config := &ssh.ServerConfig{}
+	config.AddHostKey(newSigner())
+	equal := func(a, b ssh.PublicKey) bool {
+		return a != nil && b != nil && bytes.Equal(a.Marshal(), b.Marshal())
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool { return equal(auth, s.ca) },
+		UserKeyFallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if equal(key, s.key) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.PublicKeyCallback = checker.Authenticate
+	if s.password != "" && s.keyboard {
+		config.KeyboardInteractiveCallback = func(_ ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "", []string{"Password: "}, []bool{false})
+			if err != nil || len(answers) != 1 || answers[0] != s.password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		}
+	} else if s.password != "" {
+		config.PasswordCallback = func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != s.password {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	rtx.Must(err, "Cannot listen")
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if sc, _, _, err := ssh.NewServerConn(conn, config); err == nil {
+			sc.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	rtx.Must(err, "Cannot connect")
+	defer conn.Close()
+	c, _, _, err := ssh.NewClientConn(conn, l.Addr().String(), &ssh.ClientConfig{
+		User:            creds.Username,
+		Auth:            creds.Methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		c.Close()
+	}
+	return err
+}
+
+func TestKey_Signer(t *testing.T) {
+	_, plain := newKey("")
+	_, encrypted := newKey("secret")
+	ca := newSigner()
+	key, pem := newKey("")
+	cert := newCertificate(key, ca)
+
+	tests := []struct {
+		name     string
+		key      Key
+		wantCert bool
+		wantErr  bool
+	}{
+		{name: "plain", key: Key{Private: Value(plain)}},
+		{name: "encrypted", key: Key{Private: Value(encrypted), Passphrase: Value("secret")}},
+		{name: "passphrase-newline", key: Key{Private: Value(encrypted), Passphrase: Value("secret\r\n")}},
+		{name: "certificate", key: Key{Private: Value(pem), Certificate: Value(cert)}, wantCert: true},
+		{name: "no-key", key: Key{}, wantErr: true},
+		{name: "missing-key", key: Key{Private: File("testdata/missing")}, wantErr: true},
+		{name: "invalid-key", key: Key{Private: Value("invalid")}, wantErr: true},
+		{name: "wrong-passphrase", key: Key{Private: Value(encrypted), Passphrase: Value("wrong")}, wantErr: true},
+		{name: "missing-passphrase", key: Key{Private: Value(encrypted)}, wantErr: true},
+		{name: "unreadable-passphrase", key: Key{Private: Value(encrypted), Passphrase: Env("CREDENTIALS_TEST_MISSING")}, wantErr: true},
+		{name: "invalid-certificate", key: Key{Private: Value(pem), Certificate: Value("invalid")}, wantErr: true},
+		{name: "not-a-certificate", key: Key{Private: Value(pem), Certificate: Value(ssh.MarshalAuthorizedKey(ca.PublicKey()))}, wantErr: true},
+		{name: "wrong-certificate", key: Key{Private: Value(plain), Certificate: Value(cert)}, wantErr: true},
+		{name: "unreadable-certificate", key: Key{Private: Value(pem), Certificate: File("testdata/missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := tt.key.Signer(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, ok := signer.PublicKey().(*ssh.Certificate); ok != tt.wantCert {
+				t.Errorf("Signer() returned a certificate = %v, want %v", ok, tt.wantCert)
+			}
+		})
+	}
+}
+
+func TestConfig_Credentials(t *testing.T) {
+	key, pem := newKey("")
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	rtx.Must(err, "Cannot get public key")
+	ca := newSigner()
+	certKey, certPEM := newKey("")
+	cert := newCertificate(certKey, ca)
+
+	tests := []struct {
+		name          string
+		config        Config
+		server        testServer
+		wantErr       bool
+		wantHandshake bool
+	}{
+		{
+			name:          "key",
+			config:        Config{Username: testUser, Keys: []Key{{Private: Value(pem)}}},
+			server:        testServer{key: pub},
+			wantHandshake: true,
+		},
+		{
+			name:          "certificate",
+			config:        Config{Username: testUser, Keys: []Key{{Private: Value(certPEM), Certificate: Value(cert)}}},
+			server:        testServer{ca: ca.PublicKey()},
+			wantHandshake: true,
+		},
+		{
+			name:   "password-fallback",
+			config: Config{Username: testUser, Keys: []Key{{Private: Value(pem)}}, Password: Value("secret")},
+			server: testServer{password: "secret"},
+
+			wantHandshake: true,
+		},
+		{
+			name:          "keyboard-interactive",
+			config:        Config{Username: testUser, Password: Value("secret")},
+			server:        testServer{password: "secret", keyboard: true},
+			wantHandshake: true,
+		},
+		{
+			name:          "password-newline",
+			config:        Config{Username: testUser, Password: Value("secret\n")},
+			server:        testServer{password: "secret"},
+			wantHandshake: true,
+		},
+		{
+			name:   "wrong-password",
+			config: Config{Username: testUser, Password: Value("wrong")},
+			server: testServer{password: "secret"},
+		},
+		{
+			name:   "unknown-key",
+			config: Config{Username: testUser, Keys: []Key{{Private: Value(certPEM)}}},
+			server: testServer{key: pub},
+		},
+		{
+			name:          "unavailable-agent",
+			config:        Config{Username: testUser, Agent: NewAgent("testdata/missing.sock"), Password: Value("secret")},
+			server:        testServer{password: "secret"},
+			wantHandshake: true,
+		},
+		{
+			name:    "invalid-key",
+			config:  Config{Username: testUser, Keys: []Key{{Private: Value("invalid")}}},
+			wantErr: true,
+		},
+		{
+			name:    "unreadable-password",
+			config:  Config{Username: testUser, Password: Env("CREDENTIALS_TEST_MISSING")},
+			wantErr: true,
+		},
+		{
+			name:    "no-method",
+			config:  Config{Username: testUser},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := tt.config.Credentials(context.Background(), "s1-abc01")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Credentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if creds.Username != testUser {
+				t.Errorf("Credentials() username = %q, want %q", creds.Username, testUser)
+			}
+			if err := tt.server.handshake(t, creds); (err == nil) != tt.wantHandshake {
+				t.Errorf("handshake() error = %v, want success = %v", err, tt.wantHandshake)
+			}
+		})
+	}
+}
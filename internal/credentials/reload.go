@@ -0,0 +1,158 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// errNotLoaded is returned by a Reloadable before credentials are stored.
+var errNotLoaded = errors.New("SSH credentials not loaded")
+
+// Loader is a Provider whose secrets can be read ahead of time.
+type Loader interface {
+	Provider
+	// Load reads the secrets and returns a Provider using their current
+	// values, so that they are not read again. It fails if a secret cannot
+	// be read or a key cannot be parsed.
+	Load(ctx context.Context) (Provider, error)
+	// Files returns the local files the secrets are read from.
+	Files() []string
+}
+
+// Load reads the secrets of the Config and returns a copy using their
+// current values.
+func (c *Config) Load(ctx context.Context) (Provider, error) {
+	loaded := &Config{Username: c.Username, Agent: c.Agent}
+	for _, k := range c.Keys {
+		var l Key
+		var err error
+		if l.Private, err = read(ctx, k.Private); err != nil {
+			return nil, fmt.Errorf("cannot read SSH key: %w", err)
+		}
+		if l.Passphrase, err = read(ctx, k.Passphrase); err != nil {
+			return nil, fmt.Errorf("cannot read the passphrase: %w", err)
+		}
+		if l.Certificate, err = read(ctx, k.Certificate); err != nil {
+			return nil, fmt.Errorf("cannot read the certificate: %w", err)
+		}
+		// Keys that cannot be used must not replace working ones.
+		if _, err := l.Signer(ctx); err != nil {
+			return nil, fmt.Errorf("cannot load SSH key: %w", err)
+		}
+		loaded.Keys = append(loaded.Keys, l)
+	}
+	var err error
+	if loaded.Password, err = read(ctx, c.Password); err != nil {
+		return nil, fmt.Errorf("cannot read the SSH password: %w", err)
+	}
+	return loaded, nil
+}
+
+// Files returns the local files of the keys, passphrases, certificates and
+// password of the Config.
+func (c *Config) Files() []string {
+	files := appendFile(nil, c.Password)
+	for _, k := range c.Keys {
+		for _, s := range []Secret{k.Private, k.Passphrase, k.Certificate} {
+			files = appendFile(files, s)
+		}
+	}
+	return files
+}
+
+// Load reads the secrets of the default and the sites' providers and returns
+// a copy using their current values.
+func (s *Sites) Load(ctx context.Context) (Provider, error) {
+	loaded := &Sites{Resolver: s.Resolver, Sites: make(map[string]Provider, len(s.Sites))}
+	var err error
+	if loaded.Default, err = load(ctx, s.Default); err != nil {
+		return nil, err
+	}
+	for site, p := range s.Sites {
+		if loaded.Sites[site], err = load(ctx, p); err != nil {
+			return nil, fmt.Errorf("invalid credentials for site %s: %w", site, err)
+		}
+	}
+	return loaded, nil
+}
+
+// Files returns the local files of the secrets of the default and the
+// sites' providers.
+func (s *Sites) Files() []string {
+	files := filesOf(s.Default)
+	for _, p := range s.Sites {
+		files = append(files, filesOf(p)...)
+	}
+	return files
+}
+
+// filesOf returns the files of p if it's a Loader.
+func filesOf(p Provider) []string {
+	if l, ok := p.(Loader); ok {
+		return l.Files()
+	}
+	return nil
+}
+
+// load returns the loaded copy of p, or p itself if it's not a Loader.
+func load(ctx context.Context, p Provider) (Provider, error) {
+	if l, ok := p.(Loader); ok {
+		return l.Load(ctx)
+	}
+	return p, nil
+}
+
+// read returns a Value holding the current value of the secret, or nil if
+// the secret is nil.
+func read(ctx context.Context, s Secret) (Secret, error) {
+	if s == nil {
+		return nil, nil
+	}
+	v, err := s.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Value(v), nil
+}
+
+// appendFile appends the local file a secret is read from, if any.
+func appendFile(files []string, s Secret) []string {
+	switch s := s.(type) {
+	case File:
+		return append(files, string(s))
+	case stored:
+		if dir, ok := s.store.(DirStore); ok {
+			return append(files, filepath.Join(string(dir), s.name))
+		}
+	}
+	return files
+}
+
+// Reloadable is a Provider whose credentials can be replaced while they are
+// in use. The sessions already established are not affected.
+type Reloadable struct {
+	v atomic.Value
+}
+
+// providerBox wraps the Providers stored in an atomic.Value, which requires
+// values of the same concrete type.
+type providerBox struct {
+	Provider
+}
+
+// Store replaces the credentials.
+func (r *Reloadable) Store(p Provider) {
+	r.v.Store(providerBox{p})
+}
+
+// Credentials returns the credentials of the last stored Provider.
+func (r *Reloadable) Credentials(ctx context.Context, host string) (*Credentials, error) {
+	box, ok := r.v.Load().(providerBox)
+	if !ok {
+		return nil, errNotLoaded
+	}
+	return box.Credentials(ctx, host)
+}
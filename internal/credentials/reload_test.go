@@ -0,0 +1,132 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestConfig_Load(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	_, pem := newKey("")
+	rtx.Must(ioutil.WriteFile(keyFile, pem, 0600), "Cannot write key")
+	store := DirStore(dir)
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "password"), []byte("secret"), 0600),
+		"Cannot write password")
+
+	config := &Config{
+		Username: testUser,
+		Keys:     []Key{{Private: File(keyFile)}},
+		Password: stored{store: store, name: "password"},
+	}
+	p, err := config.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	loaded := p.(*Config)
+	want := &Config{
+		Username: testUser,
+		Keys:     []Key{{Private: Value(pem)}},
+		Password: Value("secret"),
+	}
+	if !reflect.DeepEqual(loaded, want) {
+		t.Errorf("Load() = %+v, want %+v", loaded, want)
+	}
+
+	// The loaded credentials don't change until they are loaded again.
+	rtx.Must(ioutil.WriteFile(keyFile, []byte("invalid"), 0600), "Cannot write key")
+	if _, err := loaded.Credentials(ctx, "s1-abc01"); err != nil {
+		t.Errorf("Credentials() after the key changed error = %v", err)
+	}
+	if _, err := config.Load(ctx); err == nil {
+		t.Errorf("Load() of an invalid key succeeded")
+	}
+
+	for name, c := range map[string]*Config{
+		"missing-passphrase": {Keys: []Key{{Private: Value(pem), Passphrase: File(filepath.Join(dir, "missing"))}}},
+		"missing-cert":       {Keys: []Key{{Private: Value(pem), Certificate: File(filepath.Join(dir, "missing"))}}},
+		"missing-key":        {Keys: []Key{{Private: File(filepath.Join(dir, "missing"))}}},
+		"missing-password":   {Password: Env("CREDENTIALS_TEST_MISSING")},
+	} {
+		if _, err := c.Load(ctx); err == nil {
+			t.Errorf("Load() with %s succeeded", name)
+		}
+	}
+}
+
+func TestSites_Files(t *testing.T) {
+	sites := &Sites{
+		Sites: map[string]Provider{
+			"abc01": &Config{
+				Keys:     []Key{{Private: File("/abc01.key"), Certificate: File("/abc01-cert.pub")}},
+				Password: stored{store: DirStore("/secrets"), name: "abc01"},
+			},
+			"abc02": &Reloadable{},
+		},
+		Default: &Config{
+			Keys:     []Key{{Private: File("/default.key"), Passphrase: Env("PASSPHRASE")}},
+			Password: Value("secret"),
+		},
+	}
+	got := sites.Files()
+	sort.Strings(got)
+	want := []string{"/abc01-cert.pub", "/abc01.key", "/default.key", "/secrets/abc01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Files() = %v, want %v", got, want)
+	}
+}
+
+func TestSites_Load(t *testing.T) {
+	ctx := context.Background()
+	other := &Reloadable{}
+	sites := &Sites{
+		Sites: map[string]Provider{
+			"abc01": &Config{Username: "site", Password: Env("CREDENTIALS_TEST_MISSING")},
+			"abc02": other,
+		},
+		Default: &Config{Username: "default", Password: Value("secret")},
+	}
+	if _, err := sites.Load(ctx); err == nil {
+		t.Errorf("Load() with an unreadable site password succeeded")
+	}
+
+	sites.Sites["abc01"] = &Config{Username: "site", Password: Value("secret")}
+	p, err := sites.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded := p.(*Sites); loaded.Sites["abc02"] != other || len(loaded.Sites) != 2 {
+		t.Errorf("Load() = %+v", loaded)
+	}
+
+	sites.Default = &Config{Password: Env("CREDENTIALS_TEST_MISSING")}
+	if _, err := sites.Load(ctx); err == nil {
+		t.Errorf("Load() with an unreadable default password succeeded")
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	ctx := context.Background()
+	r := &Reloadable{}
+	if _, err := r.Credentials(ctx, "s1-abc01"); err != errNotLoaded {
+		t.Errorf("Credentials() before Store() error = %v, want %v", err, errNotLoaded)
+	}
+	for _, p := range []Provider{
+		&Config{Username: "first", Password: Value("secret")},
+		&Sites{Default: &Config{Username: "second", Password: Value("secret")}},
+	} {
+		r.Store(p)
+		want, _ := p.Credentials(ctx, "s1-abc01")
+		got, err := r.Credentials(ctx, "s1-abc01")
+		if err != nil || got.Username != want.Username {
+			t.Errorf("Credentials() = %+v, %v, want username %q", got, err, want.Username)
+		}
+	}
+}
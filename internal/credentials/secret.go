@@ -0,0 +1,113 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Secret is a sensitive value, e.g. a private key or a password. The service
+// reads it when loading the credentials, at startup and on every reload, so
+// that it can be rotated without restarting: see Loader.
+type Secret interface {
+	Read(ctx context.Context) ([]byte, error)
+}
+
+// readPassword reads a Secret holding a password or a passphrase. The
+// trailing line breaks, e.g. the newline ending files written by editors or by
+// echo, are removed since they cannot be typed as part of it. Other secrets,
+// e.g. private keys in PEM format, are read verbatim.
+func readPassword(ctx context.Context, s Secret) ([]byte, error) {
+	v, err := s.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(v, "\r\n"), nil
+}
+
+// Value is a Secret with a fixed value.
+type Value []byte
+
+// Read returns the value.
+func (v Value) Read(context.Context) ([]byte, error) {
+	return v, nil
+}
+
+// File is a Secret read from a local file.
+type File string
+
+// Read returns the content of the file.
+func (f File) Read(context.Context) ([]byte, error) {
+	return ioutil.ReadFile(string(f))
+}
+
+// Env is a Secret read from an environment variable.
+type Env string
+
+// Read returns the value of the environment variable.
+func (e Env) Read(context.Context) ([]byte, error) {
+	v, ok := os.LookupEnv(string(e))
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", string(e))
+	}
+	return []byte(v), nil
+}
+
+// Store is a secret manager, holding secrets by name.
+type Store interface {
+	Secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// DirStore is a Store reading each secret from the file with the same name in
+// a directory, as secret managers mount them in containers. It stands in for
+// the API of a secret manager.
+type DirStore string
+
+// Secret returns the content of the file named after the secret.
+func (d DirStore) Secret(_ context.Context, name string) ([]byte, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid secret name: %q", name)
+	}
+	return ioutil.ReadFile(filepath.Join(string(d), name))
+}
+
+// stored is a Secret read from a Store.
+type stored struct {
+	store Store
+	name  string
+}
+
+func (s stored) Read(ctx context.Context) ([]byte, error) {
+	return s.store.Secret(ctx, s.name)
+}
+
+// ParseSecret returns the Secret a reference points to: "env:NAME" is an
+// environment variable, "secret:NAME" is a secret of the store and anything
+// else is the path of a local file, optionally prefixed by file://.
+func ParseSecret(ref string, store Store) (Secret, error) {
+	switch {
+	case ref == "":
+		return nil, errors.New("empty secret reference")
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		if name == "" {
+			return nil, fmt.Errorf("invalid secret reference: %q", ref)
+		}
+		return Env(name), nil
+	case strings.HasPrefix(ref, "secret:"):
+		name := strings.TrimPrefix(ref, "secret:")
+		if name == "" {
+			return nil, fmt.Errorf("invalid secret reference: %q", ref)
+		}
+		if store == nil {
+			return nil, fmt.Errorf("no secret store configured for %q", ref)
+		}
+		return stored{store: store, name: name}, nil
+	}
+	return File(strings.TrimPrefix(ref, "file://")), nil
+}
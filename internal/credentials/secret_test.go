@@ -0,0 +1,109 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/osx"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestParseSecret(t *testing.T) {
+	store := DirStore("testdata")
+	tests := []struct {
+		name    string
+		ref     string
+		store   Store
+		want    Secret
+		wantErr bool
+	}{
+		{name: "path", ref: "/path/to/key", want: File("/path/to/key")},
+		{name: "file-url", ref: "file:///path/to/key", want: File("/path/to/key")},
+		{name: "env", ref: "env:SSH_KEY", want: Env("SSH_KEY")},
+		{name: "secret", ref: "secret:key", store: store, want: stored{store: store, name: "key"}},
+		{name: "empty", ref: "", wantErr: true},
+		{name: "empty-env", ref: "env:", wantErr: true},
+		{name: "empty-secret", ref: "secret:", store: store, wantErr: true},
+		{name: "secret-without-store", ref: "secret:key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSecret(tt.ref, tt.store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSecret() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecret_Read(t *testing.T) {
+	dir := t.TempDir()
+	rtx.Must(ioutil.WriteFile(filepath.Join(dir, "password"), []byte("from-store"), 0600),
+		"Cannot write secret")
+	defer osx.MustSetenv("CREDENTIALS_TEST_PASSWORD", "from-env")()
+
+	tests := []struct {
+		name    string
+		secret  Secret
+		want    string
+		wantErr bool
+	}{
+		{name: "value", secret: Value("value"), want: "value"},
+		{name: "file", secret: File(filepath.Join(dir, "password")), want: "from-store"},
+		{name: "missing-file", secret: File(filepath.Join(dir, "missing")), wantErr: true},
+		{name: "env", secret: Env("CREDENTIALS_TEST_PASSWORD"), want: "from-env"},
+		{name: "missing-env", secret: Env("CREDENTIALS_TEST_MISSING"), wantErr: true},
+		{name: "store", secret: stored{store: DirStore(dir), name: "password"}, want: "from-store"},
+		{name: "store-traversal", secret: stored{store: DirStore(dir), name: "../password"}, wantErr: true},
+		{name: "store-hidden", secret: stored{store: DirStore(dir), name: ".."}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.secret.Read(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readPassword(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	rtx.Must(ioutil.WriteFile(file, []byte("secret\n"), 0600), "Cannot write secret")
+
+	tests := []struct {
+		name    string
+		secret  Secret
+		want    string
+		wantErr bool
+	}{
+		{name: "file-newline", secret: File(file), want: "secret"},
+		{name: "crlf", secret: Value("secret\r\n"), want: "secret"},
+		{name: "blank-lines", secret: Value("secret\n\n"), want: "secret"},
+		{name: "no-newline", secret: Value("secret"), want: "secret"},
+		{name: "spaces", secret: Value(" secret "), want: " secret "},
+		{name: "inner-newline", secret: Value("sec\nret"), want: "sec\nret"},
+		{name: "missing-file", secret: File(filepath.Join(dir, "missing")), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPassword(context.Background(), tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("readPassword() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/m-lab/switch-monitoring/internal/resolver"
+)
+
+// Sites is a Provider returning the credentials of the site of each switch,
+// or the default ones if the site has none.
+type Sites struct {
+	// Resolver returns the site of a switch. If nil, resolver.Default is
+	// used.
+	Resolver resolver.Resolver
+	Sites    map[string]Provider
+	Default  Provider
+}
+
+// Credentials returns the credentials of the site of the host.
+func (s *Sites) Credentials(ctx context.Context, host string) (*Credentials, error) {
+	r := s.Resolver
+	if r == nil {
+		r = resolver.Default
+	}
+	if site, err := r.Site(host); err == nil {
+		if p, ok := s.Sites[site]; ok {
+			return p.Credentials(ctx, host)
+		}
+	}
+	return s.Default.Credentials(ctx, host)
+}
+
+// siteConfig is the configuration of the credentials of a site in a sites
+// file.
+type siteConfig struct {
+	Username string      `json:"username"`
+	Keys     []keyConfig `json:"keys"`
+	Agent    bool        `json:"agent"`
+	Password string      `json:"password"`
+}
+
+// keyConfig is the configuration of a key in a sites file.
+type keyConfig struct {
+	Private     string `json:"private"`
+	Passphrase  string `json:"passphrase"`
+	Certificate string `json:"certificate"`
+}
+
+// LoadSites reads a sites file from disk.
+func LoadSites(filename string, defaults *Config, store Store) (map[string]Provider, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSites(data, defaults, store)
+}
+
+// ParseSites parses a JSON document overriding the credentials of specific
+// sites, like the following:
+//
+//	{
+//	  "abc01": {
+//	    "username": "admin",
+//	    "keys": [{
+//	      "private": "secret:abc01-key",
+//	      "passphrase": "env:ABC01_PASSPHRASE",
+//	      "certificate": "/etc/ssh/abc01-cert.pub"
+//	    }],
+//	    "agent": true,
+//	    "password": "secret:abc01-password"
+//	  }
+//	}
+//
+// Secrets are references, as accepted by ParseSecret. The username and the
+// agent of a site default to the ones of the default configuration.
+func ParseSites(data []byte, defaults *Config, store Store) (map[string]Provider, error) {
+	var sites map[string]siteConfig
+	if err := json.Unmarshal(data, &sites); err != nil {
+		return nil, err
+	}
+	providers := make(map[string]Provider, len(sites))
+	for site, sc := range sites {
+		c, err := sc.config(defaults, store)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credentials for site %s: %w", site, err)
+		}
+		providers[site] = c
+	}
+	return providers, nil
+}
+
+// config returns the Config described by the site's configuration.
+func (sc siteConfig) config(defaults *Config, store Store) (*Config, error) {
+	c := &Config{Username: sc.Username}
+	if c.Username == "" {
+		c.Username = defaults.Username
+	}
+	if sc.Agent {
+		if defaults.Agent == nil {
+			return nil, errors.New("ssh-agent is not enabled")
+		}
+		c.Agent = defaults.Agent
+	}
+	for _, kc := range sc.Keys {
+		var k Key
+		var err error
+		if k.Private, err = ParseSecret(kc.Private, store); err != nil {
+			return nil, err
+		}
+		if kc.Passphrase != "" {
+			if k.Passphrase, err = ParseSecret(kc.Passphrase, store); err != nil {
+				return nil, err
+			}
+		}
+		if kc.Certificate != "" {
+			if k.Certificate, err = ParseSecret(kc.Certificate, store); err != nil {
+				return nil, err
+			}
+		}
+		c.Keys = append(c.Keys, k)
+	}
+	if sc.Password != "" {
+		var err error
+		if c.Password, err = ParseSecret(sc.Password, store); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.Keys) == 0 && c.Agent == nil && c.Password == nil {
+		return nil, ErrNoMethod
+	}
+	return c, nil
+}
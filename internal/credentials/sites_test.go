@@ -0,0 +1,116 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/m-lab/switch-monitoring/internal/resolver"
+)
+
+func TestParseSites(t *testing.T) {
+	agent := NewAgent("agent.sock")
+	defaults := &Config{Username: "default", Agent: agent}
+	store := DirStore("testdata")
+
+	tests := []struct {
+		name    string
+		data    string
+		check   func(t *testing.T, sites map[string]Provider)
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `{
+				"abc01": {
+					"username": "admin",
+					"keys": [{"private": "secret:key", "passphrase": "env:PASSPHRASE", "certificate": "/cert.pub"}],
+					"password": "secret:password"
+				},
+				"abc02": {"agent": true}
+			}`,
+			check: func(t *testing.T, sites map[string]Provider) {
+				abc01 := sites["abc01"].(*Config)
+				if abc01.Username != "admin" || len(abc01.Keys) != 1 ||
+					abc01.Keys[0].Passphrase != Env("PASSPHRASE") ||
+					abc01.Keys[0].Certificate != File("/cert.pub") ||
+					abc01.Password != (stored{store: store, name: "password"}) {
+					t.Errorf("ParseSites() abc01 = %+v", abc01)
+				}
+				abc02 := sites["abc02"].(*Config)
+				if abc02.Username != "default" || abc02.Agent != agent {
+					t.Errorf("ParseSites() abc02 = %+v", abc02)
+				}
+			},
+		},
+		{name: "invalid-json", data: `[]`, wantErr: true},
+		{name: "no-method", data: `{"abc01": {"username": "admin"}}`, wantErr: true},
+		{name: "invalid-key", data: `{"abc01": {"keys": [{"private": ""}]}}`, wantErr: true},
+		{name: "invalid-passphrase", data: `{"abc01": {"keys": [{"private": "key", "passphrase": "env:"}]}}`, wantErr: true},
+		{name: "invalid-certificate", data: `{"abc01": {"keys": [{"private": "key", "certificate": "secret:"}]}}`, wantErr: true},
+		{name: "invalid-password", data: `{"abc01": {"password": "env:"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sites, err := ParseSites([]byte(tt.data), defaults, store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, sites)
+			}
+		})
+	}
+
+	if _, err := ParseSites([]byte(`{"abc01": {"agent": true}}`), &Config{}, nil); err == nil {
+		t.Errorf("ParseSites() accepted the agent while it's disabled")
+	}
+}
+
+func TestLoadSites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.json")
+	rtx.Must(ioutil.WriteFile(path, []byte(`{"abc01": {"password": "env:PASSWORD"}}`), 0600),
+		"Cannot write sites file")
+	sites, err := LoadSites(path, &Config{}, nil)
+	if err != nil || len(sites) != 1 {
+		t.Errorf("LoadSites() = %v, %v", sites, err)
+	}
+	if _, err := LoadSites(filepath.Join(t.TempDir(), "missing.json"), &Config{}, nil); err == nil {
+		t.Errorf("LoadSites() of a missing file succeeded")
+	}
+}
+
+func TestSites_Credentials(t *testing.T) {
+	sites := &Sites{
+		Sites: map[string]Provider{
+			"abc01": &Config{Username: "site", Password: Value("site")},
+		},
+		Default: &Config{Username: "default", Password: Value("default")},
+	}
+	tests := []struct {
+		host     string
+		resolver resolver.Resolver
+		want     string
+	}{
+		{host: "s1-abc01.measurement-lab.org", want: "site"},
+		{host: "s1.abc01.measurement-lab.org", want: "site"},
+		{host: "s1-abc02.measurement-lab.org", want: "default"},
+		{host: "unknown", want: "default"},
+		{
+			host:     "sw1.abc01.example.org",
+			resolver: resolver.MustRegexp("custom", `^sw[0-9]+\.([a-z0-9]+)\.`),
+			want:     "site",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			sites.Resolver = tt.resolver
+			creds, err := sites.Credentials(context.Background(), tt.host)
+			if err != nil || creds.Username != tt.want {
+				t.Errorf("Credentials() = %+v, %v, want username %q", creds, err, tt.want)
+			}
+		})
+	}
+}
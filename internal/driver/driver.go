@@ -0,0 +1,102 @@
+// Package driver selects how to fetch and compare the configuration of each
+// switch, depending on its vendor.
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/m-lab/switch-monitoring/internal"
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+)
+
+// Vendors with a built-in driver.
+const (
+	// Juniper switches are read over NETCONF with the JunOS extensions, in
+	// the JunOS text format.
+	Juniper = "juniper"
+	// Generic switches are read with a standard NETCONF <get-config>, as
+	// XML.
+	Generic = "generic"
+)
+
+// Driver fetches and compares the configuration of switches from a specific
+// vendor.
+type Driver struct {
+	// Netconf reads the actual configuration from a switch.
+	Netconf internal.NetconfClient
+	// Format is the syntax of both the actual and the expected
+	// configuration, which determines how they are normalized and compared.
+	Format netconf.Format
+}
+
+// VendorSource knows the vendor of some of the switches.
+type VendorSource interface {
+	// Vendor returns the vendor of the target, or false if it's unknown.
+	Vendor(target string) (string, bool)
+}
+
+// Registry maps vendors to drivers. The vendor of a switch is looked up, in
+// order, in Overrides, in Vendors and finally defaults to Default. Vendor
+// names are case-insensitive.
+//
+// Drivers must be registered before the Registry is used concurrently.
+type Registry struct {
+	// Default is the vendor of the switches whose vendor is unknown.
+	Default string
+	// Vendors provides the vendor of the switches, e.g. from the inventory.
+	// Can be nil.
+	Vendors VendorSource
+	// Overrides maps targets to their vendor, taking precedence over
+	// Vendors.
+	Overrides map[string]string
+
+	drivers map[string]Driver
+}
+
+// NewRegistry returns an empty Registry with the specified default vendor.
+func NewRegistry(defaultVendor string) *Registry {
+	return &Registry{
+		Default: defaultVendor,
+		drivers: map[string]Driver{},
+	}
+}
+
+// Register adds the driver for a vendor, replacing any existing one.
+func (r *Registry) Register(vendor string, d Driver) {
+	r.drivers[strings.ToLower(vendor)] = d
+}
+
+// Vendor returns the vendor of the target.
+func (r *Registry) Vendor(target string) string {
+	if v, ok := r.Overrides[target]; ok {
+		return strings.ToLower(v)
+	}
+	if r.Vendors != nil {
+		if v, ok := r.Vendors.Vendor(target); ok && v != "" {
+			return strings.ToLower(v)
+		}
+	}
+	return strings.ToLower(r.Default)
+}
+
+// ForTarget returns the driver for the target's vendor.
+func (r *Registry) ForTarget(target string) (Driver, error) {
+	vendor := r.Vendor(target)
+	d, ok := r.drivers[vendor]
+	if !ok {
+		return Driver{}, fmt.Errorf("no driver for vendor %q of %s",
+			vendor, target)
+	}
+	return d, nil
+}
+
+// Close closes the NETCONF clients of all the drivers that need to be
+// closed.
+func (r *Registry) Close() {
+	for _, d := range r.drivers {
+		if c, ok := d.Netconf.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/m-lab/switch-monitoring/internal/netconf"
+)
+
+type mockNetconf struct {
+	name   string
+	closed bool
+}
+
+func (n *mockNetconf) GetConfig(context.Context, string, ...string) (string, error) {
+	return n.name, nil
+}
+
+func (n *mockNetconf) Close() {
+	n.closed = true
+}
+
+type mockVendors map[string]string
+
+func (m mockVendors) Vendor(target string) (string, bool) {
+	v, ok := m[target]
+	return v, ok
+}
+
+func TestRegistry_ForTarget(t *testing.T) {
+	juniper := &mockNetconf{name: "juniper"}
+	generic := &mockNetconf{name: "generic"}
+	r := NewRegistry(Juniper)
+	r.Register(Juniper, Driver{Netconf: juniper, Format: netconf.Text})
+	r.Register("Generic", Driver{Netconf: generic, Format: netconf.XML})
+	r.Vendors = mockVendors{
+		"s1-abc01": "Generic",
+		"s1-abc02": "generic",
+		"s1-abc03": "arista",
+		"s1-abc04": "",
+	}
+	r.Overrides = map[string]string{"s1-abc02": "JUNIPER"}
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "s1-abc01", want: "generic"},
+		{target: "s1-abc02", want: "juniper"},
+		{target: "s1-abc03", wantErr: true},
+		{target: "s1-abc04", want: "juniper"},
+		{target: "s1-xyz01", want: "juniper"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			d, err := r.ForTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ForTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if name, _ := d.Netconf.GetConfig(context.Background(), tt.target); name != tt.want {
+				t.Errorf("ForTarget() returned the %s driver, want %s", name, tt.want)
+			}
+		})
+	}
+
+	r.Close()
+	if !juniper.closed || !generic.closed {
+		t.Errorf("Close() did not close all the clients")
+	}
+}
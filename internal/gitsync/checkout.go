@@ -0,0 +1,102 @@
+// Package gitsync keeps a local checkout of a git repository up to date, so
+// that the expected switch configurations can be read from a repository
+// instead of GCS.
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// Checkout is a local checkout of a git repository. It's updated by fetching
+// the configured ref and resetting the working tree to it, discarding any
+// local change.
+type Checkout struct {
+	// Remote is the URL of the repository.
+	Remote string
+	// Ref is the branch, tag or commit to check out. If empty, the remote's
+	// HEAD is used.
+	Ref string
+	// Dir is the local directory of the checkout. It's created if needed.
+	Dir string
+
+	// mu serializes the syncs.
+	mu sync.Mutex
+	// files is held for writing while the working tree is updated.
+	files sync.RWMutex
+}
+
+// RLocker returns a Locker preventing the working tree from being updated
+// while it's held, so that readers never see a partially updated tree.
+func (c *Checkout) RLocker() sync.Locker {
+	return c.files.RLocker()
+}
+
+// Sync updates the checkout to the latest version of Ref, cloning the
+// repository first if needed.
+func (c *Checkout) Sync(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(c.Dir, ".git")); os.IsNotExist(err) {
+		if err := c.git(ctx, "init", "--quiet", c.Dir); err != nil {
+			return err
+		}
+		if err := c.git(ctx, "-C", c.Dir, "remote", "add", "origin", c.Remote); err != nil {
+			return err
+		}
+	}
+
+	ref := c.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := c.git(ctx, "-C", c.Dir, "fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+
+	// Fetching can be slow, but the working tree is only locked while the
+	// fetched files are written.
+	c.files.Lock()
+	defer c.files.Unlock()
+	return c.git(ctx, "-C", c.Dir, "reset", "--quiet", "--hard", "FETCH_HEAD")
+}
+
+// Run syncs the checkout every interval until the context is canceled.
+// Errors are logged and the previous version is kept.
+func (c *Checkout) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Sync(ctx); err != nil {
+			log.WithError(err).Error("Cannot update the git checkout")
+		}
+	}
+}
+
+// git runs a git command, returning its output in the error if it fails.
+func (c *Checkout) git(ctx context.Context, args ...string) error {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err,
+			strings.TrimSpace(out.String()))
+	}
+	return nil
+}
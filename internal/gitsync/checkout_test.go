@@ -0,0 +1,117 @@
+package gitsync
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// newRepo creates a repository with a single file and returns its path.
+func newRepo(t *testing.T, dir, content string) string {
+	repo := filepath.Join(dir, "remote")
+	for _, args := range [][]string{
+		{"init", "--quiet", repo},
+		{"-C", repo, "config", "user.email", "test@example.com"},
+		{"-C", repo, "config", "user.name", "test"},
+	} {
+		rtx.Must(exec.Command("git", args...).Run(), "Cannot init repository")
+	}
+	commit(t, repo, content)
+	return repo
+}
+
+// commit replaces the content of abc01.conf and commits it.
+func commit(t *testing.T, repo, content string) {
+	rtx.Must(ioutil.WriteFile(filepath.Join(repo, "abc01.conf"),
+		[]byte(content), 0644), "Cannot write file")
+	for _, args := range [][]string{
+		{"-C", repo, "add", "-A"},
+		{"-C", repo, "commit", "--quiet", "-m", "update"},
+	} {
+		rtx.Must(exec.Command("git", args...).Run(), "Cannot commit")
+	}
+}
+
+func TestCheckout_Sync(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "gitsync")
+	rtx.Must(err, "Cannot create temporary directory")
+	defer os.RemoveAll(dir)
+
+	repo := newRepo(t, dir, "version 1")
+	c := &Checkout{Remote: repo, Dir: filepath.Join(dir, "checkout")}
+	ctx := context.Background()
+
+	for _, want := range []string{"version 1", "version 2"} {
+		if want != "version 1" {
+			commit(t, repo, want)
+		}
+		if err := c.Sync(ctx); err != nil {
+			t.Fatalf("Sync() returned err: %v", err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(c.Dir, "abc01.conf"))
+		if err != nil || string(got) != want {
+			t.Errorf("checked out %q, %v, want %q", got, err, want)
+		}
+	}
+
+	c.Ref = "does-not-exist"
+	if err := c.Sync(ctx); err == nil {
+		t.Errorf("Sync() expected err, got nil")
+	}
+}
+
+func TestCheckout_RLocker(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	repo := newRepo(t, dir, "version 1")
+	c := &Checkout{Remote: repo, Dir: filepath.Join(dir, "checkout")}
+	ctx := context.Background()
+	rtx.Must(c.Sync(ctx), "Cannot sync")
+	commit(t, repo, "version 2")
+
+	// The working tree is not updated while a reader holds the lock.
+	lock := c.RLocker()
+	lock.Lock()
+	done := make(chan error)
+	go func() { done <- c.Sync(ctx) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Sync() returned %v while the checkout was locked", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	got, err := ioutil.ReadFile(filepath.Join(c.Dir, "abc01.conf"))
+	if err != nil || string(got) != "version 1" {
+		t.Errorf("read %q, %v while locked, want %q", got, err, "version 1")
+	}
+	lock.Unlock()
+
+	rtx.Must(<-done, "Cannot sync")
+	got, err = ioutil.ReadFile(filepath.Join(c.Dir, "abc01.conf"))
+	if err != nil || string(got) != "version 2" {
+		t.Errorf("read %q, %v after unlocking, want %q", got, err, "version 2")
+	}
+}
+
+func TestCheckout_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitsync")
+	rtx.Must(err, "Cannot create temporary directory")
+	defer os.RemoveAll(dir)
+
+	c := &Checkout{Remote: filepath.Join(dir, "does-not-exist"), Dir: dir}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Errors are logged and Run returns when the context is canceled.
+	c.Run(ctx, 10*time.Millisecond)
+}
@@ -0,0 +1,442 @@
+// Package history archives the configurations read from the switches and the
+// results of their checks, so that past configuration drift can be
+// investigated after it has been fixed.
+package history
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	resultsDir = "results"
+	configsDir = "configs"
+	dayFormat  = "2006-01-02"
+	day        = 24 * time.Hour
+)
+
+// DefaultWindow is how far back the /history endpoint looks when the since
+// parameter is not set.
+const DefaultWindow = 7 * day
+
+var (
+	// ErrNotFound is returned when nothing has been recorded for a target, or
+	// a configuration version does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrInvalidName is returned for targets and versions that cannot be
+	// safely used as file names.
+	ErrInvalidName = errors.New("invalid name")
+
+	validTarget  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	validVersion = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Result is a single check of a switch's configuration.
+type Result struct {
+	Time  time.Time `json:"time"`
+	Match bool      `json:"match"`
+	// Config is the version of the configuration read from the switch.
+	Config string `json:"config"`
+}
+
+// Version is a distinct configuration read from a switch.
+type Version struct {
+	Hash      string    `json:"hash"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Episode is a period during which the configuration of a switch differed
+// from the expected one.
+type Episode struct {
+	Start time.Time `json:"start"`
+	// End is the time of the first check finding the configuration fixed.
+	// It is nil if the drift is still ongoing.
+	End *time.Time `json:"end,omitempty"`
+	// Configs are the versions of the configuration seen during the
+	// episode, in order.
+	Configs []string `json:"configs"`
+}
+
+// Timeline is the history of a switch.
+type Timeline struct {
+	Target   string    `json:"target"`
+	Episodes []Episode `json:"episodes"`
+	Versions []Version `json:"versions"`
+}
+
+// Store archives results and configurations in a local directory, with a
+// subdirectory for each switch containing:
+//
+//   - results/<YYYY-MM-DD>.jsonl, with one Result per line for each UTC day,
+//     in the order they were recorded;
+//   - configs/<sha256>.conf, for each distinct configuration. Its
+//     modification time is the last time it was seen.
+//
+// The configurations contain secrets, e.g. encrypted passwords, so only the
+// owner can read the archive.
+type Store struct {
+	// Retention is how long results and configurations are kept after they
+	// were last seen. Zero keeps them forever. See Prune.
+	Retention time.Duration
+
+	dir string
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewStore returns a Store archiving to dir, which is created if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, now: time.Now}, nil
+}
+
+// Record archives the result of a check and the configuration read from the
+// switch, unless the same configuration has already been archived.
+func (s *Store) Record(target, config string, match bool) error {
+	if !validTarget.MatchString(target) {
+		return ErrInvalidName
+	}
+	hash := versionOf(config)
+	now := s.now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	configs := filepath.Join(s.dir, target, configsDir)
+	results := filepath.Join(s.dir, target, resultsDir)
+	for _, dir := range []string{configs, results} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(configs, hash+".conf")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := writeFile(path, []byte(config)); err != nil {
+			return err
+		}
+	}
+	// Prune keeps the configurations seen recently.
+	if err := os.Chtimes(path, now, now); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(Result{Time: now, Match: match, Config: hash})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(results, now.Format(dayFormat)+".jsonl"),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Results returns the results recorded for the target since the specified
+// time, oldest first. Only the files of the days since then are read.
+func (s *Store) Results(target string, since time.Time) ([]Result, error) {
+	if !validTarget.MatchString(target) {
+		return nil, ErrInvalidName
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := ioutil.ReadDir(filepath.Join(s.dir, target, resultsDir))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fi := range days {
+		if end, ok := dayEnd(fi.Name()); ok && end.After(since) {
+			files = append(files, filepath.Join(s.dir, target, resultsDir, fi.Name()))
+		}
+	}
+
+	results := []Result{}
+	for _, file := range files {
+		if results, err = readResults(file, target, since, results); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// Timeline returns the drift episodes and the configuration versions of the
+// target since the specified time. An episode ongoing at that time starts
+// with its first result since then.
+func (s *Store) Timeline(target string, since time.Time) (*Timeline, error) {
+	results, err := s.Results(target, since)
+	if err != nil {
+		return nil, err
+	}
+	return timeline(target, results), nil
+}
+
+// Prune deletes the results older than the retention, and the configurations
+// that have not been seen since. It does nothing if Retention is zero.
+func (s *Store) Prune() error {
+	if s.Retention <= 0 {
+		return nil
+	}
+	cutoff := s.now().Add(-s.Retention)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targets, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, t := range targets {
+		if !t.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, t.Name())
+		// The results of a day are deleted once they are all too old.
+		errs = append(errs, removeIf(filepath.Join(dir, resultsDir), func(fi os.FileInfo) bool {
+			end, ok := dayEnd(fi.Name())
+			return ok && !end.After(cutoff)
+		}))
+		errs = append(errs, removeIf(filepath.Join(dir, configsDir), func(fi os.FileInfo) bool {
+			return fi.ModTime().Before(cutoff)
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// Run prunes the archive every interval until the context is canceled.
+// Errors are logged.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Prune(); err != nil {
+			log.WithError(err).Error("Cannot prune the history")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Config returns an archived configuration of the target.
+func (s *Store) Config(target, version string) (string, error) {
+	if !validTarget.MatchString(target) || !validVersion.MatchString(version) {
+		return "", ErrInvalidName
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, target, configsDir,
+		version+".conf"))
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	return string(data), err
+}
+
+// ServeHTTP handles GET requests to the /history endpoint. It returns the
+// timeline of the target as JSON since the time in the since parameter, or
+// for the last DefaultWindow. If the version parameter is set, it returns the
+// archived configuration with that hash instead.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("URL parameter 'target' is missing"))
+		return
+	}
+
+	if version := r.URL.Query().Get("version"); version != "" {
+		config, err := s.Config(target, version)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(config))
+		return
+	}
+
+	since := s.now().Add(-DefaultWindow)
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("URL parameter 'since' must be an RFC 3339 time"))
+			return
+		}
+	}
+	t, err := s.Timeline(target, since)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// timeline groups consecutive mismatching results into episodes and lists
+// the distinct configurations in the order they were first seen.
+func timeline(target string, results []Result) *Timeline {
+	t := &Timeline{
+		Target:   target,
+		Episodes: []Episode{},
+		Versions: []Version{},
+	}
+	versions := map[string]int{}
+	var current *Episode
+	for _, r := range results {
+		if i, ok := versions[r.Config]; ok {
+			t.Versions[i].LastSeen = r.Time
+		} else {
+			versions[r.Config] = len(t.Versions)
+			t.Versions = append(t.Versions, Version{
+				Hash:      r.Config,
+				FirstSeen: r.Time,
+				LastSeen:  r.Time,
+			})
+		}
+
+		if r.Match {
+			if current != nil {
+				end := r.Time
+				current.End = &end
+				t.Episodes = append(t.Episodes, *current)
+				current = nil
+			}
+			continue
+		}
+		if current == nil {
+			current = &Episode{Start: r.Time}
+		}
+		if n := len(current.Configs); n == 0 || current.Configs[n-1] != r.Config {
+			current.Configs = append(current.Configs, r.Config)
+		}
+	}
+	if current != nil {
+		t.Episodes = append(t.Episodes, *current)
+	}
+	return t
+}
+
+// readResults appends the results read from a file since the specified time.
+func readResults(path, target string, since time.Time, results []Result) ([]Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A line may be truncated if the process died while writing it.
+			log.WithField("target", target).WithError(err).Warn(
+				"Skipping invalid history record")
+			continue
+		}
+		if !r.Time.Before(since) {
+			results = append(results, r)
+		}
+	}
+	return results, scanner.Err()
+}
+
+// dayEnd returns the end of the day whose results are in the named file.
+func dayEnd(name string) (time.Time, bool) {
+	start, err := time.Parse(dayFormat, strings.TrimSuffix(name, ".jsonl"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start.Add(day), true
+}
+
+// removeIf removes the files of the directory matching old. A missing
+// directory is empty.
+func removeIf(dir string, old func(os.FileInfo) bool) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.Mode().IsRegular() && old(fi) {
+			if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// versionOf returns the version of a configuration, i.e. its SHA-256 hash.
+func versionOf(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFile writes the data to a temporary file and renames it, so that the
+// file is never left partially written.
+func writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrInvalidName:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		log.WithError(err).Error("Cannot read history")
+	}
+	fmt.Fprint(w, err.Error())
+}
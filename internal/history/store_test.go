@@ -0,0 +1,286 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+const target = "s1-abc01.measurement-lab.org"
+
+// newTestStore returns a Store in a temporary directory whose clock advances
+// by one minute every time it is read.
+func newTestStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "history")
+	rtx.Must(err, "Cannot create temp dir")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s, err := NewStore(filepath.Join(dir, "archive"))
+	rtx.Must(err, "Cannot create store")
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return s
+}
+
+func minute(n int) time.Time {
+	return time.Date(2020, 1, 1, 0, n, 0, 0, time.UTC)
+}
+
+func TestStore_Record(t *testing.T) {
+	s := newTestStore(t)
+	for _, r := range []struct {
+		config string
+		match  bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", false},
+		{"c", false},
+		{"a", true},
+		{"a", true},
+		{"b", false},
+	} {
+		rtx.Must(s.Record(target, r.config, r.match), "Cannot record")
+	}
+
+	// Configurations are deduplicated.
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, target, configsDir))
+	rtx.Must(err, "Cannot list configs")
+	if len(files) != 3 {
+		t.Errorf("Record() archived %d configs, want 3", len(files))
+	}
+
+	got, err := s.Timeline(target, time.Time{})
+	if err != nil {
+		t.Fatalf("Timeline() returned err: %v", err)
+	}
+	a, b, c := versionOf("a"), versionOf("b"), versionOf("c")
+	end := minute(5)
+	want := &Timeline{
+		Target: target,
+		Episodes: []Episode{
+			{Start: minute(2), End: &end, Configs: []string{b, c}},
+			{Start: minute(7), Configs: []string{b}},
+		},
+		Versions: []Version{
+			{Hash: a, FirstSeen: minute(1), LastSeen: minute(6)},
+			{Hash: b, FirstSeen: minute(2), LastSeen: minute(7)},
+			{Hash: c, FirstSeen: minute(3), LastSeen: minute(4)},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Timeline() = %+v, want %+v", got, want)
+	}
+
+	if config, err := s.Config(target, c); config != "c" || err != nil {
+		t.Errorf("Config() = %q, %v, want c", config, err)
+	}
+	// The configurations contain secrets, so the archive is private.
+	for _, path := range []string{
+		s.dir,
+		filepath.Join(s.dir, target, configsDir),
+		filepath.Join(s.dir, target, configsDir, c+".conf"),
+		filepath.Join(s.dir, target, resultsDir, "2020-01-01.jsonl"),
+	} {
+		fi, err := os.Stat(path)
+		rtx.Must(err, "Cannot stat %s", path)
+		if fi.Mode().Perm()&0077 != 0 {
+			t.Errorf("%s mode = %v, want no access for group and others", path, fi.Mode())
+		}
+	}
+}
+
+func TestStore_errors(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.Record("../etc", "a", true); err != ErrInvalidName {
+		t.Errorf("Record() returned %v, want %v", err, ErrInvalidName)
+	}
+	if _, err := s.Timeline(target, time.Time{}); err != ErrNotFound {
+		t.Errorf("Timeline() returned %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.Config(target, "../../x"); err != ErrInvalidName {
+		t.Errorf("Config() returned %v, want %v", err, ErrInvalidName)
+	}
+	if _, err := s.Config(target, versionOf("a")); err != ErrNotFound {
+		t.Errorf("Config() returned %v, want %v", err, ErrNotFound)
+	}
+
+	// Truncated records are skipped.
+	rtx.Must(s.Record(target, "a", false), "Cannot record")
+	f, err := os.OpenFile(filepath.Join(s.dir, target, resultsDir, "2020-01-01.jsonl"),
+		os.O_APPEND|os.O_WRONLY, 0644)
+	rtx.Must(err, "Cannot open results")
+	f.WriteString(`{"time":"2020-`)
+	f.Close()
+	if results, err := s.Results(target, time.Time{}); len(results) != 1 || err != nil {
+		t.Errorf("Results() = %v, %v, want 1 result", results, err)
+	}
+}
+
+// recordAt records a result at the specified time.
+func recordAt(s *Store, now time.Time, config string, match bool) {
+	s.now = func() time.Time { return now }
+	rtx.Must(s.Record(target, config, match), "Cannot record")
+}
+
+func TestStore_Results(t *testing.T) {
+	s := newTestStore(t)
+	day1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	day3 := day2.Add(24 * time.Hour)
+
+	for _, now := range []time.Time{day1, day2, day3} {
+		recordAt(s, now, "a", true)
+	}
+
+	tests := []struct {
+		since time.Time
+		want  []time.Time
+	}{
+		{since: time.Time{}, want: []time.Time{day1, day2, day3}},
+		{since: day2, want: []time.Time{day2, day3}},
+		{since: day2.Add(time.Minute), want: []time.Time{day3}},
+		{since: day3.Add(time.Minute), want: []time.Time{}},
+	}
+	for _, tt := range tests {
+		results, err := s.Results(target, tt.since)
+		rtx.Must(err, "Cannot read results")
+		got := []time.Time{}
+		for _, r := range results {
+			got = append(got, r.Time)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Results(since=%v) = %v, want %v", tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	s := newTestStore(t)
+	day1 := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	recordAt(s, day1, "a", false)
+	recordAt(s, day1, "b", false)
+	recordAt(s, day1.Add(24*time.Hour), "a", true)
+
+	// Without retention, nothing is deleted.
+	s.now = func() time.Time { return day1.Add(365 * 24 * time.Hour) }
+	rtx.Must(s.Prune(), "Cannot prune")
+	if results, _ := s.Results(target, time.Time{}); len(results) != 3 {
+		t.Errorf("Prune() without retention kept %d results, want 3", len(results))
+	}
+
+	// The first day and the configuration only seen then are deleted.
+	s.Retention = 36 * time.Hour
+	s.now = func() time.Time { return day1.Add(60 * time.Hour) }
+	rtx.Must(s.Prune(), "Cannot prune")
+	results, err := s.Results(target, time.Time{})
+	if err != nil || len(results) != 1 || !results[0].Match {
+		t.Errorf("Prune() kept %v, %v, want the second day only", results, err)
+	}
+	if _, err := s.Config(target, versionOf("a")); err != nil {
+		t.Errorf("Prune() deleted a configuration seen recently: %v", err)
+	}
+	if _, err := s.Config(target, versionOf("b")); err != ErrNotFound {
+		t.Errorf("Config() of a pruned configuration returned %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestStore_ServeHTTP(t *testing.T) {
+	s := newTestStore(t)
+	rtx.Must(s.Record(target, "a", false), "Cannot record")
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		body   string
+	}{
+		{
+			name:   "timeline",
+			method: "GET",
+			url:    "/v1/history?target=" + target,
+			status: http.StatusOK,
+		},
+		{
+			name:   "version",
+			method: "GET",
+			url:    "/v1/history?target=" + target + "&version=" + versionOf("a"),
+			status: http.StatusOK,
+			body:   "a",
+		},
+		{
+			name:   "unknown-version",
+			method: "GET",
+			url:    "/v1/history?target=" + target + "&version=" + versionOf("b"),
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "since",
+			method: "GET",
+			url:    "/v1/history?target=" + target + "&since=2020-01-01T00:00:00Z",
+			status: http.StatusOK,
+		},
+		{
+			name:   "invalid-since",
+			method: "GET",
+			url:    "/v1/history?target=" + target + "&since=yesterday",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown-target",
+			method: "GET",
+			url:    "/v1/history?target=s1-xyz01",
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "invalid-target",
+			method: "GET",
+			url:    "/v1/history?target=..",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "missing-target",
+			method: "GET",
+			url:    "/v1/history",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "method-not-allowed",
+			method: "POST",
+			url:    "/v1/history?target=" + target,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.url, nil))
+			if rr.Code != tt.status {
+				t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, tt.status)
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("ServeHTTP() = %q, want %q", rr.Body.String(), tt.body)
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/history?target="+target, nil))
+	var got Timeline
+	rtx.Must(json.Unmarshal(rr.Body.Bytes(), &got), "Cannot parse timeline")
+	if len(got.Episodes) != 1 || got.Episodes[0].End != nil {
+		t.Errorf("ServeHTTP() = %+v, want one ongoing episode", got)
+	}
+}
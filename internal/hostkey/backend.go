@@ -0,0 +1,98 @@
+package hostkey
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+)
+
+// Backend persists the known host keys.
+type Backend interface {
+	// Load returns the stored data, or nil if nothing has been stored yet.
+	Load(ctx context.Context) ([]byte, error)
+	// Save replaces the stored data.
+	Save(ctx context.Context, data []byte) error
+}
+
+// NewBackend returns a Backend for the specified location, which can be
+// either a gs://bucket/path URL or a local path.
+func NewBackend(ctx context.Context, location string) (Backend, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "gs" {
+		return &fileBackend{path: strings.TrimPrefix(location, "file://")}, nil
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, errors.New("invalid GCS location: " + location)
+	}
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	obj := stiface.AdaptClient(client).Bucket(u.Host).Object(
+		strings.TrimPrefix(u.Path, "/"))
+	return &gcsBackend{obj: obj}, nil
+}
+
+// fileBackend stores the known host keys in a local file.
+type fileBackend struct {
+	path string
+}
+
+func (b *fileBackend) Load(context.Context) ([]byte, error) {
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes the data to a temporary file and renames it, so that the file
+// is never left partially written.
+func (b *fileBackend) Save(_ context.Context, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), b.path)
+}
+
+// gcsBackend stores the known host keys in a GCS object.
+type gcsBackend struct {
+	obj stiface.ObjectHandle
+}
+
+func (b *gcsBackend) Load(ctx context.Context) ([]byte, error) {
+	r, err := b.obj.NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (b *gcsBackend) Save(ctx context.Context, data []byte) error {
+	w := b.obj.NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,109 @@
+package hostkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/google-cloud-go-testing/storage/stiface"
+	"github.com/m-lab/go/rtx"
+)
+
+func TestNewBackend(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewBackend(ctx, "file:///tmp/known_hosts")
+	if err != nil || b.(*fileBackend).path != "/tmp/known_hosts" {
+		t.Errorf("NewBackend() = %v, %v", b, err)
+	}
+	b, err = NewBackend(ctx, "known_hosts")
+	if err != nil || b.(*fileBackend).path != "known_hosts" {
+		t.Errorf("NewBackend() = %v, %v", b, err)
+	}
+	if _, err := NewBackend(ctx, "gs://bucket"); err == nil {
+		t.Errorf("NewBackend() expected err, got nil")
+	}
+}
+
+func Test_fileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostkey")
+	rtx.Must(err, "Cannot create temporary directory")
+
+	b := &fileBackend{path: filepath.Join(dir, "known_hosts")}
+	data, err := b.Load(context.Background())
+	if err != nil || data != nil {
+		t.Errorf("Load() = %q, %v, want nil, nil", data, err)
+	}
+	rtx.Must(b.Save(context.Background(), []byte("test")), "Cannot save")
+	data, err = b.Load(context.Background())
+	if err != nil || string(data) != "test" {
+		t.Errorf("Load() = %q, %v, want test", data, err)
+	}
+
+	b = &fileBackend{path: filepath.Join(dir, "missing", "known_hosts")}
+	if err := b.Save(context.Background(), []byte("test")); err == nil {
+		t.Errorf("Save() expected err, got nil")
+	}
+}
+
+// fakeObject is a GCS object stored in memory.
+type fakeObject struct {
+	stiface.ObjectHandle
+	data    []byte
+	readErr error
+}
+
+func (o *fakeObject) NewReader(context.Context) (stiface.Reader, error) {
+	if o.readErr != nil {
+		return nil, o.readErr
+	}
+	return &fakeReader{r: bytes.NewReader(o.data)}, nil
+}
+
+func (o *fakeObject) NewWriter(context.Context) stiface.Writer {
+	return &fakeWriter{obj: o}
+}
+
+type fakeReader struct {
+	stiface.Reader
+	r *bytes.Reader
+}
+
+func (r *fakeReader) Read(p []byte) (int, error) { return r.r.Read(p) }
+func (r *fakeReader) Close() error               { return nil }
+
+type fakeWriter struct {
+	stiface.Writer
+	obj *fakeObject
+	buf bytes.Buffer
+}
+
+func (w *fakeWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *fakeWriter) Close() error {
+	w.obj.data = w.buf.Bytes()
+	return nil
+}
+
+func Test_gcsBackend(t *testing.T) {
+	obj := &fakeObject{readErr: storage.ErrObjectNotExist}
+	b := &gcsBackend{obj: obj}
+	data, err := b.Load(context.Background())
+	if err != nil || data != nil {
+		t.Errorf("Load() = %q, %v, want nil, nil", data, err)
+	}
+
+	obj.readErr = nil
+	rtx.Must(b.Save(context.Background(), []byte("test")), "Cannot save")
+	data, err = b.Load(context.Background())
+	if err != nil || string(data) != "test" {
+		t.Errorf("Load() = %q, %v, want test", data, err)
+	}
+
+	obj.readErr = errors.New("error")
+	if _, err := b.Load(context.Background()); err == nil {
+		t.Errorf("Load() expected err, got nil")
+	}
+}
@@ -0,0 +1,294 @@
+// Package hostkey implements trust-on-first-use verification of the SSH host
+// keys presented by the switches.
+package hostkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/crypto/ssh"
+)
+
+// Host key policies.
+const (
+	// Insecure accepts any host key without recording it.
+	Insecure = "insecure"
+	// TOFU records the first key seen for each host and reports, but
+	// accepts, any different key.
+	TOFU = "tofu"
+	// Strict records the first key seen for each host and refuses to connect
+	// if a different key is presented.
+	Strict = "strict"
+)
+
+// saveTimeout is the maximum time allowed to persist the known keys.
+const saveTimeout = 30 * time.Second
+
+var (
+	// ErrMismatch is returned in strict mode when a host presents a key
+	// different from the known one.
+	ErrMismatch = errors.New("host key mismatch")
+	// ErrNoPendingKey is returned when accepting a key for a host that did
+	// not present a new one.
+	ErrNoPendingKey = errors.New("no pending host key")
+
+	hostKeyChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "switch_monitoring_host_key_changes_total",
+		Help: "Number of times a switch presented a new SSH host key",
+	}, []string{"target"})
+	hostKeyPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "switch_monitoring_host_key_pending",
+		Help: "Whether a new SSH host key is waiting to be accepted",
+	}, []string{"target"})
+	hostKeySaveErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "switch_monitoring_host_key_save_errors_total",
+		Help: "Number of failures to persist the known SSH host keys",
+	})
+)
+
+// Store keeps track of the host key of every switch. The known keys are
+// persisted through a Backend, while the new keys waiting to be accepted by
+// an operator are kept in memory.
+//
+// Keys recorded on first use are persisted in the background, so that SSH
+// handshakes never wait for the backend.
+type Store struct {
+	strict  bool
+	backend Backend
+
+	// saveMu serializes the writes to the backend. It's acquired before mu.
+	saveMu sync.Mutex
+
+	mu      sync.Mutex
+	known   map[string]ssh.PublicKey
+	pending map[string]ssh.PublicKey
+	// dirty is true if known changed since it was last persisted.
+	dirty bool
+	// saving is true while a background save is running.
+	saving bool
+	// saved is closed and replaced whenever a background save ends.
+	saved chan struct{}
+}
+
+// NewStore returns a Store loading the known keys from the backend. If strict
+// is true, connections to hosts presenting a different key are refused.
+func NewStore(ctx context.Context, backend Backend, strict bool) (*Store, error) {
+	data, err := backend.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	known, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		strict:  strict,
+		backend: backend,
+		known:   known,
+		pending: map[string]ssh.PublicKey{},
+		saved:   make(chan struct{}),
+	}, nil
+}
+
+// Check verifies the key presented by a host. Unknown hosts are trusted and
+// their key is recorded. If the key differs from the known one, it is kept
+// as pending until accepted and the connection is refused in strict mode.
+func (s *Store) Check(host string, key ssh.PublicKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	known, ok := s.known[host]
+	if !ok {
+		log.WithFields(log.Fields{
+			"target":      host,
+			"fingerprint": ssh.FingerprintSHA256(key),
+		}).Info("Recording SSH host key on first use")
+		s.known[host] = key
+		s.persist()
+		return nil
+	}
+
+	if bytes.Equal(known.Marshal(), key.Marshal()) {
+		return nil
+	}
+
+	if p, ok := s.pending[host]; !ok || !bytes.Equal(p.Marshal(), key.Marshal()) {
+		hostKeyChanges.WithLabelValues(host).Inc()
+		hostKeyPending.WithLabelValues(host).Set(1)
+		s.pending[host] = key
+	}
+	log.WithFields(log.Fields{
+		"target":          host,
+		"fingerprint":     ssh.FingerprintSHA256(key),
+		"old_fingerprint": ssh.FingerprintSHA256(known),
+		"strict":          s.strict,
+	}).Warn("SSH host key changed")
+
+	if s.strict {
+		return fmt.Errorf("%w for %s: got %s", ErrMismatch, host,
+			ssh.FingerprintSHA256(key))
+	}
+	return nil
+}
+
+// Accept replaces the known key of a host with its pending one. The known
+// keys are persisted before returning.
+func (s *Store) Accept(host string) error {
+	s.mu.Lock()
+	key, ok := s.pending[host]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNoPendingKey
+	}
+	s.known[host] = key
+	s.dirty = true
+	delete(s.pending, host)
+	hostKeyPending.WithLabelValues(host).Set(0)
+	s.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"target":      host,
+		"fingerprint": ssh.FingerprintSHA256(key),
+	}).Info("New SSH host key accepted")
+	return s.save()
+}
+
+// Flush waits for the background saves to complete, e.g. before exiting.
+func (s *Store) Flush(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		saving, saved := s.saving, s.saved
+		s.mu.Unlock()
+		if !saving {
+			return nil
+		}
+		select {
+		case <-saved:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ServeAccept handles POST requests to accept the pending key for the host
+// specified by the target parameter.
+func (s *Store) ServeAccept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if len(target) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("URL parameter 'target' is missing"))
+		return
+	}
+
+	err := s.Accept(target)
+	switch {
+	case errors.Is(err, ErrNoPendingKey):
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+	case err != nil:
+		log.WithError(err).Error("Cannot save the accepted host key")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// persist marks the known keys as changed and starts a background save,
+// unless one is already running. Keys recorded while a save is running are
+// persisted by the same goroutine once it completes, so that discovering many
+// switches at once doesn't write the backend once per switch. It must be
+// called with s.mu held.
+func (s *Store) persist() {
+	s.dirty = true
+	if s.saving {
+		return
+	}
+	s.saving = true
+	go s.saveAll()
+}
+
+// saveAll saves the known keys until no change is left to persist. If a save
+// fails, the keys are kept in memory and persisted with the next change.
+func (s *Store) saveAll() {
+	for {
+		s.mu.Lock()
+		if !s.dirty {
+			s.saving = false
+			close(s.saved)
+			s.saved = make(chan struct{})
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		s.save()
+	}
+}
+
+// save persists the known keys. It must be called without s.mu held, since
+// the backend can be slow. The keys are copied after acquiring saveMu, so that
+// concurrent saves can't overwrite newer keys with older ones. Errors are
+// logged, counted and returned.
+func (s *Store) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data := s.marshal()
+	s.dirty = false
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+	err := s.backend.Save(ctx, data)
+	if err != nil {
+		hostKeySaveErrors.Inc()
+		log.WithError(err).Error("Cannot save the known host keys")
+	}
+	return err
+}
+
+// marshal returns the known keys in the known_hosts format, sorted by host.
+func (s *Store) marshal() []byte {
+	hosts := make([]string, 0, len(s.known))
+	for h := range s.known {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	buf := new(bytes.Buffer)
+	for _, h := range hosts {
+		fmt.Fprintf(buf, "%s %s", h, ssh.MarshalAuthorizedKey(s.known[h]))
+	}
+	return buf.Bytes()
+}
+
+// parse reads a known_hosts file with a single host per line.
+func parse(data []byte) (map[string]ssh.PublicKey, error) {
+	known := map[string]ssh.PublicKey{}
+	for len(bytes.TrimSpace(data)) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range hosts {
+			known[h] = key
+		}
+		data = rest
+	}
+	return known, nil
+}
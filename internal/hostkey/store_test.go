@@ -0,0 +1,250 @@
+package hostkey
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// memBackend is an in-memory Backend. If block is set, saves wait until it's
+// closed.
+type memBackend struct {
+	mu      sync.Mutex
+	data    []byte
+	saves   int
+	loadErr error
+	saveErr error
+	block   chan struct{}
+}
+
+func (b *memBackend) Load(context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data, b.loadErr
+}
+
+func (b *memBackend) Save(_ context.Context, data []byte) error {
+	b.mu.Lock()
+	block := b.block
+	b.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.saveErr != nil {
+		return b.saveErr
+	}
+	b.data = data
+	b.saves++
+	return nil
+}
+
+func (b *memBackend) saved() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data), b.saves
+}
+
+func (b *memBackend) contents() string {
+	data, _ := b.saved()
+	return data
+}
+
+func (b *memBackend) setSaveErr(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.saveErr = err
+}
+
+func newKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(nil)
+	rtx.Must(err, "Cannot generate key")
+	key, err := ssh.NewPublicKey(pub)
+	rtx.Must(err, "Cannot convert key")
+	return key
+}
+
+func TestNewStore(t *testing.T) {
+	key := newKey(t)
+	b := &memBackend{
+		data: []byte("# comment\ns1-abc01 " + string(ssh.MarshalAuthorizedKey(key))),
+	}
+	s, err := NewStore(context.Background(), b, false)
+	if err != nil {
+		t.Fatalf("NewStore() returned err: %v", err)
+	}
+	if err := s.Check("s1-abc01", key); err != nil {
+		t.Errorf("Check() returned err for a known key: %v", err)
+	}
+	rtx.Must(s.Flush(context.Background()), "Cannot flush store")
+	if _, saves := b.saved(); saves != 0 {
+		t.Errorf("Check() saved the store for a known key")
+	}
+
+	_, err = NewStore(context.Background(), &memBackend{data: []byte("invalid")}, false)
+	if err == nil {
+		t.Errorf("NewStore() expected err, got nil")
+	}
+	_, err = NewStore(context.Background(), &memBackend{loadErr: errors.New("error")}, false)
+	if err == nil {
+		t.Errorf("NewStore() expected err, got nil")
+	}
+}
+
+func TestStore_Check(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		host := "s1-tofu"
+		if strict {
+			host = "s1-strict"
+		}
+		b := &memBackend{}
+		s, err := NewStore(context.Background(), b, strict)
+		rtx.Must(err, "Cannot create store")
+
+		// The first key is trusted and saved.
+		oldKey, newKey := newKey(t), newKey(t)
+		if err := s.Check(host, oldKey); err != nil {
+			t.Errorf("Check() returned err on first use: %v", err)
+		}
+		rtx.Must(s.Flush(context.Background()), "Cannot flush store")
+		if want := host + " " + string(ssh.MarshalAuthorizedKey(oldKey)); b.contents() != want {
+			t.Errorf("saved data = %q, want %q", b.contents(), want)
+		}
+
+		// A different key is reported once, and refused in strict mode only.
+		for i := 0; i < 2; i++ {
+			err = s.Check(host, newKey)
+			if strict != errors.Is(err, ErrMismatch) {
+				t.Errorf("Check(strict=%v) returned err %v", strict, err)
+			}
+		}
+		if v := testutil.ToFloat64(hostKeyChanges.WithLabelValues(host)); v != 1 {
+			t.Errorf("host key changes = %v, want 1", v)
+		}
+		if v := testutil.ToFloat64(hostKeyPending.WithLabelValues(host)); v != 1 {
+			t.Errorf("host key pending = %v, want 1", v)
+		}
+
+		// Once accepted, the new key becomes the known one.
+		rtx.Must(s.Accept(host), "Cannot accept key")
+		if err := s.Check(host, newKey); err != nil {
+			t.Errorf("Check() returned err for an accepted key: %v", err)
+		}
+		if v := testutil.ToFloat64(hostKeyPending.WithLabelValues(host)); v != 0 {
+			t.Errorf("host key pending = %v, want 0", v)
+		}
+		if !strings.Contains(b.contents(), string(ssh.MarshalAuthorizedKey(newKey))) {
+			t.Errorf("accepted key not saved: %q", b.contents())
+		}
+		if err := s.Accept(host); err != ErrNoPendingKey {
+			t.Errorf("Accept() error = %v, want %v", err, ErrNoPendingKey)
+		}
+	}
+}
+
+func TestStore_Check_slowBackend(t *testing.T) {
+	b := &memBackend{block: make(chan struct{})}
+	s, err := NewStore(context.Background(), b, false)
+	rtx.Must(err, "Cannot create store")
+
+	// Checks don't wait for the backend, and the keys recorded during a save
+	// are persisted together once it completes.
+	keys := map[string]ssh.PublicKey{}
+	for _, host := range []string{"s1-abc01", "s1-abc02", "s1-abc03"} {
+		keys[host] = newKey(t)
+		done := make(chan error)
+		go func() { done <- s.Check(host, keys[host]) }()
+		select {
+		case err := <-done:
+			rtx.Must(err, "Cannot check key")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Check() blocked on a slow backend")
+		}
+	}
+	close(b.block)
+	rtx.Must(s.Flush(context.Background()), "Cannot flush store")
+
+	data, saves := b.saved()
+	if saves > 2 {
+		t.Errorf("saves = %d, want at most 2", saves)
+	}
+	for host, key := range keys {
+		if !strings.Contains(data, host+" "+string(ssh.MarshalAuthorizedKey(key))) {
+			t.Errorf("key of %s not saved: %q", host, data)
+		}
+	}
+}
+
+func TestStore_Check_saveError(t *testing.T) {
+	b := &memBackend{saveErr: errors.New("error")}
+	s, err := NewStore(context.Background(), b, false)
+	rtx.Must(err, "Cannot create store")
+
+	before := testutil.ToFloat64(hostKeySaveErrors)
+	rtx.Must(s.Check("s1-abc01", newKey(t)), "Cannot check key")
+	rtx.Must(s.Flush(context.Background()), "Cannot flush store")
+	if v := testutil.ToFloat64(hostKeySaveErrors) - before; v != 1 {
+		t.Errorf("host key save errors = %v, want 1", v)
+	}
+
+	// The key kept in memory is persisted with the next change.
+	b.setSaveErr(nil)
+	rtx.Must(s.Check("s1-abc02", newKey(t)), "Cannot check key")
+	rtx.Must(s.Flush(context.Background()), "Cannot flush store")
+	if data, _ := b.saved(); !strings.Contains(data, "s1-abc01 ") {
+		t.Errorf("key recorded during a failed save was lost: %q", data)
+	}
+}
+
+func TestStore_ServeAccept(t *testing.T) {
+	b := &memBackend{}
+	s, err := NewStore(context.Background(), b, true)
+	rtx.Must(err, "Cannot create store")
+	s.Check("s1-abc01", newKey(t))
+	s.Check("s1-abc01", newKey(t))
+	s.Check("s1-xyz01", newKey(t))
+	s.Check("s1-xyz01", newKey(t))
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		saveErr error
+		want    int
+	}{
+		{name: "ok", method: http.MethodPost, target: "s1-abc01", want: http.StatusOK},
+		{name: "no-pending-key", method: http.MethodPost, target: "s1-abc01", want: http.StatusNotFound},
+		{name: "missing-target", method: http.MethodPost, want: http.StatusBadRequest},
+		{name: "wrong-method", method: http.MethodGet, target: "s1-xyz01", want: http.StatusMethodNotAllowed},
+		{
+			name:    "save-error",
+			method:  http.MethodPost,
+			target:  "s1-xyz01",
+			saveErr: errors.New("error"),
+			want:    http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.setSaveErr(tt.saveErr)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/v1/hostkeys/accept?target="+tt.target, nil)
+			s.ServeAccept(rw, req)
+			if rw.Code != tt.want {
+				t.Errorf("ServeAccept() returned %d, want %d", rw.Code, tt.want)
+			}
+		})
+	}
+}
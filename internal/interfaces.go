@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -8,7 +9,13 @@ import (
 
 // NetconfClient is a generic NETCONF client.
 type NetconfClient interface {
-	GetConfig(hostname string, section ...string) (string, error)
+	GetConfig(ctx context.Context, hostname string, section ...string) (string, error)
+}
+
+// RPCClient runs raw NETCONF RPCs on a switch and returns the content of
+// the reply.
+type RPCClient interface {
+	Exec(ctx context.Context, hostname, rpc string) (string, error)
 }
 
 // HTTPProvider is a data provider returning HTTP responses.
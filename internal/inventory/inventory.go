@@ -0,0 +1,195 @@
+// Package inventory keeps track of the switches on the platform, as listed
+// by siteinfo.
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal"
+)
+
+// ErrNotLoaded is returned when the inventory is used before the list of
+// switches has been fetched successfully.
+var ErrNotLoaded = errors.New("switch inventory not loaded yet")
+
+// switchInfo is an entry of siteinfo's switches.json, which maps site names
+// to the details of their switch. Only the fields needed here are decoded.
+type switchInfo struct {
+	SwitchMake string `json:"switch_make"`
+}
+
+// target is a switch in the inventory.
+type target struct {
+	hostname string
+	site     string
+	vendor   string
+}
+
+// targetGroup is an entry of the Prometheus HTTP service discovery format.
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Inventory is the list of switches on the platform. It's fetched from the
+// siteinfo switches.json and must be refreshed periodically.
+type Inventory struct {
+	provider   internal.HTTPProvider
+	url        string
+	hostFormat string
+
+	mu      sync.RWMutex
+	targets []target
+	known   map[string]target
+}
+
+// New returns an Inventory fetching switches.json from the specified URL.
+// hostFormat is used to build the hostname of each switch from the site
+// name, e.g. "s1-%s.measurement-lab.org".
+func New(provider internal.HTTPProvider, url, hostFormat string) *Inventory {
+	return &Inventory{
+		provider:   provider,
+		url:        url,
+		hostFormat: hostFormat,
+	}
+}
+
+// Refresh fetches the list of switches. If it fails, the previous list is
+// kept.
+func (inv *Inventory) Refresh() error {
+	resp, err := inv.provider.Get(inv.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot fetch %s: %s", inv.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var switches map[string]switchInfo
+	if err := json.Unmarshal(body, &switches); err != nil {
+		return fmt.Errorf("cannot parse %s: %w", inv.url, err)
+	}
+
+	targets := make([]target, 0, len(switches))
+	known := make(map[string]target, len(switches))
+	for site, info := range switches {
+		t := target{
+			hostname: fmt.Sprintf(inv.hostFormat, site),
+			site:     site,
+			vendor:   info.SwitchMake,
+		}
+		targets = append(targets, t)
+		known[t.hostname] = t
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].hostname < targets[j].hostname
+	})
+
+	inv.mu.Lock()
+	inv.targets = targets
+	inv.known = known
+	inv.mu.Unlock()
+	return nil
+}
+
+// Run refreshes the inventory every interval until the context is canceled.
+// The first refresh happens immediately. Errors are logged.
+func (inv *Inventory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := inv.Refresh(); err != nil {
+			log.WithError(err).Error("Cannot refresh the switch inventory")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Targets returns the hostnames of all the switches.
+func (inv *Inventory) Targets(context.Context) ([]string, error) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	if inv.known == nil {
+		return nil, ErrNotLoaded
+	}
+	hostnames := make([]string, len(inv.targets))
+	for i, t := range inv.targets {
+		hostnames[i] = t.hostname
+	}
+	return hostnames, nil
+}
+
+// Contains returns true if the hostname is a switch in the inventory.
+func (inv *Inventory) Contains(hostname string) (bool, error) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	if inv.known == nil {
+		return false, ErrNotLoaded
+	}
+	_, ok := inv.known[hostname]
+	return ok, nil
+}
+
+// Vendor returns the make of the switch, as listed in siteinfo, or false if
+// the hostname is not in the inventory.
+func (inv *Inventory) Vendor(hostname string) (string, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	t, ok := inv.known[hostname]
+	return t.vendor, ok
+}
+
+// Site returns the site of the switch, as listed in siteinfo, or false if
+// the hostname is not in the inventory.
+func (inv *Inventory) Site(hostname string) (string, bool) {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	t, ok := inv.known[hostname]
+	return t.site, ok
+}
+
+// ServeHTTP serves the list of switches in the Prometheus HTTP service
+// discovery format, with a "site" label for every switch.
+func (inv *Inventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	inv.mu.RLock()
+	if inv.known == nil {
+		inv.mu.RUnlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(ErrNotLoaded.Error()))
+		return
+	}
+	groups := make([]targetGroup, len(inv.targets))
+	for i, t := range inv.targets {
+		groups[i] = targetGroup{
+			Targets: []string{t.hostname},
+			Labels:  map[string]string{"site": t.site},
+		}
+	}
+	inv.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
@@ -0,0 +1,132 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const hostFormat = "s1-%s.measurement-lab.org"
+
+// mockProvider is an HTTPProvider returning a fixed response.
+type mockProvider struct {
+	status int
+	body   string
+	err    error
+}
+
+func (p *mockProvider) Get(string) (*http.Response, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &http.Response{
+		StatusCode: p.status,
+		Status:     http.StatusText(p.status),
+		Body:       ioutil.NopCloser(strings.NewReader(p.body)),
+	}, nil
+}
+
+func TestInventory_Refresh(t *testing.T) {
+	provider := &mockProvider{
+		status: http.StatusOK,
+		body:   `{"xyz01": {"switch_make": "juniper"}, "abc01": {}}`,
+	}
+	inv := New(provider, "https://siteinfo/switches.json", hostFormat)
+
+	// Before the first refresh, the inventory cannot be used.
+	if _, err := inv.Targets(context.Background()); err != ErrNotLoaded {
+		t.Errorf("Targets() error = %v, want %v", err, ErrNotLoaded)
+	}
+	if _, err := inv.Contains("s1-abc01.measurement-lab.org"); err != ErrNotLoaded {
+		t.Errorf("Contains() error = %v, want %v", err, ErrNotLoaded)
+	}
+
+	if err := inv.Refresh(); err != nil {
+		t.Fatalf("Refresh() returned err: %v", err)
+	}
+	want := []string{"s1-abc01.measurement-lab.org", "s1-xyz01.measurement-lab.org"}
+	if got, err := inv.Targets(context.Background()); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Targets() = %v, %v, want %v", got, err, want)
+	}
+	if ok, err := inv.Contains("s1-abc01.measurement-lab.org"); !ok || err != nil {
+		t.Errorf("Contains() = %v, %v, want true", ok, err)
+	}
+	if ok, err := inv.Contains("s1-abc02.measurement-lab.org"); ok || err != nil {
+		t.Errorf("Contains() = %v, %v, want false", ok, err)
+	}
+	if v, ok := inv.Vendor("s1-xyz01.measurement-lab.org"); !ok || v != "juniper" {
+		t.Errorf("Vendor() = %q, %v, want juniper", v, ok)
+	}
+	if _, ok := inv.Vendor("s1-abc02.measurement-lab.org"); ok {
+		t.Errorf("Vendor() = true, want false")
+	}
+	if s, ok := inv.Site("s1-xyz01.measurement-lab.org"); !ok || s != "xyz01" {
+		t.Errorf("Site() = %q, %v, want xyz01", s, ok)
+	}
+	if _, ok := inv.Site("s1-abc02.measurement-lab.org"); ok {
+		t.Errorf("Site() = true, want false")
+	}
+
+	// Failed refreshes keep the previous list.
+	for _, p := range []mockProvider{
+		{err: errors.New("error")},
+		{status: http.StatusNotFound},
+		{status: http.StatusOK, body: "invalid"},
+	} {
+		*provider = p
+		if err := inv.Refresh(); err == nil {
+			t.Errorf("Refresh() expected err, got nil")
+		}
+		if got, _ := inv.Targets(context.Background()); !reflect.DeepEqual(got, want) {
+			t.Errorf("Targets() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestInventory_Run(t *testing.T) {
+	inv := New(&mockProvider{status: http.StatusOK, body: `{"abc01": {}}`},
+		"https://siteinfo/switches.json", hostFormat)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The first refresh happens before Run returns.
+	inv.Run(ctx, time.Hour)
+	if ok, err := inv.Contains("s1-abc01.measurement-lab.org"); !ok || err != nil {
+		t.Errorf("Contains() = %v, %v, want true", ok, err)
+	}
+}
+
+func TestInventory_ServeHTTP(t *testing.T) {
+	inv := New(&mockProvider{status: http.StatusOK, body: `{"abc01": {}}`},
+		"https://siteinfo/switches.json", hostFormat)
+
+	rr := httptest.NewRecorder()
+	inv.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code,
+			http.StatusServiceUnavailable)
+	}
+
+	if err := inv.Refresh(); err != nil {
+		t.Fatalf("Refresh() returned err: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	inv.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets", nil))
+	want := `[{"targets":["s1-abc01.measurement-lab.org"],"labels":{"site":"abc01"}}]` + "\n"
+	if rr.Code != http.StatusOK || rr.Body.String() != want {
+		t.Errorf("ServeHTTP() = %d %s, want %s", rr.Code, rr.Body.String(), want)
+	}
+
+	rr = httptest.NewRecorder()
+	inv.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/targets", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("ServeHTTP() returned %d, want %d", rr.Code,
+			http.StatusMethodNotAllowed)
+	}
+}
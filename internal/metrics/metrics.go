@@ -0,0 +1,70 @@
+// Package metrics provides the metrics about the service itself, as opposed
+// to the switches it monitors.
+package metrics
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unknown is reported for the build information that is not available.
+const unknown = "unknown"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "switch_monitoring_http_requests_total",
+		Help: "Number of HTTP requests served, by path and status code",
+	}, []string{"path", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "switch_monitoring_http_request_duration_seconds",
+		Help: "Duration of the HTTP requests served, by path and status code",
+		// Checks connect to the switches, which can take several seconds.
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"path", "code"})
+	inFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "switch_monitoring_http_requests_in_flight",
+		Help: "Number of HTTP requests being served, by path",
+	}, []string{"path"})
+
+	buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "switch_monitoring_build_info",
+		Help: "Build information, as labels with a constant value of 1",
+	}, []string{"version", "revision", "go_version"})
+)
+
+func init() {
+	version, revision := build(debug.ReadBuildInfo())
+	buildInfo.WithLabelValues(version, revision, runtime.Version()).Set(1)
+}
+
+// Instrument wraps h so that its requests are counted and timed under the
+// specified path.
+func Instrument(path string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"path": path}
+	return promhttp.InstrumentHandlerInFlight(inFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(requests.MustCurryWith(labels), h)))
+}
+
+// build returns the module version and the VCS revision the binary was built
+// from.
+func build(info *debug.BuildInfo, ok bool) (string, string) {
+	version, revision := unknown, unknown
+	if !ok {
+		return version, revision
+	}
+	if info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && s.Value != "" {
+			revision = s.Value
+		}
+	}
+	return version, revision
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrument(t *testing.T) {
+	h := Instrument("/v1/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	for _, url := range []string{"/v1/test", "/v1/test", "/v1/test?fail=1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+
+	if v := testutil.ToFloat64(requests.WithLabelValues("/v1/test", "200")); v != 2 {
+		t.Errorf("requests{200} = %v, want 2", v)
+	}
+	if v := testutil.ToFloat64(requests.WithLabelValues("/v1/test", "502")); v != 1 {
+		t.Errorf("requests{502} = %v, want 1", v)
+	}
+	if n := testutil.CollectAndCount(requestDuration); n != 2 {
+		t.Errorf("request durations = %d series, want 2", n)
+	}
+	if v := testutil.ToFloat64(inFlight.WithLabelValues("/v1/test")); v != 0 {
+		t.Errorf("in-flight requests = %v, want 0", v)
+	}
+}
+
+func Test_build(t *testing.T) {
+	tests := []struct {
+		name         string
+		info         *debug.BuildInfo
+		ok           bool
+		wantVersion  string
+		wantRevision string
+	}{
+		{
+			name:         "not-available",
+			wantVersion:  unknown,
+			wantRevision: unknown,
+		},
+		{
+			name: "module",
+			info: &debug.BuildInfo{
+				Main: debug.Module{Version: "v1.2.3"},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs", Value: "git"},
+					{Key: "vcs.revision", Value: "abc123"},
+				},
+			},
+			ok:           true,
+			wantVersion:  "v1.2.3",
+			wantRevision: "abc123",
+		},
+		{
+			name:         "no-vcs",
+			info:         &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}},
+			ok:           true,
+			wantVersion:  "(devel)",
+			wantRevision: unknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, revision := build(tt.info, tt.ok)
+			if version != tt.wantVersion || revision != tt.wantRevision {
+				t.Errorf("build() = %q, %q, want %q, %q", version, revision,
+					tt.wantVersion, tt.wantRevision)
+			}
+		})
+	}
+
+	if n := testutil.CollectAndCount(buildInfo); n != 1 {
+		t.Errorf("build info = %d series, want 1", n)
+	}
+}
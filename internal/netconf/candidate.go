@@ -0,0 +1,88 @@
+package netconf
+
+import "errors"
+
+// ErrMaskedValue is returned when the configuration to push to a switch
+// contains the placeholder of a masked value instead of the actual one.
+var ErrMaskedValue = errors.New("configuration contains masked values")
+
+// Candidate returns the configuration to push to a switch to restore the
+// expected configuration, in the JunOS text format. The statements that the
+// rules ignore or mask are not compared, so the expected configuration may be
+// outdated or redacted there: they are kept as found in the running
+// configuration instead, e.g. so that passwords are never overwritten. A nil
+// rules applies the default rules only. Replacements are not applied, since
+// they only make configurations comparable.
+//
+// It fails with ErrMaskedValue if the candidate contains the placeholder of a
+// masked value, e.g. because the expected configuration was copied from a
+// report.
+func Candidate(expected, running string, rules *Rules) (string, error) {
+	if rules == nil {
+		rules = &defaultRules
+	}
+	candidate := rules.merge(Parse(expected), Parse(running), nil)
+	if containsMasked(candidate) {
+		return "", ErrMaskedValue
+	}
+	return candidate.String(), nil
+}
+
+// merge returns a copy of expected whose ignored and masked statements are
+// replaced with the ones of running. prefix contains the words of both nodes
+// and all of their parents.
+func (r *Rules) merge(expected, running *Node, prefix []string) *Node {
+	res := &Node{Words: expected.Words, Container: expected.Container}
+	runningByKey := childrenByKey(running)
+	seen := map[string]int{}
+	for _, e := range expected.Children {
+		words := append(prefix[:len(prefix):len(prefix)], e.Words...)
+		if r.ignored(words, len(prefix)) {
+			continue
+		}
+		k := e.key()
+		i := seen[k]
+		seen[k]++
+		if !e.Container {
+			res.Children = append(res.Children, e)
+			continue
+		}
+		// Statements within a container missing from the switch are
+		// compared with an empty container.
+		other := &Node{Container: true}
+		if i < len(runningByKey[k]) {
+			other = runningByKey[k][i]
+		}
+		res.Children = append(res.Children, r.merge(e, other, words))
+	}
+	for _, c := range running.Children {
+		words := append(prefix[:len(prefix):len(prefix)], c.Words...)
+		if r.ignored(words, len(prefix)) {
+			res.Children = append(res.Children, c)
+		}
+	}
+	return res
+}
+
+// ignored returns true if the statement with the specified words is ignored
+// or masked, prefix being the number of words of its parents.
+func (r *Rules) ignored(words []string, prefix int) bool {
+	return r.matches(r.Ignore, words, prefix, len(words)) > 0 ||
+		r.matches(r.Mask, words, prefix, len(words)-1) > 0
+}
+
+// containsMasked returns true if any statement of the tree contains the
+// placeholder of a masked value, quoted or not.
+func containsMasked(n *Node) bool {
+	for _, w := range n.Words {
+		if w == maskedValue || w == `"`+maskedValue+`"` {
+			return true
+		}
+	}
+	for _, c := range n.Children {
+		if containsMasked(c) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package netconf
+
+import "testing"
+
+func TestCandidate(t *testing.T) {
+	r, err := LoadRules("testdata/rules.json")
+	if err != nil {
+		t.Fatalf("LoadRules() returned err: %v", err)
+	}
+	rules := r.ForSite("abc01")
+
+	running := `version 1.0;
+system {
+    host-name s1;
+    ntp {
+        server 1.2.3.4;
+    }
+    root-authentication {
+        encrypted-password "$6$running";
+    }
+}
+snmp {
+    location "Somewhere, Earth";
+}
+interfaces {
+    xe-0/0/1 {
+        description "uplink";
+        mtu 1500;
+    }
+}`
+	tests := []struct {
+		name     string
+		expected string
+		rules    *Rules
+		want     string
+		wantErr  error
+	}{
+		{
+			name: "keeps-ignored-and-masked",
+			expected: `system {
+    host-name s2;
+    ntp {
+        server 5.6.7.8;
+    }
+    root-authentication {
+        encrypted-password "$6$expected";
+    }
+}
+snmp {
+    location "<masked>";
+    community public;
+}
+interfaces {
+    xe-0/0/1 {
+        description "old uplink";
+        mtu 9216;
+    }
+}`,
+			rules: rules,
+			want: `interfaces {
+    xe-0/0/1 {
+        description "uplink";
+        mtu 1500;
+    }
+}
+snmp {
+    community public;
+    location "Somewhere, Earth";
+}
+system {
+    host-name s2;
+    ntp {
+        server 1.2.3.4;
+    }
+    root-authentication {
+        encrypted-password "$6$running";
+    }
+}
+version 1.0;`,
+		},
+		{
+			name:     "default-rules",
+			expected: "system {\n    host-name s2;\n}",
+			want:     "system {\n    host-name s2;\n}\nversion 1.0;",
+		},
+		{
+			name:     "ordered-statements",
+			expected: "firewall {\n    filter f {\n        term b;\n        term a;\n    }\n}",
+			want:     "firewall {\n    filter f {\n        term b;\n        term a;\n    }\n}\nversion 1.0;",
+		},
+		{
+			name:     "quoted-regex",
+			expected: "policy-options {\n    policy-statement p {\n        from as-path-regex \"^65000\\.1$\";\n    }\n}",
+			want:     "policy-options {\n    policy-statement p {\n        from as-path-regex \"^65000\\.1$\";\n    }\n}\nversion 1.0;",
+		},
+		{
+			name:     "masked-value",
+			expected: "system {\n    host-name <masked>;\n}",
+			wantErr:  ErrMaskedValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Candidate(tt.expected, running, tt.rules)
+			if err != tt.wantErr {
+				t.Fatalf("Candidate() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Candidate() = \n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
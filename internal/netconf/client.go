@@ -1,38 +1,143 @@
 package netconf
 
 import (
-	"github.com/scottdware/go-junos"
+	"context"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/credentials"
 )
 
 // Client is a client to get the switch configuration using the
 // NETCONF protocol.
 type Client struct {
-	auth      *junos.AuthMethod
+	auth      credentials.Provider
 	connector connector
 }
 
-// New returns a new NetconfClient.
-func New(auth *junos.AuthMethod) Client {
+// options are the optional settings of a Client.
+type options struct {
+	hostKeys HostKeyPolicy
+	pool     *PoolConfig
+}
+
+// Option configures how a Client connects to the switches.
+type Option func(*options)
+
+// WithHostKeyPolicy makes the client verify the switches' host keys with
+// the provided policy. By default, any host key is accepted.
+func WithHostKeyPolicy(p HostKeyPolicy) Option {
+	return func(o *options) {
+		o.hostKeys = p
+	}
+}
+
+// WithPool makes the client keep sessions open and reuse them across calls,
+// according to the provided configuration. The client must be closed when
+// it's not needed anymore.
+func WithPool(config PoolConfig) Option {
+	return func(o *options) {
+		o.pool = &config
+	}
+}
+
+// New returns a new NetconfClient for JunOS devices, reading their
+// configuration in the JunOS text format.
+func New(auth credentials.Provider, opts ...Option) Client {
+	o := newOptions(opts)
+	return newClient(auth, junosConnector{hostKeys: o.hostKeys}, o)
+}
+
+// NewGeneric returns a new NetconfClient for any device supporting standard
+// NETCONF (RFC 6241), reading their configuration as XML.
+func NewGeneric(auth credentials.Provider, opts ...Option) Client {
+	o := newOptions(opts)
+	return newClient(auth, genericConnector{hostKeys: o.hostKeys}, o)
+}
+
+// NewPooled returns a new NetconfClient for JunOS devices keeping sessions
+// open and reusing them across calls, according to the provided
+// configuration. The client must be closed when it's not needed anymore.
+func NewPooled(auth credentials.Provider, config PoolConfig, opts ...Option) Client {
+	return New(auth, append(opts, WithPool(config))...)
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func newClient(auth credentials.Provider, c connector, o options) Client {
+	if o.pool != nil {
+		c = newPoolConnector(c, *o.pool)
+	}
 	return Client{
 		auth:      auth,
-		connector: junosConnector{},
+		connector: c,
+	}
+}
+
+// Close closes any idle session kept open by a pooled client. It's a no-op
+// for clients that are not pooled.
+func (c Client) Close() {
+	if p, ok := c.connector.(*poolConnector); ok {
+		p.Close()
 	}
 }
 
 // GetConfig connects to a switch, reads the specified configuration section
 // and returns its content. The section can be an empty string. In that case,
 // the whole configuration will be read.
-func (c Client) GetConfig(hostname string, section ...string) (string, error) {
-	jnpr, err := c.connector.NewSession(hostname, c.auth)
+//
+// If the context is canceled or its deadline expires, the session with the
+// switch is closed and the call returns immediately. Errors are of type
+// *Error, whose Reason describes why the call failed.
+func (c Client) GetConfig(ctx context.Context, hostname string, section ...string) (string, error) {
+	jnpr, err := c.session(ctx, c.connector, hostname)
 	if err != nil {
 		return "", err
 	}
 	defer jnpr.Close()
 
-	config, err := jnpr.GetConfig("text", section...)
+	start := time.Now()
+	config, err := jnpr.GetConfig(ctx, "text", section...)
+	timing(ctx).RPC += time.Since(start)
 	if err != nil {
-		return "", err
+		return "", classify(err, ReasonRPC)
 	}
 
 	return config, nil
 }
+
+// Exec connects to a switch, runs a raw RPC (e.g.
+// "<get-alarm-information/>") and returns the content of the reply.
+//
+// If the context is canceled or its deadline expires, the session with the
+// switch is closed and the call returns immediately.
+func (c Client) Exec(ctx context.Context, hostname, rpc string) (string, error) {
+	jnpr, err := c.session(ctx, c.connector, hostname)
+	if err != nil {
+		return "", err
+	}
+	defer jnpr.Close()
+
+	start := time.Now()
+	reply, err := jnpr.Exec(ctx, rpc)
+	timing(ctx).RPC += time.Since(start)
+	return reply, classify(err, ReasonRPC)
+}
+
+// session returns a session for the host from the connector, adding the time
+// it took to the context's Timing. The session is counted as in use until
+// it's closed.
+func (c Client) session(ctx context.Context, connector connector, hostname string) (connection, error) {
+	start := time.Now()
+	conn, err := connector.NewSession(ctx, hostname, c.auth)
+	timing(ctx).Connect += time.Since(start)
+	if err != nil {
+		return nil, classify(err, ReasonUnknown)
+	}
+	return withGauge(conn, sessionsInUse), nil
+}
@@ -1,11 +1,14 @@
 package netconf
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
-	"github.com/scottdware/go-junos"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+	"golang.org/x/crypto/ssh"
 )
 
 type mockConnector struct {
@@ -13,7 +16,7 @@ type mockConnector struct {
 	mustFailConn bool
 }
 
-func (c mockConnector) NewSession(string, *junos.AuthMethod) (connection, error) {
+func (c mockConnector) NewSession(context.Context, string, credentials.Provider) (connection, error) {
 	if c.mustFail {
 		return nil, fmt.Errorf("error")
 	}
@@ -23,10 +26,12 @@ func (c mockConnector) NewSession(string, *junos.AuthMethod) (connection, error)
 }
 
 type mockConnection struct {
-	mustFail bool
+	mustFail     bool
+	mustFailPing bool
+	closed       bool
 }
 
-func (c *mockConnection) GetConfig(string, ...string) (string, error) {
+func (c *mockConnection) GetConfig(context.Context, string, ...string) (string, error) {
 	if c.mustFail {
 		return "", fmt.Errorf("error")
 	}
@@ -39,26 +44,80 @@ func (c *mockConnection) GetConfig(string, ...string) (string, error) {
 	return string(testfile), nil
 }
 
+func (c *mockConnection) Exec(_ context.Context, rpc string) (string, error) {
+	if c.mustFail {
+		return "", fmt.Errorf("error")
+	}
+	return "<reply>" + rpc + "</reply>", nil
+}
+
+func (c *mockConnection) Ping(context.Context) error {
+	if c.mustFailPing {
+		return fmt.Errorf("error")
+	}
+	return nil
+}
+
 func (c *mockConnection) Close() {
-	// not implemented.
+	c.closed = true
 }
 
 func TestNew(t *testing.T) {
-	auth := &junos.AuthMethod{}
+	auth := &credentials.Config{}
 	netconf := New(auth)
 	if netconf.auth != auth {
 		t.Errorf("New() didn't return the expected struct.")
 	}
+
+	policy := hostKeyPolicyFunc(func(string, ssh.PublicKey) error { return nil })
+	netconf = New(auth, WithHostKeyPolicy(policy))
+	if c, ok := netconf.connector.(junosConnector); !ok || c.hostKeys == nil {
+		t.Errorf("New() didn't set the host key policy.")
+	}
+}
+
+func TestNewGeneric(t *testing.T) {
+	auth := &credentials.Config{}
+	policy := hostKeyPolicyFunc(func(string, ssh.PublicKey) error { return nil })
+	netconf := NewGeneric(auth, WithHostKeyPolicy(policy))
+	if c, ok := netconf.connector.(genericConnector); !ok || c.hostKeys == nil {
+		t.Errorf("NewGeneric() didn't return the expected struct.")
+	}
+
+	netconf = NewGeneric(auth, WithPool(PoolConfig{IdleTimeout: time.Minute}))
+	defer netconf.Close()
+	p, ok := netconf.connector.(*poolConnector)
+	if !ok {
+		t.Fatalf("NewGeneric() didn't return a pooled client.")
+	}
+	if _, ok := p.connector.(genericConnector); !ok {
+		t.Errorf("NewGeneric() didn't pool generic sessions.")
+	}
+}
+
+func TestNewPooled(t *testing.T) {
+	auth := &credentials.Config{}
+	netconf := NewPooled(auth, PoolConfig{IdleTimeout: time.Minute})
+	defer netconf.Close()
+	if netconf.auth != auth {
+		t.Errorf("NewPooled() didn't return the expected struct.")
+	}
+	if _, ok := netconf.connector.(*poolConnector); !ok {
+		t.Errorf("NewPooled() didn't return a pooled client.")
+	}
+
+	// Closing a non-pooled client is a no-op.
+	New(auth).Close()
 }
 
 func TestClient_GetConfigHash(t *testing.T) {
 	mockConnector := &mockConnector{}
 	netconf := &Client{
-		auth:      &junos.AuthMethod{},
+		auth:      &credentials.Config{},
 		connector: mockConnector,
 	}
 
-	res, err := netconf.GetConfig("test")
+	res, err := netconf.GetConfig(context.Background(), "test")
 	if err != nil {
 		t.Errorf("GetConfig(): expected nil, got %v", err)
 	}
@@ -68,7 +127,7 @@ func TestClient_GetConfigHash(t *testing.T) {
 
 	// Let the connector fail.
 	mockConnector.mustFail = true
-	_, err = netconf.GetConfig("test")
+	_, err = netconf.GetConfig(context.Background(), "test")
 	if err == nil {
 		t.Errorf("GetConfig(): expected err, got nil.")
 	}
@@ -76,9 +135,33 @@ func TestClient_GetConfigHash(t *testing.T) {
 
 	// Let connection.GetConfig() fail.
 	mockConnector.mustFailConn = true
-	_, err = netconf.GetConfig("test")
+	_, err = netconf.GetConfig(context.Background(), "test")
 	if err == nil {
 		t.Errorf("GetConfig(): expected err, got nil.")
 	}
 
 }
+
+func TestClient_Exec(t *testing.T) {
+	mockConnector := &mockConnector{}
+	netconf := &Client{
+		auth:      &credentials.Config{},
+		connector: mockConnector,
+	}
+
+	res, err := netconf.Exec(context.Background(), "test", "<get-alarm-information/>")
+	if err != nil || res != "<reply><get-alarm-information/></reply>" {
+		t.Errorf("Exec() = %q, %v", res, err)
+	}
+
+	mockConnector.mustFail = true
+	if _, err := netconf.Exec(context.Background(), "test", "<rpc/>"); err == nil {
+		t.Errorf("Exec(): expected err, got nil.")
+	}
+	mockConnector.mustFail = false
+
+	mockConnector.mustFailConn = true
+	if _, err := netconf.Exec(context.Background(), "test", "<rpc/>"); err == nil {
+		t.Errorf("Exec(): expected err, got nil.")
+	}
+}
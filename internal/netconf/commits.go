@@ -0,0 +1,62 @@
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rpcCommitInformation lists the most recent commits on a JunOS switch.
+const rpcCommitInformation = "<get-commit-information/>"
+
+// Commit is an entry of the commit history of a JunOS switch.
+type Commit struct {
+	Sequence int       `json:"sequence"`
+	User     string    `json:"user"`
+	Client   string    `json:"client"`
+	Time     time.Time `json:"time"`
+	Comment  string    `json:"comment,omitempty"`
+}
+
+// commitInformation is the reply to <get-commit-information>.
+type commitInformation struct {
+	History []struct {
+		Sequence string `xml:"sequence-number"`
+		User     string `xml:"user"`
+		Client   string `xml:"client"`
+		DateTime struct {
+			Seconds int64 `xml:"seconds,attr"`
+		} `xml:"date-time"`
+		Log string `xml:"log"`
+	} `xml:"commit-history"`
+}
+
+// Commits returns the commit history of a JunOS switch, most recent first.
+func (c Client) Commits(ctx context.Context, hostname string) ([]Commit, error) {
+	reply, err := c.Exec(ctx, hostname, rpcCommitInformation)
+	if err != nil {
+		return nil, err
+	}
+	return parseCommits(reply)
+}
+
+func parseCommits(reply string) ([]Commit, error) {
+	var info commitInformation
+	if err := xml.Unmarshal([]byte(reply), &info); err != nil {
+		return nil, err
+	}
+	commits := make([]Commit, 0, len(info.History))
+	for _, h := range info.History {
+		seq, _ := strconv.Atoi(strings.TrimSpace(h.Sequence))
+		commits = append(commits, Commit{
+			Sequence: seq,
+			User:     strings.TrimSpace(h.User),
+			Client:   strings.TrimSpace(h.Client),
+			Time:     time.Unix(h.DateTime.Seconds, 0).UTC(),
+			Comment:  strings.TrimSpace(h.Log),
+		})
+	}
+	return commits, nil
+}
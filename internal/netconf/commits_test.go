@@ -0,0 +1,63 @@
+package netconf
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+const commitReply = `<commit-information xmlns="http://xml.juniper.net/junos/18.3R3/junos">
+<commit-history>
+<sequence-number>0</sequence-number>
+<user>alice</user>
+<client>cli</client>
+<date-time junos:seconds="1600086400">2020-09-14 12:26:40 UTC</date-time>
+<log>
+fix uplink
+</log>
+</commit-history>
+<commit-history>
+<sequence-number>1</sequence-number>
+<user>root</user>
+<client>netconf</client>
+<date-time junos:seconds="1600000000">2020-09-13 12:26:40 UTC</date-time>
+</commit-history>
+</commit-information>`
+
+func Test_parseCommits(t *testing.T) {
+	got, err := parseCommits(commitReply)
+	if err != nil {
+		t.Fatalf("parseCommits() returned err: %v", err)
+	}
+	want := []Commit{
+		{Sequence: 0, User: "alice", Client: "cli",
+			Time: time.Unix(1600086400, 0).UTC(), Comment: "fix uplink"},
+		{Sequence: 1, User: "root", Client: "netconf",
+			Time: time.Unix(1600000000, 0).UTC()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCommits() = %+v, want %+v", got, want)
+	}
+
+	if _, err := parseCommits("<commit-information>"); err == nil {
+		t.Errorf("parseCommits(): expected err, got nil.")
+	}
+}
+
+func TestClient_Commits(t *testing.T) {
+	connector := newScriptedConnector()
+	connector.replies[rpcCommitInformation] = commitReply
+	c := Client{auth: &credentials.Config{}, connector: connector}
+	commits, err := c.Commits(context.Background(), "s1-abc01")
+	if err != nil || len(commits) != 2 {
+		t.Errorf("Commits() = %v, %v", commits, err)
+	}
+
+	c.connector = mockConnector{mustFailConn: true}
+	if _, err := c.Commits(context.Background(), "s1-abc01"); err == nil {
+		t.Errorf("Commits(): expected err, got nil.")
+	}
+}
@@ -1,48 +1,127 @@
 package netconf
 
 import (
-	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
+)
 
-	"github.com/kylelemons/godebug/diff"
+// Kinds of mismatches between two configurations.
+const (
+	// Missing statements are in the expected configuration only.
+	Missing = "missing"
+	// Unexpected statements are in the actual configuration only.
+	Unexpected = "unexpected"
+	// Reordered statements are present in both configurations, but their
+	// relative order is different and meaningful to JunOS.
+	Reordered = "reordered"
 )
 
-// Compare cleans up two switch configuration files and returns true if they
-// are the same.
-func Compare(c1, c2 string) bool {
-	c1 = cleanConfig(c1)
-	c2 = cleanConfig(c2)
+// Mismatch is a difference between the expected and the actual configuration.
+// Path is the hierarchy of statements containing the mismatched one, e.g.
+// "interfaces xe-0/0/1 unit 0 family inet".
+type Mismatch struct {
+	Path      string `json:"path"`
+	Statement string `json:"statement"`
+	Kind      string `json:"kind"`
+}
+
+// Compare cleans up two JunOS switch configuration files according to the
+// rules and returns true if they are semantically the same.
+func Compare(c1, c2 string, rules *Rules) bool {
+	return Text.Compare(c1, c2, rules)
+}
+
+// Mismatches compares two configuration trees and returns the differences
+// between them. Statements are matched by their words, ignoring their order
+// unless it is meaningful to JunOS. When a container is missing or
+// unexpected, its children are not reported individually.
+func Mismatches(expected, actual *Node) []Mismatch {
+	res := []Mismatch{}
+	return compareChildren(nil, expected, actual, res)
+}
+
+func compareChildren(path []string, expected, actual *Node, res []Mismatch) []Mismatch {
+	p := strings.Join(path, " ")
+	expectedByKey := childrenByKey(expected)
+	actualByKey := childrenByKey(actual)
+
+	// Repeated statements, e.g. a term defined twice in a hand-edited
+	// file, are matched by their occurrence: the n-th expected one with
+	// the n-th actual one.
+	seen := map[string]int{}
+	for _, e := range expected.Children {
+		k := e.key()
+		i := seen[k]
+		seen[k]++
+		if i >= len(actualByKey[k]) {
+			res = append(res, Mismatch{Path: p, Statement: e.Statement(),
+				Kind: Missing})
+			continue
+		}
+		if e.Container {
+			res = compareChildren(
+				append(path[:len(path):len(path)], e.Statement()), e,
+				actualByKey[k][i], res)
+		}
+	}
+	seen = map[string]int{}
+	for _, a := range actual.Children {
+		k := a.key()
+		i := seen[k]
+		seen[k]++
+		if i >= len(expectedByKey[k]) {
+			res = append(res, Mismatch{Path: p, Statement: a.Statement(),
+				Kind: Unexpected})
+		}
+	}
+
+	// Ordered statements present on both sides must appear in the same
+	// relative order. Both lists contain the same occurrences, so they
+	// have the same length.
+	expectedOrder := orderedChildren(expected, actualByKey)
+	actualOrder := orderedChildren(actual, expectedByKey)
+	for i := 0; i < len(expectedOrder) && i < len(actualOrder); i++ {
+		if expectedOrder[i].id != actualOrder[i].id {
+			res = append(res, Mismatch{Path: p,
+				Statement: expectedOrder[i].node.Statement(),
+				Kind:      Reordered})
+			break
+		}
+	}
+
+	return res
+}
+
+// childrenByKey returns the children of n by key. Repeated statements have
+// several entries, in their original order.
+func childrenByKey(n *Node) map[string][]*Node {
+	children := map[string][]*Node{}
+	for _, c := range n.Children {
+		children[c.key()] = append(children[c.key()], c)
+	}
+	return children
+}
 
-	fmt.Println(diff.Diff(c1, c2))
-	return c1 == c2
+// orderedChild is an ordered statement, identified by its key and its
+// occurrence among the siblings with the same key.
+type orderedChild struct {
+	id   string
+	node *Node
 }
 
-// cleanConfig cleans a JunOS switch configuration file to make it comparable.
-// It removes any comment lines at the beginning, trims whitespace and the
-// beginning/end, replaces any encrypted password with "dummy" and returns
-// what is left.
-func cleanConfig(config string) string {
-	// Remove version string. This is necessary since the switch will always
-	// report the current version number at the beginning of the config file,
-	// but the version is not part of the config file itself.
-	re := regexp.MustCompile("version.+")
-	config = re.ReplaceAllString(config, "")
-
-	// Remove single-line comments (lines starting with '#').
-	re = regexp.MustCompile(`(?m)^#.*\n*`)
-	config = strings.TrimSpace(re.ReplaceAllString(config, ""))
-
-	// Remove C-style multiline comments (/* ... */).
-	re = regexp.MustCompile(`(?s)\/\*.*?\*\/`)
-	config = strings.TrimSpace(re.ReplaceAllString(config, ""))
-
-	// Replace all password fields with "dummy".
-	// TODO: once we start pre-configuring the switch with a random password,
-	// we can remove this step so that the actual passwords are compared.
-	re = regexp.MustCompile("encrypted-password.+")
-	config = re.ReplaceAllString(config, "encrypted-password \"dummy\";")
-
-	// Trim the result.
-	return strings.TrimSpace(config)
+// orderedChildren returns the ordered children of n that are matched by a
+// child of other, in their original order.
+func orderedChildren(n *Node, other map[string][]*Node) []orderedChild {
+	var children []orderedChild
+	seen := map[string]int{}
+	for _, c := range n.Children {
+		k := c.key()
+		i := seen[k]
+		seen[k]++
+		if c.ordered() && i < len(other[k]) {
+			children = append(children, orderedChild{
+				id: k + "#" + strconv.Itoa(i), node: c})
+		}
+	}
+	return children
 }
@@ -2,6 +2,7 @@ package netconf
 
 import (
 	"io/ioutil"
+	"reflect"
 	"testing"
 
 	"github.com/m-lab/go/rtx"
@@ -47,8 +48,8 @@ func TestCompare(t *testing.T) {
 		},
 		{
 			name: "c-style-comments",
-			c1:   "text/* comment */text\n",
-			c2:   "texttext",
+			c1:   "text /* comment */ text\n",
+			c2:   "text text",
 			want: true,
 		},
 		{
@@ -90,9 +91,131 @@ func TestCompare(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := Compare(tt.c1, tt.c2); got != tt.want {
+			if got := Compare(tt.c1, tt.c2, nil); got != tt.want {
 				t.Errorf("Compare() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMismatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		want     []Mismatch
+	}{
+		{
+			name:     "reordered-statements",
+			expected: "system { host-name s1; name-server { 8.8.8.8; 8.8.4.4; } }",
+			actual:   "system {\nname-server { 8.8.4.4; 8.8.8.8; }\nhost-name s1;\n}",
+			want:     []Mismatch{},
+		},
+		{
+			name: "changed-leaf",
+			expected: `interfaces {
+    xe-0/0/1 {
+        unit 0 {
+            family inet {
+                address 192.168.0.1/26;
+            }
+        }
+    }
+}`,
+			actual: `interfaces {
+    xe-0/0/1 {
+        unit 0 {
+            family inet {
+                address 192.168.0.2/26;
+            }
+        }
+    }
+}`,
+			want: []Mismatch{
+				{
+					Path:      "interfaces xe-0/0/1 unit 0 family inet",
+					Statement: "address 192.168.0.1/26",
+					Kind:      Missing,
+				},
+				{
+					Path:      "interfaces xe-0/0/1 unit 0 family inet",
+					Statement: "address 192.168.0.2/26",
+					Kind:      Unexpected,
+				},
+			},
+		},
+		{
+			name:     "missing-container",
+			expected: "snmp { location x; community y; }\nsystem { ntp; }",
+			actual:   "system { ntp; }",
+			want: []Mismatch{
+				{Path: "", Statement: "snmp", Kind: Missing},
+			},
+		},
+		{
+			name:     "leaf-vs-container",
+			expected: "system { ntp; }",
+			actual:   "system { ntp { server 1.2.3.4; } }",
+			want: []Mismatch{
+				{Path: "system", Statement: "ntp", Kind: Missing},
+				{Path: "system", Statement: "ntp", Kind: Unexpected},
+			},
+		},
+		{
+			name:     "reordered-terms",
+			expected: "firewall { filter f { term a { then accept; } term b { then discard; } } }",
+			actual:   "firewall { filter f { term b { then discard; } term a { then accept; } } }",
+			want: []Mismatch{
+				{Path: "firewall filter f", Statement: "term a", Kind: Reordered},
+			},
+		},
+		{
+			name:     "repeated-ordered-key",
+			expected: "firewall { filter f { term a { then accept; } term a { then accept; } term b { then discard; } } }",
+			actual:   "firewall { filter f { term a { then accept; } term b { then discard; } } }",
+			want: []Mismatch{
+				{Path: "firewall filter f", Statement: "term a", Kind: Missing},
+			},
+		},
+		{
+			name:     "repeated-ordered-key-reordered",
+			expected: "firewall { filter f { term a { then accept; } term b { then discard; } term a { then reject; } } }",
+			actual:   "firewall { filter f { term a { then accept; } term a { then reject; } term b { then discard; } } }",
+			want: []Mismatch{
+				{Path: "firewall filter f", Statement: "term b", Kind: Reordered},
+			},
+		},
+		{
+			name:     "reordered-list",
+			expected: "permissions [ view view-configuration ];",
+			actual:   "permissions [ view-configuration view ];",
+			want:     []Mismatch{},
+		},
+		{
+			name:     "reordered-import-chain",
+			expected: "protocols { bgp { group g { import [ deny-bogons accept-all ]; } } }",
+			actual:   "protocols { bgp { group g { import [ accept-all deny-bogons ]; } } }",
+			want: []Mismatch{
+				{Path: "protocols bgp group g", Statement: "import [ deny-bogons accept-all ]", Kind: Missing},
+				{Path: "protocols bgp group g", Statement: "import [ accept-all deny-bogons ]", Kind: Unexpected},
+			},
+		},
+		{
+			name:     "reordered-export-chain",
+			expected: "routing-options { forwarding-table { export [ lb static ]; } }",
+			actual:   "routing-options { forwarding-table { export [ static lb ]; } }",
+			want: []Mismatch{
+				{Path: "routing-options forwarding-table", Statement: "export [ lb static ]", Kind: Missing},
+				{Path: "routing-options forwarding-table", Statement: "export [ static lb ]", Kind: Unexpected},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Mismatches(Text.canonical(tt.expected, nil), Text.canonical(tt.actual, nil))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mismatches() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
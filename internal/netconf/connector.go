@@ -1,54 +1,211 @@
 package netconf
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"net"
 	"time"
 
 	"github.com/Juniper/go-netconf/netconf"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
 	"github.com/scottdware/go-junos"
 	"golang.org/x/crypto/ssh"
 )
 
-const defaultTimeout = 15 * time.Second
+const (
+	// defaultTimeout is the maximum time allowed to establish a session,
+	// unless the context has an earlier deadline.
+	defaultTimeout = 15 * time.Second
 
-var newSession = junos.NewSessionWithConfig
+	// netconfPort is the default NETCONF over SSH port.
+	netconfPort = "830"
+
+	// rpcPing is a lightweight RPC used to check whether a session is
+	// still usable.
+	rpcPing = "<get-system-uptime-information/>"
+)
+
+var newSession = dialSession
 
 // These types provide an abstraction for the underlying connector and
 // connection to a NETCONF-enabled device, so that they can be unit tested.
 
 type connection interface {
-	GetConfig(string, ...string) (string, error)
+	GetConfig(context.Context, string, ...string) (string, error)
+	Exec(context.Context, string) (string, error)
+	Ping(context.Context) error
 	Close()
 }
 
 type connector interface {
-	NewSession(string, *junos.AuthMethod) (connection, error)
+	NewSession(context.Context, string, credentials.Provider) (connection, error)
 }
 
-type junosConnector struct{}
+// HostKeyPolicy verifies the SSH host key presented by a switch.
+type HostKeyPolicy interface {
+	// Check returns an error if the key must not be trusted for host.
+	Check(host string, key ssh.PublicKey) error
+}
+
+type junosConnector struct {
+	// hostKeys verifies the switches' host keys. If nil, any key is
+	// accepted.
+	hostKeys HostKeyPolicy
+}
+
+func (c junosConnector) NewSession(ctx context.Context, host string, auth credentials.Provider) (connection, error) {
+	config, err := sshConfig(ctx, host, auth, c.hostKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// This matches the only two key exchange algorithm we use on our switches.
+	config.Config.KeyExchanges = []string{"curve25519-sha256@libssh.org",
+		"diffie-hellman-group-exchange-sha256"}
 
-func (junosConnector) NewSession(host string, auth *junos.AuthMethod) (connection, error) {
-	var config *ssh.ClientConfig
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	jnpr, err := newSession(ctx, host, config)
+	if err != nil {
+		return nil, err
+	}
+	return withGauge(junosSession{jnpr}, sessionsOpen), nil
+}
 
-	if len(auth.PrivateKey) == 0 {
-		return nil, errors.New("no private key specified")
+// sshConfig returns the SSH client configuration to connect to host with the
+// credentials returned by the provider. If hostKeys is nil, any host key is
+// accepted.
+func sshConfig(ctx context.Context, host string, auth credentials.Provider, hostKeys HostKeyPolicy) (*ssh.ClientConfig, error) {
+	if auth == nil {
+		return nil, credentials.ErrNoMethod
 	}
-	config, err := netconf.SSHConfigPubKeyFile(auth.Username, auth.PrivateKey, auth.Passphrase)
+	creds, err := auth.Credentials(ctx, host)
 	if err != nil {
 		return nil, err
 	}
+	config := &ssh.ClientConfig{
+		User: creds.Username,
+		Auth: creds.Methods,
+	}
 
 	config.Timeout = defaultTimeout
 
-	// Every time the switch is rebooted, a new host key is generated.
-	// Since we don't have any mean to track host key changes at the moment,
-	// and we don't know which key is the "correct" one, we do not check the
-	// key here.
+	// Every time the switch is rebooted, a new host key is generated, so
+	// keys are only verified if a policy has been configured. The policy is
+	// given the switch's hostname rather than the remote address, which is
+	// what the SSH library would pass.
 	config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	if hostKeys != nil {
+		config.HostKeyCallback = func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if err := hostKeys.Check(host, key); err != nil {
+				return fmt.Errorf("%w: %w", ErrHostKeyRejected, err)
+			}
+			return nil
+		}
+	}
+	return config, nil
+}
 
-	// This matches the only two key exchange algorithm we use on our switches.
-	config.Config.KeyExchanges = []string{"curve25519-sha256@libssh.org",
-		"diffie-hellman-group-exchange-sha256"}
+// dialSession connects to the NETCONF port of the specified host and
+// establishes a session. Since the SSH handshake does not support contexts,
+// the connection is closed if the context is done before the session is
+// established.
+func dialSession(ctx context.Context, host string, config *ssh.ClientConfig) (*junos.Junos, error) {
+	var jnpr *junos.Junos
+	err := dialNetconf(ctx, host, func(conn net.Conn) error {
+		s, err := newSSHSession(conn, config)
+		if err != nil {
+			return err
+		}
+		if jnpr, err = junos.NewSessionFromNetconf(s); err != nil {
+			s.Close()
+		}
+		return err
+	})
+	return jnpr, err
+}
+
+// dialNetconf connects to the NETCONF port of the specified host and calls
+// establish to set up a session over the connection. The connection is
+// closed if establish fails or the context is done before it returns.
+func dialNetconf(ctx context.Context, host string, establish func(net.Conn) error) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, netconfPort))
+	if err != nil {
+		return err
+	}
+
+	err = withContext(ctx, func() { conn.Close() }, func() error {
+		return establish(conn)
+	})
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// withContext calls f and returns its error. If the context is done before
+// f returns, abort is called to interrupt it and the context's error is
+// returned instead.
+func withContext(ctx context.Context, abort func(), f func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			abort()
+		case <-done:
+		}
+	}()
+
+	err := f()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// junosSession is a connection to a JunOS device. If the context passed to
+// any of its methods is done before the call completes, the session is
+// closed.
+type junosSession struct {
+	*junos.Junos
+}
+
+// GetConfig reads the configuration in the requested format.
+func (s junosSession) GetConfig(ctx context.Context, format string, section ...string) (string, error) {
+	var config string
+	err := withContext(ctx, s.Close, func() error {
+		var err error
+		config, err = s.Junos.GetConfig(format, section...)
+		return err
+	})
+	return config, err
+}
+
+// Exec runs a raw RPC and returns the content of the reply.
+func (s junosSession) Exec(ctx context.Context, rpc string) (string, error) {
+	return execRPC(ctx, s.Session, rpc)
+}
+
+// Ping sends a lightweight RPC to check whether the session is still usable.
+func (s junosSession) Ping(ctx context.Context) error {
+	_, err := execRPC(ctx, s.Session, rpcPing)
+	return err
+}
 
-	return newSession(host, config)
+// execRPC runs a raw RPC on the session and returns the content of the
+// reply. If the context is done before the reply is received, the session is
+// closed.
+func execRPC(ctx context.Context, s *netconf.Session, rpc string) (string, error) {
+	var data string
+	err := withContext(ctx, func() { s.Close() }, func() error {
+		reply, err := s.Exec(netconf.RawMethod(rpc))
+		if err != nil {
+			return err
+		}
+		data = reply.Data
+		return nil
+	})
+	return data, err
 }
@@ -1,38 +1,107 @@
 package netconf
 
 import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
 	"github.com/scottdware/go-junos"
 	"golang.org/x/crypto/ssh"
 )
 
+// fakeTransport is a NETCONF transport replying to every request with the
+// result of the reply function. If hang is true, Receive blocks until the
+// transport is closed.
+type fakeTransport struct {
+	mu       sync.Mutex
+	reply    func(request string) string
+	hang     bool
+	request  string
+	closed   chan struct{}
+	isClosed bool
+}
+
+func newFakeTransport(reply func(string) string) *fakeTransport {
+	return &fakeTransport{reply: reply, closed: make(chan struct{})}
+}
+
+func (t *fakeTransport) Send(b []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.request = string(b)
+	return nil
+}
+
+func (t *fakeTransport) Receive() ([]byte, error) {
+	if t.hang {
+		<-t.closed
+		return nil, errors.New("transport closed")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return []byte(t.reply(t.request)), nil
+}
+
+func (t *fakeTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.isClosed {
+		t.isClosed = true
+		close(t.closed)
+	}
+	return nil
+}
+
+func (t *fakeTransport) ReceiveHello() (*netconf.HelloMessage, error) {
+	return &netconf.HelloMessage{}, nil
+}
+
+func (t *fakeTransport) SendHello(*netconf.HelloMessage) error {
+	return nil
+}
+
+// fakeSession returns a junosSession using the provided transport.
+func fakeSession(t *fakeTransport) junosSession {
+	return junosSession{&junos.Junos{Session: &netconf.Session{Transport: t}}}
+}
+
 func Test_junosConnector_NewSession(t *testing.T) {
-	// Let NewSession fail due to an empty AuthMethod.
+	ctx := context.Background()
+
+	// Let NewSession fail due to empty credentials.
 	j := &junosConnector{}
-	_, err := j.NewSession("", &junos.AuthMethod{})
+	_, err := j.NewSession(ctx, "", &credentials.Config{})
 	if err == nil {
 		t.Errorf("NewSession(): expected err, got nil.")
 	}
 
 	// Attempt to use a non-existing key file.
-	auth := &junos.AuthMethod{
-		PrivateKey: "thiswillfail",
-		Username:   "thiswillfail",
+	auth := &credentials.Config{
+		Username: "thiswillfail",
+		Keys:     []credentials.Key{{Private: credentials.File("thiswillfail")}},
 	}
 
-	_, err = j.NewSession("", auth)
+	_, err = j.NewSession(ctx, "", auth)
 	if err == nil {
 		t.Errorf("NewSession() expected err, got nil.")
 	}
 
 	// This should succeed.
-	auth.PrivateKey = "testdata/dummy.key"
+	auth.Keys[0].Private = credentials.File("testdata/dummy.key")
 	oldNewSession := newSession
-	newSession = func(host string, clientConfig *ssh.ClientConfig) (*junos.Junos, error) {
+	newSession = func(ctx context.Context, host string, clientConfig *ssh.ClientConfig) (*junos.Junos, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("NewSession() did not set a deadline")
+		}
 		return &junos.Junos{}, nil
 	}
-	s, err := j.NewSession("", auth)
+	s, err := j.NewSession(ctx, "", auth)
 	if err != nil {
 		t.Errorf("NewSession() expected err, got nil.")
 	}
@@ -41,3 +110,126 @@ func Test_junosConnector_NewSession(t *testing.T) {
 	}
 	newSession = oldNewSession
 }
+
+// dummyCredentials returns credentials authenticating with the test key.
+func dummyCredentials() *credentials.Config {
+	return &credentials.Config{
+		Keys: []credentials.Key{{Private: credentials.File("testdata/dummy.key")}},
+	}
+}
+
+type hostKeyPolicyFunc func(string, ssh.PublicKey) error
+
+func (f hostKeyPolicyFunc) Check(host string, key ssh.PublicKey) error {
+	return f(host, key)
+}
+
+func Test_junosConnector_hostKeyPolicy(t *testing.T) {
+	wantErr := errors.New("untrusted key")
+	var checkedHost string
+	j := junosConnector{
+		hostKeys: hostKeyPolicyFunc(func(host string, _ ssh.PublicKey) error {
+			checkedHost = host
+			return wantErr
+		}),
+	}
+
+	oldNewSession := newSession
+	defer func() { newSession = oldNewSession }()
+	newSession = func(ctx context.Context, host string, config *ssh.ClientConfig) (*junos.Junos, error) {
+		// The policy must be called with the switch's hostname, not
+		// with the address passed by the SSH library.
+		addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 830}
+		return nil, config.HostKeyCallback("127.0.0.1:830", addr, nil)
+	}
+
+	auth := dummyCredentials()
+	_, err := j.NewSession(context.Background(), "s1-abc01", auth)
+	if !errors.Is(err, wantErr) || Reason(classify(err, ReasonUnknown)) != ReasonHostKey {
+		t.Errorf("NewSession() error = %v, want %v", err, wantErr)
+	}
+	if checkedHost != "s1-abc01" {
+		t.Errorf("host key checked for %q, want s1-abc01", checkedHost)
+	}
+}
+
+func Test_dialSession(t *testing.T) {
+	// Connecting to a closed port fails.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	host, _, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	if _, err := dialSession(context.Background(), host,
+		&ssh.ClientConfig{}); err == nil {
+		t.Errorf("dialSession(): expected err, got nil.")
+	}
+
+	// A handshake that never completes is aborted when the context expires.
+	ln, err = net.Listen("tcp", net.JoinHostPort(host, netconfPort))
+	if err != nil {
+		t.Skipf("cannot listen on the NETCONF port: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	_, err = dialSession(ctx, host, &ssh.ClientConfig{
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("dialSession() returned %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+}
+
+func Test_junosSession(t *testing.T) {
+	transport := newFakeTransport(func(req string) string {
+		if strings.Contains(req, "get-configuration") {
+			return "<rpc-reply><configuration-text>" +
+				"system { host-name s1-abc01; }" +
+				"</configuration-text></rpc-reply>"
+		}
+		return "<rpc-reply><system-uptime-information/></rpc-reply>"
+	})
+	s := fakeSession(transport)
+
+	config, err := s.GetConfig(context.Background(), "text")
+	if err != nil || !strings.Contains(config, "host-name s1-abc01") {
+		t.Errorf("GetConfig() = %q, %v", config, err)
+	}
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() returned err: %v", err)
+	}
+	reply, err := s.Exec(context.Background(), rpcPing)
+	if err != nil || reply != "<system-uptime-information/>" {
+		t.Errorf("Exec() = %q, %v", reply, err)
+	}
+
+	// A canceled context closes the session and aborts the call.
+	transport.hang = true
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetConfig(ctx, "text"); err != context.DeadlineExceeded {
+		t.Errorf("GetConfig() returned %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+	if !transport.isClosed {
+		t.Errorf("GetConfig() did not close the session")
+	}
+	if err := s.Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Ping() returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
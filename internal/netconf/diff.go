@@ -0,0 +1,218 @@
+package netconf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+// diffContext is the number of unchanged lines shown around each change in
+// the unified diff.
+const diffContext = 3
+
+// Line is a single line of a configuration file, with its 1-based line number.
+type Line struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+// Hunk is a contiguous set of changes between the expected and the actual
+// configuration. Removed lines are present in the expected configuration but
+// missing on the switch, added lines are present on the switch only. Line
+// numbers refer to the canonical configurations.
+type Hunk struct {
+	ExpectedStart int    `json:"expected_start"`
+	ActualStart   int    `json:"actual_start"`
+	Removed       []Line `json:"removed,omitempty"`
+	Added         []Line `json:"added,omitempty"`
+}
+
+// Diff is the difference between the expected and the actual configuration
+// of a switch, both as a line-by-line diff of the canonical configurations
+// and as a list of mismatched statements.
+type Diff struct {
+	Unified    string     `json:"unified"`
+	Hunks      []Hunk     `json:"hunks"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// Empty returns true if there are no differences.
+func (d *Diff) Empty() bool {
+	return len(d.Hunks) == 0 && len(d.Mismatches) == 0
+}
+
+// edit is a single line of a diff. a and b are the line numbers in the
+// expected and actual configuration, respectively, or zero if the line does
+// not exist there.
+type edit struct {
+	op   byte
+	text string
+	a, b int
+}
+
+// Lines returns the number of lines that differ.
+func (d *Diff) Lines() int {
+	n := 0
+	for _, h := range d.Hunks {
+		n += len(h.Removed) + len(h.Added)
+	}
+	return n
+}
+
+// ComputeDiff cleans up the expected and actual JunOS switch configuration
+// files according to the rules and returns the differences between them.
+func ComputeDiff(expected, actual string, rules *Rules) *Diff {
+	return Text.Diff(expected, actual, rules)
+}
+
+// ComputeSectionDiff cleans up the expected and actual JunOS switch
+// configuration files according to the rules and returns the differences in
+// the specified top-level section (e.g. "interfaces"). A section missing from
+// either file is considered empty.
+func ComputeSectionDiff(expected, actual, section string, rules *Rules) *Diff {
+	return Text.SectionDiff(expected, actual, section, rules)
+}
+
+// sectionOf returns a new root containing only the specified top-level
+// section of n.
+func sectionOf(n *Node, section string) *Node {
+	root := &Node{Container: true}
+	if c := n.Child(section); c != nil {
+		root.Children = []*Node{c}
+	}
+	return root
+}
+
+// diffTrees returns the differences between two canonical configurations.
+func diffTrees(e, a *Node) *Diff {
+	edits := computeEdits(
+		strings.Split(e.String(), "\n"),
+		strings.Split(a.String(), "\n"))
+
+	return &Diff{
+		Unified:    unified(edits),
+		Hunks:      hunks(edits),
+		Mismatches: Mismatches(e, a),
+	}
+}
+
+// computeEdits returns the line-by-line edits needed to turn a into b.
+func computeEdits(a, b []string) []edit {
+	var edits []edit
+	i, j := 1, 1
+	for _, c := range diff.DiffChunks(a, b) {
+		for _, line := range c.Deleted {
+			edits = append(edits, edit{op: '-', text: line, a: i})
+			i++
+		}
+		for _, line := range c.Added {
+			edits = append(edits, edit{op: '+', text: line, b: j})
+			j++
+		}
+		for _, line := range c.Equal {
+			edits = append(edits, edit{op: ' ', text: line, a: i, b: j})
+			i++
+			j++
+		}
+	}
+	return edits
+}
+
+// hunks groups consecutive changed lines into hunks.
+func hunks(edits []edit) []Hunk {
+	res := []Hunk{}
+	var cur *Hunk
+	a, b := 1, 1
+	for _, e := range edits {
+		if e.op == ' ' {
+			cur = nil
+			a, b = e.a+1, e.b+1
+			continue
+		}
+		if cur == nil {
+			res = append(res, Hunk{ExpectedStart: a, ActualStart: b})
+			cur = &res[len(res)-1]
+		}
+		if e.op == '-' {
+			cur.Removed = append(cur.Removed, Line{Number: e.a, Text: e.text})
+			a = e.a + 1
+		} else {
+			cur.Added = append(cur.Added, Line{Number: e.b, Text: e.text})
+			b = e.b + 1
+		}
+	}
+	return res
+}
+
+// unified renders the edits as a unified diff with diffContext lines of
+// context around each change. It returns an empty string if there are no
+// changes.
+func unified(edits []edit) string {
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "--- expected")
+	fmt.Fprintln(buf, "+++ actual")
+	changed := false
+
+	for start := 0; start < len(edits); {
+		// Find the next change.
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		changed = true
+
+		// Extend the block until there are more than 2*diffContext
+		// unchanged lines between two changes.
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].op != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(edits) {
+			to = len(edits)
+		}
+		writeBlock(buf, edits[from:to])
+		start = end
+	}
+
+	if !changed {
+		return ""
+	}
+	return buf.String()
+}
+
+// writeBlock writes a single @@ block of a unified diff.
+func writeBlock(buf *bytes.Buffer, block []edit) {
+	var aStart, bStart, aLen, bLen int
+	for _, e := range block {
+		if e.a != 0 {
+			if aStart == 0 {
+				aStart = e.a
+			}
+			aLen++
+		}
+		if e.b != 0 {
+			if bStart == 0 {
+				bStart = e.b
+			}
+			bLen++
+		}
+	}
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+	for _, e := range block {
+		fmt.Fprintf(buf, "%c%s\n", e.op, e.text)
+	}
+}
@@ -0,0 +1,138 @@
+package netconf
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestComputeDiff(t *testing.T) {
+	abc01Conf, err := ioutil.ReadFile("testdata/abc01.conf")
+	rtx.Must(err, "Cannot read test data")
+	abc01BisConf, err := ioutil.ReadFile("testdata/abc01-bis.conf")
+	rtx.Must(err, "Cannot read test data")
+	abc02Conf, err := ioutil.ReadFile("testdata/abc02.conf")
+	rtx.Must(err, "Cannot read test data")
+
+	tests := []struct {
+		name     string
+		expected string
+		actual   string
+		unified  string
+		hunks    []Hunk
+	}{
+		{
+			name:     "no-differences",
+			expected: "a;\nb;\nc;",
+			actual:   "# comment\nc;\nb;\na;",
+			unified:  "",
+			hunks:    []Hunk{},
+		},
+		{
+			name:     "line-changed",
+			expected: "s {\n    a;\n    b;\n}",
+			actual:   "s {\n    a;\n    c;\n}",
+			unified: `--- expected
++++ actual
+@@ -1,4 +1,4 @@
+ s {
+     a;
+-    b;
++    c;
+ }
+`,
+			hunks: []Hunk{
+				{
+					ExpectedStart: 3,
+					ActualStart:   3,
+					Removed:       []Line{{Number: 3, Text: "    b;"}},
+					Added:         []Line{{Number: 3, Text: "    c;"}},
+				},
+			},
+		},
+		{
+			name:     "lines-added-and-removed",
+			expected: "1;\n2;\n3;\n4;\n5;\n6;\n7;\n8;\n9;",
+			actual:   "0;\n1;\n2;\n3;\n4;\n5;\n6;\n7;\n8;",
+			unified: `--- expected
++++ actual
+@@ -1,3 +1,4 @@
++0;
+ 1;
+ 2;
+ 3;
+@@ -6,4 +7,3 @@
+ 6;
+ 7;
+ 8;
+-9;
+`,
+			hunks: []Hunk{
+				{
+					ExpectedStart: 1,
+					ActualStart:   1,
+					Added:         []Line{{Number: 1, Text: "0;"}},
+				},
+				{
+					ExpectedStart: 9,
+					ActualStart:   10,
+					Removed:       []Line{{Number: 9, Text: "9;"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := ComputeDiff(tt.expected, tt.actual, nil)
+			if d.Unified != tt.unified {
+				t.Errorf("ComputeDiff() unified = \n%s\nwant\n%s", d.Unified,
+					tt.unified)
+			}
+			if !reflect.DeepEqual(d.Hunks, tt.hunks) {
+				t.Errorf("ComputeDiff() hunks = %+v, want %+v", d.Hunks, tt.hunks)
+			}
+			if d.Empty() != (len(tt.hunks) == 0) {
+				t.Errorf("Empty() = %v, want %v", d.Empty(), len(tt.hunks) == 0)
+			}
+		})
+	}
+
+	// The same configuration with different comments has no differences.
+	if d := ComputeDiff(string(abc01Conf), string(abc01BisConf), nil); !d.Empty() {
+		t.Errorf("ComputeDiff() returned unexpected differences:\n%s", d.Unified)
+	}
+
+	// Different configurations must be reported in the unified diff.
+	d := ComputeDiff(string(abc01Conf), string(abc02Conf), nil)
+	if d.Empty() || !strings.Contains(d.Unified, "@@") {
+		t.Errorf("ComputeDiff() did not find any differences")
+	}
+}
+
+func TestComputeSectionDiff(t *testing.T) {
+	expected := "system { host-name s1; }\ninterfaces { xe-0/0/1 { mtu 9216; } }"
+	actual := "system { host-name s2; }\ninterfaces { xe-0/0/1 { mtu 9216; } }"
+
+	if d := ComputeSectionDiff(expected, actual, "interfaces", nil); !d.Empty() {
+		t.Errorf("ComputeSectionDiff() returned unexpected differences:\n%s",
+			d.Unified)
+	}
+	if d := ComputeSectionDiff(expected, actual, "system", nil); d.Lines() != 2 {
+		t.Errorf("ComputeSectionDiff() returned %d lines, want 2", d.Lines())
+	}
+
+	// A section missing on the switch is reported as removed.
+	d := ComputeSectionDiff(expected, "", "interfaces", nil)
+	if len(d.Mismatches) != 1 || d.Mismatches[0].Kind != Missing {
+		t.Errorf("ComputeSectionDiff() returned %+v", d.Mismatches)
+	}
+
+	// A section missing on both sides has no differences.
+	if d := ComputeSectionDiff(expected, actual, "snmp", nil); !d.Empty() {
+		t.Errorf("ComputeSectionDiff() returned unexpected differences:\n%s",
+			d.Unified)
+	}
+}
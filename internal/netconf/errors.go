@@ -0,0 +1,110 @@
+package netconf
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/Juniper/go-netconf/netconf"
+)
+
+// Reasons why a call to a switch failed.
+const (
+	// ReasonDNS means the switch's hostname could not be resolved.
+	ReasonDNS = "dns_failure"
+	// ReasonTimeout means the switch did not respond in time.
+	ReasonTimeout = "timeout"
+	// ReasonConnection means the TCP connection could not be established
+	// or was interrupted, e.g. because it was refused.
+	ReasonConnection = "connection_failure"
+	// ReasonAuth means the switch refused the SSH credentials.
+	ReasonAuth = "auth_failure"
+	// ReasonKeyExchange means the client and the switch have no SSH
+	// key exchange algorithm, cipher or MAC in common.
+	ReasonKeyExchange = "kex_failure"
+	// ReasonHostKey means the switch's SSH host key was not trusted.
+	ReasonHostKey = "host_key_rejected"
+	// ReasonRPC means the session was established, but the switch returned
+	// an error for the RPC.
+	ReasonRPC = "rpc_error"
+	// ReasonUnknown is used for errors that cannot be classified.
+	ReasonUnknown = "unknown"
+)
+
+var (
+	// ErrAuth is wrapped by the errors returned when the switch refuses the
+	// SSH credentials.
+	ErrAuth = errors.New("SSH authentication failed")
+	// ErrKeyExchange is wrapped by the errors returned when the SSH key
+	// exchange with the switch fails, e.g. because they have no algorithm
+	// in common.
+	ErrKeyExchange = errors.New("SSH key exchange failed")
+	// ErrHostKeyRejected is wrapped by the errors returned when the
+	// HostKeyPolicy does not trust the switch's host key.
+	ErrHostKeyRejected = errors.New("host key rejected")
+)
+
+// Error is an error returned by a Client, with the reason of the failure.
+type Error struct {
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reason returns the reason of a failed call to a Client, or ReasonUnknown
+// if err was not returned by a Client.
+func Reason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return ReasonUnknown
+}
+
+// classify wraps err into an Error with the reason of the failure. Errors
+// that cannot be classified otherwise are given the fallback reason, which
+// depends on whether the session was already established.
+func classify(err error, fallback string) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Reason: reason(err, fallback), Err: err}
+}
+
+func reason(err error, fallback string) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var rpcErr *netconf.RPCError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ReasonTimeout
+	case errors.As(err, &dnsErr):
+		return ReasonDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonTimeout
+	case errors.As(err, &opErr):
+		return ReasonConnection
+	case errors.As(err, &rpcErr):
+		return ReasonRPC
+	case errors.Is(err, ErrHostKeyRejected):
+		return ReasonHostKey
+	case errors.Is(err, ErrAuth):
+		return ReasonAuth
+	case errors.Is(err, ErrKeyExchange):
+		return ReasonKeyExchange
+	}
+	return fallback
+}
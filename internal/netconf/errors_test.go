@@ -0,0 +1,133 @@
+package netconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+// timeoutError is a net.Error reporting a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_classify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		fallback string
+		want     string
+	}{
+		{
+			name: "dns",
+			err: &net.OpError{Op: "dial", Err: &net.DNSError{
+				Err: "no such host", Name: "s1-xyz01", IsNotFound: true}},
+			want: ReasonDNS,
+		},
+		{
+			name: "context-deadline",
+			err:  fmt.Errorf("dial: %w", context.DeadlineExceeded),
+			want: ReasonTimeout,
+		},
+		{
+			name: "net-timeout",
+			err:  &net.OpError{Op: "dial", Err: timeoutError{}},
+			want: ReasonTimeout,
+		},
+		{
+			name: "connection-refused",
+			err:  &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED},
+			want: ReasonConnection,
+		},
+		{
+			name: "auth",
+			err: &handshakeError{msg: "ssh: handshake failed: ssh: unable to authenticate",
+				cause: ErrAuth},
+			want: ReasonAuth,
+		},
+		{
+			name: "kex",
+			err: &handshakeError{msg: "ssh: handshake failed: ssh: no common algorithm",
+				cause: ErrKeyExchange},
+			want: ReasonKeyExchange,
+		},
+		{
+			name: "host-key",
+			err:  fmt.Errorf("%w: %w", ErrHostKeyRejected, errors.New("host key mismatch")),
+			want: ReasonHostKey,
+		},
+		{
+			name: "handshake-network",
+			err: &handshakeError{msg: "ssh: handshake failed: connection reset",
+				cause: &net.OpError{Op: "read", Err: syscall.ECONNRESET}},
+			want: ReasonConnection,
+		},
+		{
+			name: "auth-message-only",
+			err: errors.New("ssh: handshake failed: ssh: unable to authenticate, " +
+				"attempted methods [none publickey], no supported methods remain"),
+			want: ReasonUnknown,
+		},
+		{
+			name:     "rpc",
+			err:      &netconf.RPCError{Severity: "error", Message: "syntax error"},
+			fallback: ReasonUnknown,
+			want:     ReasonRPC,
+		},
+		{
+			name:     "fallback",
+			err:      errors.New("the section you provided is not configured"),
+			fallback: ReasonRPC,
+			want:     ReasonRPC,
+		},
+		{
+			name:     "already-classified",
+			err:      &Error{Reason: ReasonAuth, Err: errors.New("error")},
+			fallback: ReasonRPC,
+			want:     ReasonAuth,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.fallback == "" {
+				tt.fallback = ReasonUnknown
+			}
+			err := classify(tt.err, tt.fallback)
+			if got := Reason(err); got != tt.want {
+				t.Errorf("Reason() = %q, want %q", got, tt.want)
+			}
+			if !errors.Is(err, tt.err) || err.Error() != tt.err.Error() {
+				t.Errorf("classify() = %v, want a wrapper of %v", err, tt.err)
+			}
+		})
+	}
+
+	if classify(nil, ReasonRPC) != nil {
+		t.Errorf("classify(nil) != nil")
+	}
+	if got := Reason(errors.New("error")); got != ReasonUnknown {
+		t.Errorf("Reason() = %q, want %q", got, ReasonUnknown)
+	}
+}
+
+func TestClient_errors(t *testing.T) {
+	c := Client{auth: &credentials.Config{}, connector: mockConnector{mustFailConn: true}}
+	if _, err := c.GetConfig(context.Background(), "s1-abc01"); Reason(err) != ReasonRPC {
+		t.Errorf("GetConfig() error reason = %q, want %q", Reason(err), ReasonRPC)
+	}
+	if _, err := c.Exec(context.Background(), "s1-abc01", "<rpc/>"); Reason(err) != ReasonRPC {
+		t.Errorf("Exec() error reason = %q, want %q", Reason(err), ReasonRPC)
+	}
+	c.connector = mockConnector{mustFail: true}
+	if _, err := c.GetConfig(context.Background(), "s1-abc01"); Reason(err) != ReasonUnknown {
+		t.Errorf("GetConfig() error reason = %q, want %q", Reason(err), ReasonUnknown)
+	}
+}
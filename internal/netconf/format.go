@@ -0,0 +1,53 @@
+package netconf
+
+// Format is the syntax of a configuration file. It determines how
+// configurations are parsed into a tree before being normalized and compared,
+// so that the same rules and comparison logic apply to every format.
+type Format struct {
+	parse func(config string) *Node
+}
+
+var (
+	// Text is the JunOS curly-brace configuration syntax. It's also used by
+	// the zero Format.
+	Text = Format{parse: Parse}
+	// XML is the XML encoding of NETCONF <get-config> replies, as defined by
+	// RFC 6241.
+	XML = Format{parse: ParseXML}
+)
+
+// Compare cleans up two configuration files according to the rules and
+// returns true if they are semantically the same.
+func (f Format) Compare(c1, c2 string, rules *Rules) bool {
+	return len(Mismatches(f.canonical(c1, rules), f.canonical(c2, rules))) == 0
+}
+
+// Diff cleans up the expected and actual configuration files according to
+// the rules and returns the differences between them.
+func (f Format) Diff(expected, actual string, rules *Rules) *Diff {
+	return diffTrees(f.canonical(expected, rules), f.canonical(actual, rules))
+}
+
+// SectionDiff cleans up the expected and actual configuration files
+// according to the rules and returns the differences in the specified
+// top-level section. A section missing from either file is considered empty.
+func (f Format) SectionDiff(expected, actual, section string, rules *Rules) *Diff {
+	return diffTrees(sectionOf(f.canonical(expected, rules), section),
+		sectionOf(f.canonical(actual, rules), section))
+}
+
+// canonical normalizes a configuration file according to the rules and
+// parses it, to make it comparable. A nil rules applies the default rules
+// only.
+func (f Format) canonical(config string, rules *Rules) *Node {
+	if rules == nil {
+		rules = &defaultRules
+	}
+	parse := f.parse
+	if parse == nil {
+		parse = Parse
+	}
+	root := parse(rules.replace(config))
+	rules.apply(root, nil)
+	return root
+}
@@ -0,0 +1,33 @@
+package netconf
+
+import (
+	"testing"
+)
+
+func TestFormat_Compare(t *testing.T) {
+	expected := `<config><system><hostname>s1</hostname><ntp>a</ntp></system></config>`
+	actual := `<rpc-reply><data>
+  <system>
+    <ntp>a</ntp>
+    <hostname>s1</hostname>
+  </system>
+</data></rpc-reply>`
+	if !XML.Compare(expected, actual, nil) {
+		t.Errorf("Compare() = false, want true")
+	}
+
+	rules := &Rules{Ignore: []string{"system ntp"}}
+	if !XML.Compare(expected, "<data><system><hostname>s1</hostname></system></data>", rules) {
+		t.Errorf("Compare() = false, want true")
+	}
+
+	d := XML.SectionDiff(expected, "<data><system><hostname>s2</hostname></system></data>", "system", nil)
+	if d.Lines() != 3 {
+		t.Errorf("SectionDiff() = %+v, want 3 different lines", d)
+	}
+
+	// The zero Format is the JunOS text format.
+	if !(Format{}).Compare("a { b; c; }", "a { c; b; }", nil) {
+		t.Errorf("Compare() = false, want true")
+	}
+}
@@ -0,0 +1,95 @@
+package netconf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+	"golang.org/x/crypto/ssh"
+)
+
+// rpcEmptyGetConfig reads an empty subset of the running configuration. An
+// empty subtree filter selects nothing (RFC 6241, section 6.4.2), making it a
+// lightweight, vendor-neutral way to check whether a session is usable.
+const rpcEmptyGetConfig = `<get-config><source><running/></source><filter type="subtree"/></get-config>`
+
+var newGenericSession = dialGenericSession
+
+// genericConnector establishes standard RFC 6241 NETCONF sessions over SSH,
+// which are supported by most vendors.
+type genericConnector struct {
+	// hostKeys verifies the switches' host keys. If nil, any key is
+	// accepted.
+	hostKeys HostKeyPolicy
+}
+
+func (c genericConnector) NewSession(ctx context.Context, host string, auth credentials.Provider) (connection, error) {
+	config, err := sshConfig(ctx, host, auth, c.hostKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+	s, err := newGenericSession(ctx, host, config)
+	if err != nil {
+		return nil, err
+	}
+	return withGauge(genericSession{s}, sessionsOpen), nil
+}
+
+// dialGenericSession connects to the NETCONF port of the specified host and
+// establishes a session.
+func dialGenericSession(ctx context.Context, host string, config *ssh.ClientConfig) (*netconf.Session, error) {
+	var s *netconf.Session
+	err := dialNetconf(ctx, host, func(conn net.Conn) error {
+		var err error
+		s, err = newSSHSession(conn, config)
+		return err
+	})
+	return s, err
+}
+
+// genericSession is a standard NETCONF session. If the context passed to any
+// of its methods is done before the call completes, the session is closed.
+type genericSession struct {
+	*netconf.Session
+}
+
+// GetConfig reads the running configuration with <get-config>. The
+// configuration is always returned as XML, so the format is ignored. Each
+// section is the name of a top-level element to select with a subtree
+// filter.
+func (s genericSession) GetConfig(ctx context.Context, format string, section ...string) (string, error) {
+	rpc := "<get-config><source><running/></source>"
+	if len(section) > 0 && section[0] != "" {
+		var filter strings.Builder
+		for _, sec := range section {
+			fmt.Fprintf(&filter, "<%s/>", sec)
+		}
+		rpc += `<filter type="subtree">` + filter.String() + "</filter>"
+	}
+	rpc += "</get-config>"
+
+	return execRPC(ctx, s.Session, rpc)
+}
+
+// Exec runs a raw RPC and returns the content of the reply.
+func (s genericSession) Exec(ctx context.Context, rpc string) (string, error) {
+	return execRPC(ctx, s.Session, rpc)
+}
+
+// Ping sends an empty <get-config> to check whether the session is still
+// usable.
+func (s genericSession) Ping(ctx context.Context) error {
+	_, err := execRPC(ctx, s.Session, rpcEmptyGetConfig)
+	return err
+}
+
+// Close closes the session.
+func (s genericSession) Close() {
+	s.Session.Close()
+}
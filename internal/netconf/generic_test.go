@@ -0,0 +1,91 @@
+package netconf
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_genericConnector_NewSession(t *testing.T) {
+	ctx := context.Background()
+	c := genericConnector{}
+	if _, err := c.NewSession(ctx, "", &credentials.Config{}); err == nil {
+		t.Errorf("NewSession(): expected err, got nil.")
+	}
+
+	oldNewSession := newGenericSession
+	defer func() { newGenericSession = oldNewSession }()
+	auth := dummyCredentials()
+
+	newGenericSession = func(ctx context.Context, host string, config *ssh.ClientConfig) (*netconf.Session, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("NewSession() did not set a deadline")
+		}
+		return &netconf.Session{}, nil
+	}
+	if s, err := c.NewSession(ctx, "s1-abc01", auth); err != nil || s == nil {
+		t.Errorf("NewSession() = %v, %v", s, err)
+	}
+
+	newGenericSession = func(context.Context, string, *ssh.ClientConfig) (*netconf.Session, error) {
+		return nil, errors.New("error")
+	}
+	if _, err := c.NewSession(ctx, "s1-abc01", auth); err == nil {
+		t.Errorf("NewSession(): expected err, got nil.")
+	}
+}
+
+func Test_genericSession(t *testing.T) {
+	transport := newFakeTransport(func(req string) string {
+		if strings.Contains(req, "<system/>") {
+			return "<rpc-reply><data><system><hostname>s1</hostname></system></data></rpc-reply>"
+		}
+		return "<rpc-reply><data/></rpc-reply>"
+	})
+	s := genericSession{&netconf.Session{Transport: transport}}
+
+	config, err := s.GetConfig(context.Background(), "xml", "system")
+	if err != nil || config != "<data><system><hostname>s1</hostname></system></data>" {
+		t.Errorf("GetConfig() = %q, %v", config, err)
+	}
+	if !strings.Contains(transport.request, `<filter type="subtree"><system/></filter>`) {
+		t.Errorf("GetConfig() sent %q, want a subtree filter", transport.request)
+	}
+	if _, err := s.GetConfig(context.Background(), "xml"); err != nil {
+		t.Errorf("GetConfig() returned err: %v", err)
+	}
+	if strings.Contains(transport.request, "filter") {
+		t.Errorf("GetConfig() sent %q, want no filter", transport.request)
+	}
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping() returned err: %v", err)
+	}
+
+	transport.reply = func(string) string {
+		return "<rpc-reply><rpc-error><error-severity>error</error-severity>" +
+			"</rpc-error></rpc-reply>"
+	}
+	if _, err := s.GetConfig(context.Background(), "xml"); err == nil {
+		t.Errorf("GetConfig(): expected err, got nil.")
+	}
+
+	// A canceled context closes the session and aborts the call.
+	transport.hang = true
+	ctx, cancel := context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	if _, err := s.GetConfig(ctx, "xml"); err != context.DeadlineExceeded {
+		t.Errorf("GetConfig() returned %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+	if !transport.isClosed {
+		t.Errorf("GetConfig() did not close the session")
+	}
+	s.Close()
+}
@@ -0,0 +1,38 @@
+package netconf
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sessionsOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "switch_monitoring_ssh_sessions_open",
+		Help: "Number of open NETCONF sessions, including idle pooled ones",
+	})
+	sessionsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "switch_monitoring_ssh_sessions_in_use",
+		Help: "Number of NETCONF sessions used by a call in progress",
+	})
+)
+
+// gaugedConnection is a connection counted in a gauge until it's closed.
+type gaugedConnection struct {
+	connection
+	gauge prometheus.Gauge
+	once  sync.Once
+}
+
+// withGauge increments the gauge and returns a connection decrementing it
+// when closed for the first time.
+func withGauge(c connection, gauge prometheus.Gauge) connection {
+	gauge.Inc()
+	return &gaugedConnection{connection: c, gauge: gauge}
+}
+
+func (c *gaugedConnection) Close() {
+	c.once.Do(c.gauge.Dec)
+	c.connection.Close()
+}
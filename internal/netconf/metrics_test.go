@@ -0,0 +1,61 @@
+package netconf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/ssh"
+)
+
+func Test_withGauge(t *testing.T) {
+	before := testutil.ToFloat64(sessionsInUse)
+	conn := &mockConnection{}
+	c := withGauge(conn, sessionsInUse)
+	if v := testutil.ToFloat64(sessionsInUse) - before; v != 1 {
+		t.Errorf("sessions in use = %v, want 1", v)
+	}
+
+	// Closing more than once only decrements the gauge once.
+	c.Close()
+	c.Close()
+	if v := testutil.ToFloat64(sessionsInUse) - before; v != 0 {
+		t.Errorf("sessions in use = %v, want 0", v)
+	}
+	if !conn.closed {
+		t.Errorf("Close() did not close the connection")
+	}
+}
+
+func TestClient_sessionGauges(t *testing.T) {
+	oldNewSession := newGenericSession
+	defer func() { newGenericSession = oldNewSession }()
+	newGenericSession = func(context.Context, string, *ssh.ClientConfig) (*netconf.Session, error) {
+		return &netconf.Session{Transport: newFakeTransport(func(string) string {
+			return "<rpc-reply><data/></rpc-reply>"
+		})}, nil
+	}
+
+	open := testutil.ToFloat64(sessionsOpen)
+	inUse := testutil.ToFloat64(sessionsInUse)
+	c := NewGeneric(dummyCredentials())
+	conn, err := c.session(context.Background(), c.connector, "s1-abc01")
+	if err != nil {
+		t.Fatalf("session() returned err: %v", err)
+	}
+	if v := testutil.ToFloat64(sessionsOpen) - open; v != 1 {
+		t.Errorf("open sessions = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(sessionsInUse) - inUse; v != 1 {
+		t.Errorf("sessions in use = %v, want 1", v)
+	}
+
+	conn.Close()
+	if v := testutil.ToFloat64(sessionsOpen) - open; v != 0 {
+		t.Errorf("open sessions = %v, want 0", v)
+	}
+	if v := testutil.ToFloat64(sessionsInUse) - inUse; v != 0 {
+		t.Errorf("sessions in use = %v, want 0", v)
+	}
+}
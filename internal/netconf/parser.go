@@ -0,0 +1,276 @@
+package netconf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// orderedKeywords lists the statement keywords whose relative order is
+// meaningful to JunOS. Every other statement is compared as part of an
+// unordered set.
+var orderedKeywords = map[string]bool{
+	// Firewall filter and policy-statement terms are evaluated in order.
+	"term": true,
+}
+
+// orderedLists lists the keywords of the bracketed lists whose order is
+// meaningful to JunOS. Every other list is compared as a set.
+var orderedLists = map[string]bool{
+	// Policy chains are evaluated in order until a policy accepts or
+	// rejects the route.
+	"import":     true,
+	"export":     true,
+	"vrf-import": true,
+	"vrf-export": true,
+	"policy":     true,
+	// The first group setting a statement takes precedence.
+	"apply-groups": true,
+}
+
+// Node is a statement in a JunOS configuration file. Containers (e.g.
+// "interfaces { ... }") have children, while leaves (e.g. "host-name foo;")
+// do not. The root of a parsed configuration is a container with no words.
+type Node struct {
+	Words     []string
+	Container bool
+	Children  []*Node
+}
+
+// Statement returns the statement words joined by a space.
+func (n *Node) Statement() string {
+	words := make([]string, len(n.Words))
+	for i, w := range n.Words {
+		words[i] = quote(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// Child returns the first child whose first word is the given keyword, or
+// nil if there is none.
+func (n *Node) Child(keyword string) *Node {
+	for _, c := range n.Children {
+		if len(c.Words) > 0 && c.Words[0] == keyword {
+			return c
+		}
+	}
+	return nil
+}
+
+// ordered returns true if the relative order of this statement among its
+// siblings is meaningful.
+func (n *Node) ordered() bool {
+	return len(n.Words) > 0 && orderedKeywords[n.Words[0]]
+}
+
+// key uniquely identifies a statement among its siblings.
+func (n *Node) key() string {
+	if n.Container {
+		return n.Statement() + " {"
+	}
+	return n.Statement() + ";"
+}
+
+// String renders the configuration in a canonical format: unordered
+// statements are sorted, comments are omitted and every level is indented by
+// four spaces.
+func (n *Node) String() string {
+	buf := new(bytes.Buffer)
+	for _, c := range n.sortedChildren() {
+		c.write(buf, 0)
+	}
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func (n *Node) write(buf *bytes.Buffer, depth int) {
+	indent := strings.Repeat("    ", depth)
+	fmt.Fprintf(buf, "%s%s\n", indent, n.key())
+	if !n.Container {
+		return
+	}
+	for _, c := range n.sortedChildren() {
+		c.write(buf, depth+1)
+	}
+	fmt.Fprintf(buf, "%s}\n", indent)
+}
+
+// sortedChildren returns the unordered children sorted by key, followed by
+// the ordered ones in their original order.
+func (n *Node) sortedChildren() []*Node {
+	var unordered, ordered []*Node
+	for _, c := range n.Children {
+		if c.ordered() {
+			ordered = append(ordered, c)
+		} else {
+			unordered = append(unordered, c)
+		}
+	}
+	sort.SliceStable(unordered, func(i, j int) bool {
+		return unordered[i].key() < unordered[j].key()
+	})
+	return append(unordered, ordered...)
+}
+
+// quote returns the word as it should appear in a configuration file,
+// surrounded by double quotes if needed. Words that were quoted in the
+// configuration they were parsed from are returned as is.
+func quote(w string) string {
+	if quoted(w) {
+		return w
+	}
+	if w != "" && !strings.ContainsAny(w, " \t\n;{}\"#\\") {
+		return w
+	}
+	w = strings.ReplaceAll(w, `\`, `\\`)
+	return `"` + strings.ReplaceAll(w, `"`, `\"`) + `"`
+}
+
+// quoted returns true if the word was quoted in the configuration it was
+// parsed from. Since unquoted words end at double quotes, only those start
+// with one.
+func quoted(w string) bool {
+	return strings.HasPrefix(w, `"`)
+}
+
+// Parse parses a configuration in the JunOS curly-brace text format and
+// returns the root of the statement tree.
+//
+// Comments (both "#" and "/* */") are discarded. Quoted strings are kept as
+// written, with their quotes and escapes, so that they are pushed back as is:
+// e.g. the backslashes of a quoted regular expression are significant. Statements are terminated by
+// a semicolon or by the end of the line. The words of a bracketed list (e.g.
+// "[ a b c ]") are sorted, since JunOS treats most lists as sets, except for
+// the lists of orderedLists, e.g. policy chains, which keep their order.
+// Parsing is lenient: unbalanced braces are tolerated, so that a truncated configuration
+// can still be compared.
+func Parse(config string) *Node {
+	root := &Node{Container: true}
+	stack := []*Node{root}
+	var words, list []string
+	inList := false
+
+	flush := func() {
+		if len(words) > 0 {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, &Node{Words: words})
+			words = nil
+		}
+	}
+
+	l := &lexer{input: config}
+	for tok, ok := l.next(); ok; tok, ok = l.next() {
+		switch {
+		case tok.quoted:
+			if inList {
+				list = append(list, tok.text)
+			} else {
+				words = append(words, tok.text)
+			}
+		case tok.text == "[":
+			inList = true
+			list = nil
+		case tok.text == "]":
+			inList = false
+			if len(words) == 0 || !orderedLists[words[len(words)-1]] {
+				sort.Strings(list)
+			}
+			words = append(words, "[")
+			words = append(words, list...)
+			words = append(words, "]")
+		case tok.text == ";" || tok.text == "\n":
+			if !inList {
+				flush()
+			}
+		case tok.text == "{":
+			parent := stack[len(stack)-1]
+			n := &Node{Words: words, Container: true}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+			words = nil
+		case tok.text == "}":
+			flush()
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case inList:
+			list = append(list, tok.text)
+		default:
+			words = append(words, tok.text)
+		}
+	}
+	flush()
+
+	return root
+}
+
+// token is a lexical token of a JunOS configuration. Punctuation and
+// newlines are returned as single-character tokens.
+type token struct {
+	text   string
+	quoted bool
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+// next returns the next token, or false at the end of the input.
+func (l *lexer) next() (token, bool) {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case c == '\n' || c == ';' || c == '{' || c == '}' || c == '[' ||
+			c == ']':
+			l.pos++
+			return token{text: string(c)}, true
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#':
+			// Skip until the end of the line, but keep the newline.
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				l.pos = len(l.input)
+			} else {
+				l.pos += end
+			}
+		case strings.HasPrefix(l.input[l.pos:], "/*"):
+			end := strings.Index(l.input[l.pos+2:], "*/")
+			if end < 0 {
+				l.pos = len(l.input)
+			} else {
+				l.pos += end + 4
+			}
+		case c == '"':
+			return l.quoted(), true
+		default:
+			start := l.pos
+			for l.pos < len(l.input) &&
+				!strings.ContainsRune(" \t\r\n;{}[]\"", rune(l.input[l.pos])) {
+				l.pos++
+			}
+			return token{text: l.input[start:l.pos]}, true
+		}
+	}
+	return token{}, false
+}
+
+// quoted reads a double-quoted string, skipping backslash escapes. The
+// token is the string as written, including the quotes. An unterminated
+// string is closed at the end of the input.
+func (l *lexer) quoted() token {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		l.pos++
+		if c == '"' {
+			return token{text: l.input[start:l.pos], quoted: true}
+		}
+		if c == '\\' && l.pos < len(l.input) {
+			l.pos++
+		}
+	}
+	return token{text: l.input[start:] + `"`, quoted: true}
+}
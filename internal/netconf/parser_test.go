@@ -0,0 +1,190 @@
+package netconf
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   *Node
+	}{
+		{
+			name:   "empty",
+			config: "",
+			want:   &Node{Container: true},
+		},
+		{
+			name: "nested",
+			config: `## Last changed
+system {
+    host-name "s1-abc01"; ## comment
+    /* annotation */
+    services {
+        ssh;
+    }
+}`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"system"}, Container: true, Children: []*Node{
+					{Words: []string{"host-name", `"s1-abc01"`}},
+					{Words: []string{"services"}, Container: true, Children: []*Node{
+						{Words: []string{"ssh"}},
+					}},
+				}},
+			}},
+		},
+		{
+			name:   "list-is-sorted",
+			config: `ciphers [ b "a c" d ];`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"ciphers", "[", `"a c"`, "b", "d", "]"}},
+			}},
+		},
+		{
+			name:   "ordered-list",
+			config: `import [ deny-bogons accept-all ];`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"import", "[", "deny-bogons", "accept-all", "]"}},
+			}},
+		},
+		{
+			name:   "multi-line-list",
+			config: "members [ b\na ];",
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"members", "[", "a", "b", "]"}},
+			}},
+		},
+		{
+			name:   "newline-terminates-statement",
+			config: "a b\nc",
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"a", "b"}},
+				{Words: []string{"c"}},
+			}},
+		},
+		{
+			name:   "escaped-quote",
+			config: `description "a \"b\"";`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"description", `"a \"b\""`}},
+			}},
+		},
+		{
+			name:   "unbalanced-braces",
+			config: "}\na {\nb;",
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"a"}, Container: true, Children: []*Node{
+					{Words: []string{"b"}},
+				}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNode_String(t *testing.T) {
+	config := `system {
+    services { ssh; netconf { ssh; } }
+    host-name s1;
+}
+firewall {
+    filter f {
+        term b { then accept; }
+        term a { then discard; }
+        interface-specific;
+    }
+}
+description "a b";`
+	want := `description "a b";
+firewall {
+    filter f {
+        interface-specific;
+        term b {
+            then accept;
+        }
+        term a {
+            then discard;
+        }
+    }
+}
+system {
+    host-name s1;
+    services {
+        netconf {
+            ssh;
+        }
+        ssh;
+    }
+}`
+	if got := Parse(config).String(); got != want {
+		t.Errorf("String() = \n%s\nwant\n%s", got, want)
+	}
+
+	// Rendering a parsed file and parsing it again must give the same tree.
+	abc01Conf, err := ioutil.ReadFile("testdata/abc01.conf")
+	rtx.Must(err, "Cannot read test data")
+	n := Parse(string(abc01Conf))
+	if !reflect.DeepEqual(Parse(n.String()).String(), n.String()) {
+		t.Errorf("String() is not stable")
+	}
+}
+
+func TestNode_Child(t *testing.T) {
+	n := Parse("system { host-name s1; }\ninterfaces { xe-0/0/1 { mtu 9216; } }")
+	if c := n.Child("interfaces"); c == nil || c.Statement() != "interfaces" {
+		t.Errorf("Child() returned %v", c)
+	}
+	if c := n.Child("protocols"); c != nil {
+		t.Errorf("Child() returned %v, want nil", c)
+	}
+}
+
+func Test_quote(t *testing.T) {
+	tests := []struct {
+		name string
+		word string
+		want string
+	}{
+		{name: "plain", word: "ge-0/0/1", want: "ge-0/0/1"},
+		{name: "empty", word: "", want: `""`},
+		{name: "space", word: "a b", want: `"a b"`},
+		{name: "quote", word: `a"b`, want: `"a\"b"`},
+		{name: "backslash", word: `a\b`, want: `"a\\b"`},
+		{name: "already-quoted", word: `"^65000\.1$"`, want: `"^65000\.1$"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quote(tt.word); got != tt.want {
+				t.Errorf("quote() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_quotedRoundTrip(t *testing.T) {
+	// Quoted strings are rendered as written, keeping their escapes.
+	config := `policy-options {
+    as-path private "^6451[2-9]\.[0-9]+$";
+    community blackhole members "^65535:666$";
+    policy-statement p {
+        from as-path-regex "^65000\.1$";
+        then {
+            description "a \"quoted\" word";
+        }
+    }
+}`
+	if got := Parse(config).String(); got != config {
+		t.Errorf("String() = \n%s\nwant\n%s", got, config)
+	}
+}
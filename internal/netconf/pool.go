@@ -0,0 +1,276 @@
+package netconf
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+// PoolConfig configures how sessions are reused by a pooled Client.
+type PoolConfig struct {
+	// MaxSessionsPerHost is the maximum number of sessions open at the same
+	// time for each host. Callers wait until a session becomes available or
+	// their context is done.
+	MaxSessionsPerHost int
+
+	// IdleTimeout is how long an unused session is kept open.
+	IdleTimeout time.Duration
+}
+
+// idleSession is an open session waiting to be reused.
+type idleSession struct {
+	conn  connection
+	since time.Time
+}
+
+// hostPool contains the sessions for a single host.
+type hostPool struct {
+	// slots limits the number of sessions in use at the same time.
+	slots chan struct{}
+	// idle is a stack of open sessions not in use, the most recently used
+	// on top.
+	idle []idleSession
+}
+
+// poolConnector is a connector reusing sessions across calls. Sessions are
+// health-checked before being reused and are closed after being idle for
+// longer than the configured timeout.
+type poolConnector struct {
+	connector connector
+	config    PoolConfig
+
+	mu        sync.Mutex
+	hosts     map[string]*hostPool
+	done      chan struct{}
+	closeOnce sync.Once
+
+	now func() time.Time
+}
+
+func newPoolConnector(c connector, config PoolConfig) *poolConnector {
+	if config.MaxSessionsPerHost < 1 {
+		config.MaxSessionsPerHost = 1
+	}
+	p := &poolConnector{
+		connector: c,
+		config:    config,
+		hosts:     map[string]*hostPool{},
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+	go p.reap()
+	return p
+}
+
+// NewSession returns a session for the specified host, reusing an idle one
+// if possible. Closing the returned connection gives the session back to the
+// pool.
+func (p *poolConnector) NewSession(ctx context.Context, host string, auth credentials.Provider) (connection, error) {
+	hp := p.hostPool(host)
+	select {
+	case hp.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := p.get(ctx, host, auth, hp)
+	if err != nil {
+		<-hp.slots
+		return nil, err
+	}
+	return &pooledConnection{
+		connection: conn,
+		pool:       p,
+		host:       host,
+		auth:       auth,
+	}, nil
+}
+
+// Close closes all the idle sessions and stops the background reaper.
+func (p *poolConnector) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hp := range p.hosts {
+		for _, s := range hp.idle {
+			s.conn.Close()
+		}
+		hp.idle = nil
+	}
+}
+
+func (p *poolConnector) hostPool(host string) *hostPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	hp, ok := p.hosts[host]
+	if !ok {
+		hp = &hostPool{
+			slots: make(chan struct{}, p.config.MaxSessionsPerHost),
+		}
+		p.hosts[host] = hp
+	}
+	return hp
+}
+
+// get returns a healthy idle session for the host, or a new one if there
+// are none.
+func (p *poolConnector) get(ctx context.Context, host string, auth credentials.Provider, hp *hostPool) (connection, error) {
+	for {
+		p.mu.Lock()
+		if len(hp.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		s := hp.idle[len(hp.idle)-1]
+		hp.idle = hp.idle[:len(hp.idle)-1]
+		p.mu.Unlock()
+
+		if p.now().Sub(s.since) < p.config.IdleTimeout {
+			err := s.conn.Ping(ctx)
+			if err == nil {
+				return s.conn, nil
+			}
+			log.WithField("host", host).WithError(err).Debug(
+				"Discarding unhealthy NETCONF session")
+		}
+		s.conn.Close()
+	}
+
+	return p.connector.NewSession(ctx, host, auth)
+}
+
+// put gives a session back to the pool. If the pool has been closed, the
+// session is closed instead.
+func (p *poolConnector) put(host string, conn connection) {
+	select {
+	case <-p.done:
+		p.discard(host, conn)
+		return
+	default:
+	}
+
+	p.mu.Lock()
+	hp := p.hosts[host]
+	hp.idle = append(hp.idle, idleSession{conn: conn, since: p.now()})
+	p.mu.Unlock()
+	<-hp.slots
+}
+
+// discard closes a session and frees its slot.
+func (p *poolConnector) discard(host string, conn connection) {
+	conn.Close()
+	p.mu.Lock()
+	hp := p.hosts[host]
+	p.mu.Unlock()
+	<-hp.slots
+}
+
+// reap periodically closes the sessions that have been idle for too long.
+func (p *poolConnector) reap() {
+	ticker := time.NewTicker(p.config.IdleTimeout/2 + time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.closeExpired()
+		}
+	}
+}
+
+func (p *poolConnector) closeExpired() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hp := range p.hosts {
+		idle := hp.idle[:0]
+		for _, s := range hp.idle {
+			if p.now().Sub(s.since) >= p.config.IdleTimeout {
+				s.conn.Close()
+				continue
+			}
+			idle = append(idle, s)
+		}
+		hp.idle = idle
+	}
+}
+
+// pooledConnection is a session leased from a poolConnector. If a call
+// fails and the session turns out to be broken, it transparently reconnects
+// and retries the call once.
+type pooledConnection struct {
+	connection
+	pool *poolConnector
+	host string
+	auth credentials.Provider
+	// broken is true if the session cannot be reused.
+	broken bool
+}
+
+func (c *pooledConnection) GetConfig(ctx context.Context, format string, section ...string) (string, error) {
+	config, err := c.connection.GetConfig(ctx, format, section...)
+	if err == nil || !c.reconnect(ctx) {
+		return config, err
+	}
+	return c.connection.GetConfig(ctx, format, section...)
+}
+
+func (c *pooledConnection) Exec(ctx context.Context, rpc string) (string, error) {
+	reply, err := c.connection.Exec(ctx, rpc)
+	if err == nil || !c.reconnect(ctx) {
+		return reply, err
+	}
+	return c.connection.Exec(ctx, rpc)
+}
+
+// reconnect checks whether the session is still healthy after a failure. If
+// not, it replaces it with a new session and returns true.
+func (c *pooledConnection) reconnect(ctx context.Context) bool {
+	if c.connection.Ping(ctx) == nil {
+		return false
+	}
+	c.connection.Close()
+	conn, err := c.pool.connector.NewSession(ctx, c.host, c.auth)
+	if err != nil {
+		log.WithField("host", c.host).WithError(err).Debug(
+			"Cannot reconnect broken NETCONF session")
+		c.connection = closedConnection{err: err}
+		c.broken = true
+		return false
+	}
+	c.connection = conn
+	return true
+}
+
+// Close gives the session back to the pool, or closes it if it's broken.
+func (c *pooledConnection) Close() {
+	if c.broken {
+		c.pool.discard(c.host, c.connection)
+		return
+	}
+	c.pool.put(c.host, c.connection)
+}
+
+// closedConnection is a placeholder for a session that could not be
+// re-established.
+type closedConnection struct {
+	err error
+}
+
+func (c closedConnection) GetConfig(context.Context, string, ...string) (string, error) {
+	return "", c.err
+}
+
+func (c closedConnection) Exec(context.Context, string) (string, error) {
+	return "", c.err
+}
+
+func (c closedConnection) Ping(context.Context) error {
+	return c.err
+}
+
+func (closedConnection) Close() {}
@@ -0,0 +1,268 @@
+package netconf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+// countingConnector returns a new mockConnection for every session and keeps
+// track of them.
+type countingConnector struct {
+	mu       sync.Mutex
+	mustFail bool
+	sessions []*mockConnection
+}
+
+func (c *countingConnector) NewSession(context.Context, string, credentials.Provider) (connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mustFail {
+		return nil, fmt.Errorf("error")
+	}
+	conn := &mockConnection{}
+	c.sessions = append(c.sessions, conn)
+	return conn, nil
+}
+
+func (c *countingConnector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sessions)
+}
+
+var ctx = context.Background()
+
+func Test_poolConnector_reuse(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{
+		MaxSessionsPerHost: 2,
+		IdleTimeout:        time.Minute,
+	})
+	defer p.Close()
+
+	// Sequential sessions for the same host reuse the same connection.
+	for i := 0; i < 3; i++ {
+		conn, err := p.NewSession(ctx, "host", &credentials.Config{})
+		if err != nil {
+			t.Fatalf("NewSession() returned err: %v", err)
+		}
+		if _, err := conn.GetConfig(ctx, "text"); err != nil {
+			t.Errorf("GetConfig() returned err: %v", err)
+		}
+		conn.Close()
+	}
+	if c.count() != 1 {
+		t.Errorf("NewSession() opened %d sessions, want 1", c.count())
+	}
+
+	// A different host gets a different session.
+	conn, err := p.NewSession(ctx, "other", &credentials.Config{})
+	if err != nil {
+		t.Fatalf("NewSession() returned err: %v", err)
+	}
+	conn.Close()
+	if c.count() != 2 {
+		t.Errorf("NewSession() opened %d sessions, want 2", c.count())
+	}
+
+	// Unhealthy sessions are not reused.
+	c.sessions[0].mustFailPing = true
+	conn, err = p.NewSession(ctx, "host", &credentials.Config{})
+	if err != nil {
+		t.Fatalf("NewSession() returned err: %v", err)
+	}
+	conn.Close()
+	if c.count() != 3 || !c.sessions[0].closed {
+		t.Errorf("NewSession() reused an unhealthy session")
+	}
+
+	// Closing the pool closes all the idle sessions.
+	p.Close()
+	for i, s := range c.sessions {
+		if !s.closed {
+			t.Errorf("session %d was not closed", i)
+		}
+	}
+}
+
+func Test_poolConnector_maxSessions(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{
+		MaxSessionsPerHost: 1,
+		IdleTimeout:        time.Minute,
+	})
+	defer p.Close()
+
+	first, err := p.NewSession(ctx, "host", &credentials.Config{})
+	if err != nil {
+		t.Fatalf("NewSession() returned err: %v", err)
+	}
+
+	// The second session must wait for the first one to be released.
+	acquired := make(chan connection)
+	go func() {
+		conn, _ := p.NewSession(ctx, "host", &credentials.Config{})
+		acquired <- conn
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatalf("NewSession() did not wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.Close()
+	second := <-acquired
+	second.Close()
+	if c.count() != 1 {
+		t.Errorf("NewSession() opened %d sessions, want 1", c.count())
+	}
+
+	// A failure to connect frees the slot.
+	c.mustFail = true
+	if _, err := p.NewSession(ctx, "other", &credentials.Config{}); err == nil {
+		t.Errorf("NewSession(): expected err, got nil.")
+	}
+	c.mustFail = false
+	conn, err := p.NewSession(ctx, "other", &credentials.Config{})
+	if err != nil {
+		t.Fatalf("NewSession() returned err: %v", err)
+	}
+	conn.Close()
+}
+
+func Test_poolConnector_idleTimeout(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{
+		MaxSessionsPerHost: 1,
+		IdleTimeout:        time.Minute,
+	})
+	defer p.Close()
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	conn, _ := p.NewSession(ctx, "host", &credentials.Config{})
+	conn.Close()
+
+	// Expired sessions are not reused.
+	now = now.Add(2 * time.Minute)
+	conn, _ = p.NewSession(ctx, "host", &credentials.Config{})
+	conn.Close()
+	if c.count() != 2 || !c.sessions[0].closed {
+		t.Errorf("NewSession() reused an expired session")
+	}
+
+	// Expired sessions are closed by the reaper.
+	now = now.Add(2 * time.Minute)
+	p.closeExpired()
+	if !c.sessions[1].closed {
+		t.Errorf("closeExpired() did not close the expired session")
+	}
+}
+
+func Test_pooledConnection_reconnect(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{
+		MaxSessionsPerHost: 1,
+		IdleTimeout:        time.Minute,
+	})
+	defer p.Close()
+
+	conn, _ := p.NewSession(ctx, "host", &credentials.Config{})
+
+	// An RPC failure on a healthy session is returned as is.
+	c.sessions[0].mustFail = true
+	if _, err := conn.GetConfig(ctx, "text"); err == nil {
+		t.Errorf("GetConfig(): expected err, got nil.")
+	}
+	if c.count() != 1 {
+		t.Errorf("GetConfig() reconnected a healthy session")
+	}
+
+	// A broken session is replaced transparently.
+	c.sessions[0].mustFailPing = true
+	if _, err := conn.GetConfig(ctx, "text"); err != nil {
+		t.Errorf("GetConfig() returned err: %v", err)
+	}
+	if c.count() != 2 || !c.sessions[0].closed {
+		t.Errorf("GetConfig() did not reconnect a broken session")
+	}
+
+	// Exec is retried in the same way.
+	c.sessions[1].mustFail = true
+	c.sessions[1].mustFailPing = true
+	if _, err := conn.Exec(ctx, "<rpc/>"); err != nil {
+		t.Errorf("Exec() returned err: %v", err)
+	}
+	if c.count() != 3 || !c.sessions[1].closed {
+		t.Errorf("Exec() did not reconnect a broken session")
+	}
+
+	// If reconnecting fails, the session is discarded on Close.
+	c.sessions[2].mustFail = true
+	c.sessions[2].mustFailPing = true
+	c.mustFail = true
+	if _, err := conn.GetConfig(ctx, "text"); err == nil {
+		t.Errorf("GetConfig(): expected err, got nil.")
+	}
+	if err := conn.Ping(ctx); err == nil {
+		t.Errorf("Ping(): expected err, got nil.")
+	}
+	if _, err := conn.Exec(ctx, "<rpc/>"); err == nil {
+		t.Errorf("Exec(): expected err, got nil.")
+	}
+	conn.Close()
+	p.mu.Lock()
+	idle := len(p.hosts["host"].idle)
+	p.mu.Unlock()
+	if idle != 0 {
+		t.Errorf("Close() gave a broken session back to the pool")
+	}
+	c.mustFail = false
+
+	// The slot has been freed.
+	conn, err := p.NewSession(ctx, "host", &credentials.Config{})
+	if err != nil {
+		t.Fatalf("NewSession() returned err: %v", err)
+	}
+	conn.Close()
+}
+
+func Test_poolConnector_putAfterClose(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{IdleTimeout: time.Minute})
+
+	conn, _ := p.NewSession(ctx, "host", &credentials.Config{})
+	p.Close()
+	conn.Close()
+	if !c.sessions[0].closed {
+		t.Errorf("Close() gave a session back to a closed pool")
+	}
+}
+
+func Test_poolConnector_contextCanceled(t *testing.T) {
+	c := &countingConnector{}
+	p := newPoolConnector(c, PoolConfig{
+		MaxSessionsPerHost: 1,
+		IdleTimeout:        time.Minute,
+	})
+	defer p.Close()
+
+	conn, _ := p.NewSession(ctx, "host", &credentials.Config{})
+	defer conn.Close()
+
+	// Waiting for a free slot is interrupted by the context.
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.NewSession(waitCtx, "host",
+		&credentials.Config{}); err != context.DeadlineExceeded {
+		t.Errorf("NewSession() returned %v, want %v", err,
+			context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,205 @@
+package netconf
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JunOS RPCs used to replace the configuration of a switch.
+const (
+	rpcOpenPrivate  = "<open-configuration><private/></open-configuration>"
+	rpcClosePrivate = "<close-configuration/>"
+	rpcGetCommitted = `<get-configuration database="committed" format="text"/>`
+	rpcCompare      = `<get-configuration compare="rollback" rollback="0" format="text"/>`
+	rpcCommitCheck  = "<commit-configuration><check/></commit-configuration>"
+	rpcCommit       = "<commit-configuration/>"
+)
+
+// closeTimeout is the maximum time allowed to discard a private candidate
+// configuration, even if the remediation was canceled.
+const closeTimeout = 10 * time.Second
+
+// RemediateOptions configures how a configuration is pushed to a switch.
+type RemediateOptions struct {
+	// DryRun loads and checks the configuration, then discards it
+	// without committing.
+	DryRun bool
+
+	// ConfirmMinutes is the timeout of the "commit confirmed": if the
+	// commit is not confirmed by then, the switch rolls back to the
+	// previous configuration. Values lower than 1 are treated as 1.
+	ConfirmMinutes int
+
+	// Comment is the log message of the commit.
+	Comment string
+
+	// Rules are the rules the configuration was compared with. The
+	// statements they ignore or mask are kept as found on the switch: see
+	// Candidate. If nil, the default rules are used.
+	Rules *Rules
+}
+
+// Remediation is the outcome of pushing a configuration to a switch.
+type Remediation struct {
+	// Diff is the difference between the running and the candidate
+	// configuration, as reported by the switch.
+	Diff string `json:"diff"`
+	// Committed is true if the candidate was committed with
+	// "commit confirmed".
+	Committed bool `json:"committed"`
+	// Confirmed is true if the commit was then confirmed from a new
+	// session, proving the switch is still reachable.
+	Confirmed bool `json:"confirmed"`
+}
+
+// Remediate replaces the configuration of a JunOS switch with the provided
+// one, in the JunOS text format, except for the statements ignored or masked
+// by opts.Rules, which are kept from the committed configuration. The result
+// is loaded, as with "load override", into a private candidate, which is
+// validated with "commit check" and committed with "commit confirmed". The
+// commit is then confirmed from a new session, so that the switch rolls back
+// by itself if the new configuration makes it unreachable.
+//
+// Remediate always uses dedicated sessions, even if the client is pooled,
+// since the candidate configuration is bound to the session where it was
+// loaded.
+func (c Client) Remediate(ctx context.Context, hostname, config string, opts RemediateOptions) (*Remediation, error) {
+	conn, err := c.dedicatedSession(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Exec(ctx, rpcOpenPrivate); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// The private candidate is discarded even if ctx is canceled.
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		conn.Exec(ctx, rpcClosePrivate)
+	}()
+
+	reply, err := conn.Exec(ctx, rpcGetCommitted)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := Candidate(config, configurationText(reply), opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := execExpect(ctx, conn, loadOverrideRPC(candidate), "load-success"); err != nil {
+		return nil, fmt.Errorf("cannot load configuration: %v", err)
+	}
+	reply, err = conn.Exec(ctx, rpcCompare)
+	if err != nil {
+		return nil, err
+	}
+	result := &Remediation{Diff: configurationOutput(reply)}
+	if err := execExpect(ctx, conn, rpcCommitCheck, "commit-check-success"); err != nil {
+		return result, fmt.Errorf("commit check failed: %v", err)
+	}
+	if opts.DryRun || result.Diff == "" {
+		return result, nil
+	}
+
+	if err := execExpect(ctx, conn, commitConfirmedRPC(opts), "commit-success"); err != nil {
+		return result, fmt.Errorf("commit failed: %v", err)
+	}
+	result.Committed = true
+
+	confirm, err := c.dedicatedSession(ctx, hostname)
+	if err != nil {
+		return result, fmt.Errorf("cannot confirm commit: %v", err)
+	}
+	defer confirm.Close()
+	if err := execExpect(ctx, confirm, rpcCommit, "commit-success"); err != nil {
+		return result, fmt.Errorf("cannot confirm commit: %v", err)
+	}
+	result.Confirmed = true
+	return result, nil
+}
+
+// dedicatedSession returns a new session for the host that is not shared
+// with other callers, bypassing the pool if there is one.
+func (c Client) dedicatedSession(ctx context.Context, hostname string) (connection, error) {
+	connector := c.connector
+	if p, ok := connector.(*poolConnector); ok {
+		connector = p.connector
+	}
+	return c.session(ctx, connector, hostname)
+}
+
+// execExpect runs the RPC and returns an error unless the reply contains the
+// specified element. JunOS reports some errors, e.g. syntax errors while
+// loading a configuration, inside the reply rather than as an <rpc-error>.
+func execExpect(ctx context.Context, conn connection, rpc, element string) error {
+	reply, err := conn.Exec(ctx, rpc)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(reply, "<"+element) {
+		return fmt.Errorf("unexpected reply: %s", strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// loadOverrideRPC loads the configuration as with "load override", replacing
+// the whole candidate: "load replace" only replaces the statements tagged with
+// "replace:", merging the others, so that statements missing from the
+// configuration would be kept.
+func loadOverrideRPC(config string) string {
+	var b strings.Builder
+	b.WriteString(`<load-configuration action="override" format="text"><configuration-text>`)
+	xml.EscapeText(&b, []byte(config))
+	b.WriteString("</configuration-text></load-configuration>")
+	return b.String()
+}
+
+func commitConfirmedRPC(opts RemediateOptions) string {
+	minutes := opts.ConfirmMinutes
+	if minutes < 1 {
+		minutes = 1
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<commit-configuration><confirmed/><confirm-timeout>%d</confirm-timeout>",
+		minutes)
+	if opts.Comment != "" {
+		b.WriteString("<log>")
+		xml.EscapeText(&b, []byte(opts.Comment))
+		b.WriteString("</log>")
+	}
+	b.WriteString("</commit-configuration>")
+	return b.String()
+}
+
+// configurationText extracts the text of the <configuration-text> element
+// returned when reading a configuration in the text format. If the reply is
+// not such an element, it is returned as is.
+func configurationText(reply string) string {
+	var config struct {
+		XMLName xml.Name
+		Text    string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal([]byte(reply), &config); err != nil ||
+		config.XMLName.Local != "configuration-text" {
+		return reply
+	}
+	return config.Text
+}
+
+// configurationOutput extracts the text of a <configuration-output>
+// element, as returned when comparing configurations. If the reply cannot be
+// parsed, it is returned as is.
+func configurationOutput(reply string) string {
+	var info struct {
+		Output string `xml:"configuration-output"`
+	}
+	if err := xml.Unmarshal([]byte(reply), &info); err != nil {
+		return strings.TrimSpace(reply)
+	}
+	return strings.TrimSpace(info.Output)
+}
@@ -0,0 +1,247 @@
+package netconf
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+// scriptedConnector returns connections replying to each RPC with the reply
+// of the first matching prefix in replies, and records the RPCs of all of
+// them.
+type scriptedConnector struct {
+	replies map[string]string
+	fail    map[string]bool
+
+	mu       sync.Mutex
+	rpcs     []string
+	sessions int
+	// loaded is the last configuration loaded.
+	loaded string
+}
+
+func (c *scriptedConnector) NewSession(context.Context, string, credentials.Provider) (connection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions++
+	return &scriptedConnection{mockConnection: &mockConnection{}, c: c}, nil
+}
+
+type scriptedConnection struct {
+	*mockConnection
+	c *scriptedConnector
+}
+
+func (s *scriptedConnection) Exec(_ context.Context, rpc string) (string, error) {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if strings.HasPrefix(rpc, "<load-configuration") {
+		s.c.loaded = rpc
+	}
+	for prefix, reply := range s.c.replies {
+		if strings.HasPrefix(rpc, prefix) {
+			s.c.rpcs = append(s.c.rpcs, prefix)
+			if s.c.fail[prefix] {
+				return "", errors.New("rpc error")
+			}
+			return reply, nil
+		}
+	}
+	s.c.rpcs = append(s.c.rpcs, rpc)
+	return "<ok/>", nil
+}
+
+func newScriptedConnector() *scriptedConnector {
+	return &scriptedConnector{
+		replies: map[string]string{
+			rpcGetCommitted: "<configuration-text>system {\n" +
+				"    host-name foo;\n" +
+				"    root-authentication {\n" +
+				"        encrypted-password \"$6$running\";\n" +
+				"    }\n" +
+				"}\n</configuration-text>",
+			"<load-configuration": "<load-configuration-results><load-success/></load-configuration-results>",
+			rpcCompare: "<configuration-information><configuration-output>\n" +
+				"[edit system]\n-  host-name foo;\n+  host-name bar;\n" +
+				"</configuration-output></configuration-information>",
+			rpcCommitCheck:              "<commit-results><routing-engine><commit-check-success/></routing-engine></commit-results>",
+			"<commit-configuration><co": "<commit-results><routing-engine><commit-success/></routing-engine></commit-results>",
+			rpcCommit:                   "<commit-results><routing-engine><commit-success/></routing-engine></commit-results>",
+		},
+		fail: map[string]bool{},
+	}
+}
+
+func TestClient_Remediate(t *testing.T) {
+	const diff = "[edit system]\n-  host-name foo;\n+  host-name bar;"
+	load := "<load-configuration"
+	confirmed := "<commit-configuration><co"
+	tests := []struct {
+		name     string
+		opts     RemediateOptions
+		setup    func(c *scriptedConnector)
+		want     *Remediation
+		wantErr  bool
+		wantRPCs []string
+	}{
+		{
+			name: "commit",
+			opts: RemediateOptions{ConfirmMinutes: 5},
+			want: &Remediation{Diff: diff, Committed: true, Confirmed: true},
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcCompare, rpcCommitCheck,
+				confirmed, rpcCommit, rpcClosePrivate},
+		},
+		{
+			name: "dry-run",
+			opts: RemediateOptions{DryRun: true},
+			want: &Remediation{Diff: diff},
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcCompare, rpcCommitCheck,
+				rpcClosePrivate},
+		},
+		{
+			name: "no-changes",
+			setup: func(c *scriptedConnector) {
+				c.replies[rpcCompare] = "<configuration-information><configuration-output>\n</configuration-output></configuration-information>"
+			},
+			want: &Remediation{},
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcCompare, rpcCommitCheck,
+				rpcClosePrivate},
+		},
+		{
+			name: "get-committed-error",
+			setup: func(c *scriptedConnector) {
+				c.fail[rpcGetCommitted] = true
+			},
+			wantErr:  true,
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, rpcClosePrivate},
+		},
+		{
+			name: "load-error",
+			setup: func(c *scriptedConnector) {
+				c.replies[load] = "<load-configuration-results><load-error-count>1</load-error-count></load-configuration-results>"
+			},
+			wantErr:  true,
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcClosePrivate},
+		},
+		{
+			name: "check-error",
+			setup: func(c *scriptedConnector) {
+				c.replies[rpcCommitCheck] = "<commit-results><xnm:error/></commit-results>"
+			},
+			want:    &Remediation{Diff: diff},
+			wantErr: true,
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcCompare, rpcCommitCheck,
+				rpcClosePrivate},
+		},
+		{
+			name: "confirm-error",
+			setup: func(c *scriptedConnector) {
+				c.fail[rpcCommit] = true
+			},
+			want:    &Remediation{Diff: diff, Committed: true},
+			wantErr: true,
+			wantRPCs: []string{rpcOpenPrivate, rpcGetCommitted, load, rpcCompare, rpcCommitCheck,
+				confirmed, rpcCommit, rpcClosePrivate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connector := newScriptedConnector()
+			if tt.setup != nil {
+				tt.setup(connector)
+			}
+			// Remediation bypasses the pool.
+			pool := newPoolConnector(connector, PoolConfig{IdleTimeout: time.Minute})
+			defer pool.Close()
+			c := Client{auth: &credentials.Config{}, connector: pool}
+
+			got, err := c.Remediate(context.Background(), "s1-abc01", "system {}", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Remediate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Remediate() = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(connector.rpcs, tt.wantRPCs) {
+				t.Errorf("Remediate() sent %v, want %v", connector.rpcs, tt.wantRPCs)
+			}
+		})
+	}
+}
+
+func TestClient_RemediateCandidate(t *testing.T) {
+	connector := newScriptedConnector()
+	c := Client{auth: &credentials.Config{}, connector: connector}
+
+	// Masked statements are kept as found on the switch.
+	expected := "system {\n    host-name bar;\n    root-authentication {\n" +
+		"        encrypted-password \"$6$expected\";\n    }\n}"
+	if _, err := c.Remediate(context.Background(), "s1-abc01", expected, RemediateOptions{}); err != nil {
+		t.Fatalf("Remediate() error = %v", err)
+	}
+	// The whole candidate is replaced, so that statements missing from the
+	// expected configuration are removed.
+	if !strings.HasPrefix(connector.loaded,
+		`<load-configuration action="override" format="text"><configuration-text>system {`) {
+		t.Errorf("Remediate() loaded %q, want an override", connector.loaded)
+	}
+	if !strings.Contains(connector.loaded, "$6$running") ||
+		strings.Contains(connector.loaded, "$6$expected") {
+		t.Errorf("Remediate() loaded %q, want the running password", connector.loaded)
+	}
+
+	// Placeholders of masked values are never pushed.
+	_, err := c.Remediate(context.Background(), "s1-abc01",
+		"snmp {\n    contact \"<masked>\";\n}", RemediateOptions{})
+	if !errors.Is(err, ErrMaskedValue) {
+		t.Errorf("Remediate() error = %v, want %v", err, ErrMaskedValue)
+	}
+}
+
+func TestClient_RemediateSessionError(t *testing.T) {
+	c := Client{auth: &credentials.Config{}, connector: mockConnector{mustFail: true}}
+	if _, err := c.Remediate(context.Background(), "s1-abc01", "", RemediateOptions{}); err == nil {
+		t.Errorf("Remediate(): expected err, got nil.")
+	}
+	c.connector = mockConnector{mustFailConn: true}
+	if _, err := c.Remediate(context.Background(), "s1-abc01", "", RemediateOptions{}); err == nil {
+		t.Errorf("Remediate(): expected err, got nil.")
+	}
+}
+
+func Test_loadOverrideRPC(t *testing.T) {
+	got := loadOverrideRPC("system { host-name <a&b>; }")
+	want := `<load-configuration action="override" format="text"><configuration-text>` +
+		"system { host-name &lt;a&amp;b&gt;; }</configuration-text></load-configuration>"
+	if got != want {
+		t.Errorf("loadOverrideRPC() = %q, want %q", got, want)
+	}
+}
+
+func Test_commitConfirmedRPC(t *testing.T) {
+	tests := []struct {
+		opts RemediateOptions
+		want string
+	}{
+		{
+			opts: RemediateOptions{},
+			want: "<commit-configuration><confirmed/><confirm-timeout>1</confirm-timeout></commit-configuration>",
+		},
+		{
+			opts: RemediateOptions{ConfirmMinutes: 10, Comment: "fix <drift>"},
+			want: "<commit-configuration><confirmed/><confirm-timeout>10</confirm-timeout>" +
+				"<log>fix &lt;drift&gt;</log></commit-configuration>",
+		},
+	}
+	for _, tt := range tests {
+		if got := commitConfirmedRPC(tt.opts); got != tt.want {
+			t.Errorf("commitConfirmedRPC() = %q, want %q", got, tt.want)
+		}
+	}
+}
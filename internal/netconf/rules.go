@@ -0,0 +1,224 @@
+package netconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// maskedValue replaces the values of masked statements.
+const maskedValue = "<masked>"
+
+// defaultRules are always applied, regardless of the configured rules.
+var defaultRules = Rules{
+	// The switch will always report the current version number at the
+	// beginning of the config file, but the version is not part of the
+	// config file itself.
+	Ignore: []string{"version"},
+	// TODO: once we start pre-configuring the switch with a random password,
+	// we can remove this rule so that the actual passwords are compared.
+	Mask: []string{"** encrypted-password"},
+}
+
+// Rules describes how switch configurations are normalized before being
+// compared. A rules file is a JSON document like the following:
+//
+//	{
+//	  "replace": [{"pattern": "description \".*\";", "replacement": ""}],
+//	  "ignore": ["system ntp"],
+//	  "mask": ["snmp location"],
+//	  "sites": {
+//	    "abc01": {"ignore": ["snmp"]}
+//	  }
+//	}
+//
+// Paths are space-separated lists of statement words. Each element can be a
+// pattern matching a single word, where "*" matches any sequence of
+// characters and "?" matches a single character (e.g. "xe-*"), or "**",
+// matching any number of words. A path matches a statement if it matches the
+// beginning of the words of the statement and all of its parents.
+type Rules struct {
+	// Replace is a list of regular expression replacements applied to the
+	// configuration text before it is parsed.
+	Replace []Replacement `json:"replace"`
+
+	// Ignore is a list of paths of statements to remove, with all their
+	// children.
+	Ignore []string `json:"ignore"`
+
+	// Mask is a list of paths of statements whose remaining words are
+	// replaced by a placeholder.
+	Mask []string `json:"mask"`
+
+	// Sites contains additional rules for specific sites.
+	Sites map[string]*Rules `json:"sites"`
+}
+
+// Replacement is a regular expression replacement. The replacement string
+// can refer to submatches, as in regexp.Regexp.ReplaceAllString.
+type Replacement struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+
+	re *regexp.Regexp
+}
+
+// LoadRules reads a rules file from disk.
+func LoadRules(filename string) (*Rules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// ParseRules parses a JSON rules document and validates its patterns.
+func ParseRules(data []byte) (*Rules, error) {
+	r := &Rules{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// compile compiles the regular expressions of these rules and of every site.
+func (r *Rules) compile() error {
+	for i := range r.Replace {
+		re, err := regexp.Compile(r.Replace[i].Pattern)
+		if err != nil {
+			return err
+		}
+		r.Replace[i].re = re
+	}
+	for site, s := range r.Sites {
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("site %s: %w", site, err)
+		}
+	}
+	return nil
+}
+
+// ForSite returns the rules to apply to the specified site: the default
+// rules, followed by the global ones and the site-specific ones. It can be
+// called on a nil *Rules, in which case only the default rules are returned.
+func (r *Rules) ForSite(site string) *Rules {
+	res := &Rules{}
+	res.add(&defaultRules)
+	if r != nil {
+		res.add(r)
+		if s, ok := r.Sites[site]; ok {
+			res.add(s)
+		}
+	}
+	return res
+}
+
+// add appends the rules in other to r, ignoring other's sites.
+func (r *Rules) add(other *Rules) {
+	r.Replace = append(r.Replace, other.Replace...)
+	r.Ignore = append(r.Ignore, other.Ignore...)
+	r.Mask = append(r.Mask, other.Mask...)
+}
+
+// replace applies the regular expression replacements to the configuration
+// text.
+func (r *Rules) replace(config string) string {
+	for _, rep := range r.Replace {
+		config = rep.re.ReplaceAllString(config, rep.Replacement)
+	}
+	return config
+}
+
+// apply removes the ignored statements and masks the values of masked
+// statements among the children of n. prefix contains the words of n and all
+// of its parents.
+func (r *Rules) apply(n *Node, prefix []string) {
+	children := n.Children[:0]
+	for _, c := range n.Children {
+		words := append(prefix[:len(prefix):len(prefix)], c.Words...)
+		if r.matches(r.Ignore, words, len(prefix), len(words)) > 0 {
+			continue
+		}
+		if l := r.matches(r.Mask, words, len(prefix), len(words)-1); l > 0 {
+			c.Words = append(c.Words[:l-len(prefix):l-len(prefix)], maskedValue)
+			words = words[:l+1]
+			words[l] = maskedValue
+		}
+		r.apply(c, words)
+		children = append(children, c)
+	}
+	n.Children = children
+}
+
+// matches returns the shortest length l, with min < l <= max, such that one
+// of the paths matches the first l words. It returns zero if there is none.
+func (r *Rules) matches(paths []string, words []string, min, max int) int {
+	best := 0
+	for _, p := range paths {
+		for _, l := range matchPrefix(strings.Fields(p), words) {
+			if l > min && l <= max && (best == 0 || l < best) {
+				best = l
+			}
+		}
+	}
+	return best
+}
+
+// matchPrefix returns the lengths of the prefixes of words matched by
+// pattern.
+func matchPrefix(pattern, words []string) []int {
+	if len(pattern) == 0 {
+		return []int{0}
+	}
+	var res []int
+	if pattern[0] == "**" {
+		for i := 0; i <= len(words); i++ {
+			for _, l := range matchPrefix(pattern[1:], words[i:]) {
+				res = append(res, i+l)
+			}
+		}
+		return res
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	if !matchWord(pattern[0], words[0]) {
+		return nil
+	}
+	for _, l := range matchPrefix(pattern[1:], words[1:]) {
+		res = append(res, l+1)
+	}
+	return res
+}
+
+// matchWord returns true if the word matches the pattern, where "*" matches
+// any sequence of characters and "?" matches any single character.
+func matchWord(pattern, word string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(word); i >= 0; i-- {
+				if matchWord(pattern[1:], word[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(word) == 0 {
+				return false
+			}
+		default:
+			if len(word) == 0 || word[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		word = word[1:]
+	}
+	return len(word) == 0
+}
@@ -0,0 +1,183 @@
+package netconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadRules(t *testing.T) {
+	r, err := LoadRules("testdata/rules.json")
+	if err != nil {
+		t.Fatalf("LoadRules() returned err: %v", err)
+	}
+	if len(r.Replace) != 1 || r.Replace[0].re == nil {
+		t.Errorf("LoadRules() did not compile the replacements: %+v", r.Replace)
+	}
+	if !reflect.DeepEqual(r.Ignore, []string{"system ntp"}) ||
+		!reflect.DeepEqual(r.Mask, []string{"snmp location"}) {
+		t.Errorf("LoadRules() returned unexpected rules: %+v", r)
+	}
+	if _, ok := r.Sites["abc01"]; !ok {
+		t.Errorf("LoadRules() did not load the site rules")
+	}
+
+	if _, err := LoadRules("testdata/notfound.json"); err == nil {
+		t.Errorf("LoadRules(): expected err, got nil.")
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `{"ignore": ["system *"], "mask": ["** secret"]}`,
+		},
+		{
+			name:    "invalid-json",
+			data:    `{`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid-regexp",
+			data:    `{"replace": [{"pattern": "("}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid-site",
+			data:    `{"sites": {"abc01": {"replace": [{"pattern": "("}]}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseRules([]byte(tt.data)); (err != nil) != tt.wantErr {
+				t.Errorf("ParseRules() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRules_ForSite(t *testing.T) {
+	var nilRules *Rules
+	if got := nilRules.ForSite("abc01"); !reflect.DeepEqual(got.Ignore,
+		defaultRules.Ignore) || !reflect.DeepEqual(got.Mask, defaultRules.Mask) {
+		t.Errorf("ForSite() on nil rules = %+v, want the default rules", got)
+	}
+
+	r, err := LoadRules("testdata/rules.json")
+	if err != nil {
+		t.Fatalf("LoadRules() returned err: %v", err)
+	}
+	want := []string{"version", "system ntp", "interfaces xe-*"}
+	if got := r.ForSite("abc01"); !reflect.DeepEqual(got.Ignore, want) {
+		t.Errorf("ForSite() ignore = %v, want %v", got.Ignore, want)
+	}
+	want = []string{"version", "system ntp"}
+	if got := r.ForSite("xyz01"); !reflect.DeepEqual(got.Ignore, want) {
+		t.Errorf("ForSite() ignore = %v, want %v", got.Ignore, want)
+	}
+}
+
+func TestRules_apply(t *testing.T) {
+	r, err := LoadRules("testdata/rules.json")
+	if err != nil {
+		t.Fatalf("LoadRules() returned err: %v", err)
+	}
+
+	config := `version 1.0;
+system {
+    host-name s1;
+    ntp {
+        server 1.2.3.4;
+    }
+    root-authentication {
+        encrypted-password "secret";
+    }
+}
+snmp {
+    location "Somewhere, Earth";
+    community public;
+}
+interfaces {
+    xe-0/0/1 {
+        description "uplink";
+        mtu 9216;
+    }
+    ge-0/0/1 {
+        description "pdu";
+        disable;
+    }
+}`
+	want := `interfaces {
+    ge-0/0/1 {
+        disable;
+    }
+}
+snmp {
+    community public;
+    location <masked>;
+}
+system {
+    host-name s1;
+    root-authentication {
+        encrypted-password <masked>;
+    }
+}`
+	if got := Text.canonical(config, r.ForSite("abc01")).String(); got != want {
+		t.Errorf("canonical() = \n%s\nwant\n%s", got, want)
+	}
+}
+
+func Test_matchPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		words   string
+		want    []int
+	}{
+		{pattern: "", words: "a b", want: []int{0}},
+		{pattern: "a", words: "a b", want: []int{1}},
+		{pattern: "a b c", words: "a b", want: nil},
+		{pattern: "b", words: "a b", want: nil},
+		{pattern: "* b", words: "a b", want: []int{2}},
+		{pattern: "** b", words: "a b b", want: []int{2, 3}},
+		{pattern: "a **", words: "a b", want: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := matchPrefix(strings.Fields(tt.pattern), strings.Fields(tt.words))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matchPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchWord(t *testing.T) {
+	tests := []struct {
+		pattern string
+		word    string
+		want    bool
+	}{
+		{pattern: "", word: "", want: true},
+		{pattern: "a", word: "a", want: true},
+		{pattern: "a", word: "ab", want: false},
+		{pattern: "xe-*", word: "xe-0/0/1", want: true},
+		{pattern: "xe-*", word: "ge-0/0/1", want: false},
+		{pattern: "*/1", word: "xe-0/0/1", want: true},
+		{pattern: "?e-0/0/?", word: "ge-0/0/1", want: true},
+		{pattern: "?", word: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := matchWord(tt.pattern, tt.word); got != tt.want {
+				t.Errorf("matchWord(%q, %q) = %v, want %v", tt.pattern, tt.word,
+					got, tt.want)
+			}
+		})
+	}
+}
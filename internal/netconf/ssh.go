@@ -0,0 +1,155 @@
+package netconf
+
+import (
+	"net"
+	"sync"
+
+	"github.com/Juniper/go-netconf/netconf"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSSHSession establishes a NETCONF session over an SSH connection on conn.
+//
+// The SSH library reports handshake errors as strings only, so the handshake
+// is observed to tell why it failed: errors wrap ErrHostKeyRejected if the
+// host key was not trusted, the network error if the connection failed during
+// the key exchange, ErrKeyExchange if it failed otherwise before the host key
+// was received and ErrAuth after.
+func newSSHSession(conn net.Conn, config *ssh.ClientConfig) (*netconf.Session, error) {
+	h := &handshakeConn{Conn: conn}
+	observed := *config
+	observed.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := config.HostKeyCallback(hostname, remote, key)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.kexDone = true
+		h.hostKeyErr = err
+		return err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(h, conn.RemoteAddr().String(), &observed)
+	if err != nil {
+		return nil, &handshakeError{msg: err.Error(), cause: h.cause()}
+	}
+
+	t, err := newSSHTransport(ssh.NewClient(c, chans, reqs))
+	if err != nil {
+		return nil, err
+	}
+	return netconf.NewSession(t), nil
+}
+
+// handshakeConn is a connection recording the progress of an SSH handshake.
+type handshakeConn struct {
+	net.Conn
+
+	mu sync.Mutex
+	// kexDone is true once the key exchange completed and the host key was
+	// received.
+	kexDone bool
+	// hostKeyErr is the error returned by the host key callback.
+	hostKeyErr error
+	// netErr is the first network error reading or writing.
+	netErr error
+}
+
+func (h *handshakeConn) Read(b []byte) (int, error) {
+	n, err := h.Conn.Read(b)
+	h.record(err)
+	return n, err
+}
+
+func (h *handshakeConn) Write(b []byte) (int, error) {
+	n, err := h.Conn.Write(b)
+	h.record(err)
+	return n, err
+}
+
+// record records the first network error during the key exchange. Errors
+// once the host key was received are not recorded, since switches close or
+// reset the connection after refusing the credentials.
+func (h *handshakeConn) record(err error) {
+	netErr, ok := err.(net.Error)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.netErr == nil && !h.kexDone {
+		h.netErr = netErr
+	}
+}
+
+// cause returns the reason why the handshake failed.
+func (h *handshakeConn) cause() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case h.hostKeyErr != nil:
+		return h.hostKeyErr
+	case h.netErr != nil:
+		return h.netErr
+	case !h.kexDone:
+		return ErrKeyExchange
+	default:
+		return ErrAuth
+	}
+}
+
+// handshakeError is a failed SSH handshake. Its message is the one of the
+// SSH library, while it unwraps to the cause of the failure.
+type handshakeError struct {
+	msg   string
+	cause error
+}
+
+func (e *handshakeError) Error() string {
+	return e.msg
+}
+
+func (e *handshakeError) Unwrap() error {
+	return e.cause
+}
+
+// sshTransport is a NETCONF transport over an established SSH connection.
+// The embedded TransportSSH only provides the NETCONF framing, since its
+// SSH client cannot be set.
+type sshTransport struct {
+	*netconf.TransportSSH
+	client  *ssh.Client
+	session *ssh.Session
+}
+
+// newSSHTransport starts the NETCONF subsystem on the client. The client is
+// closed if it fails.
+func newSSHTransport(client *ssh.Client) (*sshTransport, error) {
+	t := &sshTransport{TransportSSH: &netconf.TransportSSH{}, client: client}
+	err := t.setup()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *sshTransport) setup() error {
+	var err error
+	if t.session, err = t.client.NewSession(); err != nil {
+		return err
+	}
+	w, err := t.session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := t.session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	t.ReadWriteCloser = netconf.NewReadWriteCloser(r, w)
+	return t.session.RequestSubsystem("netconf")
+}
+
+// Close closes the SSH session and the connection.
+func (t *sshTransport) Close() error {
+	t.session.Close()
+	return t.client.Close()
+}
@@ -0,0 +1,133 @@
+package netconf
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer accepts a single SSH connection with the password "secret" and
+// replies to the NETCONF subsystem request with a hello message.
+func sshServer(t *testing.T, kex []string) string {
+	_, priv, err := ed25519.GenerateKey(nil)
+	rtx.Must(err, "Cannot generate host key")
+	signer, err := ssh.NewSignerFromKey(priv)
+	rtx.Must(err, "Cannot create signer")
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	config.KeyExchanges = kex
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	rtx.Must(err, "Cannot listen")
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChan := range chans {
+			ch, reqs, err := newChan.Accept()
+			if err != nil {
+				return
+			}
+			for req := range reqs {
+				req.Reply(req.Type == "subsystem", nil)
+				ch.Write([]byte(`<hello xmlns="urn:ietf:params:xml:ns:netconf:base:1.0"><session-id>42</session-id></hello>]]>]]>`))
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func Test_newSSHSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		kex      []string
+		hostKey  ssh.HostKeyCallback
+		want     error
+		reason   string
+	}{
+		{
+			name:     "success",
+			password: "secret",
+		},
+		{
+			name:     "auth",
+			password: "wrong",
+			want:     ErrAuth,
+			reason:   ReasonAuth,
+		},
+		{
+			name:     "key-exchange",
+			password: "secret",
+			kex:      []string{"diffie-hellman-group1-sha1"},
+			want:     ErrKeyExchange,
+			reason:   ReasonKeyExchange,
+		},
+		{
+			name:     "host-key",
+			password: "secret",
+			hostKey: func(string, net.Addr, ssh.PublicKey) error {
+				return fmt.Errorf("%w: %w", ErrHostKeyRejected, errors.New("mismatch"))
+			},
+			want:   ErrHostKeyRejected,
+			reason: ReasonHostKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := sshServer(t, []string{"curve25519-sha256@libssh.org"})
+			config := &ssh.ClientConfig{
+				User:            "admin",
+				Auth:            []ssh.AuthMethod{ssh.Password(tt.password)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			}
+			config.KeyExchanges = tt.kex
+			if tt.hostKey != nil {
+				config.HostKeyCallback = tt.hostKey
+			}
+			conn, err := net.Dial("tcp", addr)
+			rtx.Must(err, "Cannot connect")
+			defer conn.Close()
+
+			s, err := newSSHSession(conn, config)
+			if tt.want == nil {
+				if err != nil || s.SessionID != 42 {
+					t.Fatalf("newSSHSession() = %+v, %v", s, err)
+				}
+				s.Close()
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("newSSHSession() error = %v, want %v", err, tt.want)
+			}
+			if got := Reason(classify(err, ReasonUnknown)); got != tt.reason {
+				t.Errorf("Reason() = %q, want %q", got, tt.reason)
+			}
+			// The message of the SSH library is kept.
+			if !strings.HasPrefix(err.Error(), "ssh: handshake failed") {
+				t.Errorf("newSSHSession() error = %q", err)
+			}
+		})
+	}
+}
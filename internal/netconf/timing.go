@@ -0,0 +1,32 @@
+package netconf
+
+import (
+	"context"
+	"time"
+)
+
+// Timing records how long the phases of the calls to a switch took. Calls
+// made with a context returned by WithTiming add their durations to it.
+type Timing struct {
+	// Connect is the time spent establishing (or reusing) a session.
+	Connect time.Duration
+	// RPC is the time spent waiting for the replies.
+	RPC time.Duration
+}
+
+type timingKey struct{}
+
+// WithTiming returns a context making the Client record the duration of
+// each phase of its calls in t. t must not be shared by concurrent calls.
+func WithTiming(ctx context.Context, t *Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// timing returns the Timing of the context, or a discarded one if there is
+// none, so that callers can always record durations.
+func timing(ctx context.Context) *Timing {
+	if t, ok := ctx.Value(timingKey{}).(*Timing); ok {
+		return t
+	}
+	return &Timing{}
+}
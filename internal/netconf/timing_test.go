@@ -0,0 +1,50 @@
+package netconf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/m-lab/switch-monitoring/internal/credentials"
+)
+
+// slowConnector is a mockConnector taking some time to establish sessions.
+type slowConnector struct {
+	mockConnector
+	delay time.Duration
+}
+
+func (c slowConnector) NewSession(ctx context.Context, host string, auth credentials.Provider) (connection, error) {
+	time.Sleep(c.delay)
+	return c.mockConnector.NewSession(ctx, host, auth)
+}
+
+func TestWithTiming(t *testing.T) {
+	c := Client{
+		auth:      &credentials.Config{},
+		connector: slowConnector{delay: 10 * time.Millisecond},
+	}
+
+	var timing Timing
+	ctx := WithTiming(context.Background(), &timing)
+	if _, err := c.GetConfig(ctx, "s1-abc01"); err != nil {
+		t.Fatalf("GetConfig() returned err: %v", err)
+	}
+	if timing.Connect < 10*time.Millisecond || timing.RPC <= 0 {
+		t.Errorf("GetConfig() recorded %+v", timing)
+	}
+
+	// Durations are added up across calls.
+	connect := timing.Connect
+	if _, err := c.Exec(ctx, "s1-abc01", "<rpc/>"); err != nil {
+		t.Fatalf("Exec() returned err: %v", err)
+	}
+	if timing.Connect < connect+10*time.Millisecond {
+		t.Errorf("Exec() recorded %+v", timing)
+	}
+
+	// Calls without a Timing in the context work as usual.
+	if _, err := c.GetConfig(context.Background(), "s1-abc01"); err != nil {
+		t.Errorf("GetConfig() returned err: %v", err)
+	}
+}
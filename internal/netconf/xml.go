@@ -0,0 +1,76 @@
+package netconf
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// xmlWrappers are the elements wrapping the configuration in NETCONF
+// messages. They are removed from the parsed tree.
+var xmlWrappers = map[string]bool{
+	"rpc-reply": true,
+	"data":      true,
+	"config":    true,
+}
+
+// ParseXML parses an XML configuration, as returned by a NETCONF
+// <get-config>, into a tree of statements, so that it can be compared like a
+// JunOS configuration:
+//
+//   - Elements containing other elements become containers, whose only word
+//     is the element's local name. List entries, i.e. elements whose first
+//     child is a <name> leaf, also have the name as their second word.
+//   - Other elements become statements, with the element's local name and
+//     its trimmed text, if any, as words.
+//   - Namespaces, attributes, comments and processing instructions are
+//     ignored, as well as the <rpc-reply>, <data> and <config> wrappers.
+//
+// Like Parse, ParseXML is lenient: if the document is malformed, the
+// statements parsed up to the error are returned.
+func ParseXML(config string) *Node {
+	root := &Node{Container: true}
+	stack := []*Node{root}
+	var text strings.Builder
+
+	d := xml.NewDecoder(strings.NewReader(config))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &Node{Words: []string{t.Name.Local}}
+			parent := stack[len(stack)-1]
+			parent.Container = true
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				continue
+			}
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !n.Container {
+				if v := strings.TrimSpace(text.String()); v != "" {
+					n.Words = append(n.Words, v)
+				}
+			} else if k := n.Children[0]; !k.Container &&
+				len(k.Words) == 2 && k.Words[0] == "name" {
+				n.Words = append(n.Words, k.Words[1])
+			}
+			text.Reset()
+		}
+	}
+
+	// Remove the NETCONF wrappers around the configuration.
+	for len(root.Children) == 1 && root.Children[0].Container &&
+		xmlWrappers[root.Children[0].Words[0]] {
+		root = &Node{Container: true, Children: root.Children[0].Children}
+	}
+	return root
+}
@@ -0,0 +1,73 @@
+package netconf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseXML(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   *Node
+	}{
+		{
+			name:   "empty",
+			config: "",
+			want:   &Node{Container: true},
+		},
+		{
+			name: "rpc-reply",
+			config: `<rpc-reply xmlns="urn:ietf:params:xml:ns:netconf:base:1.0">
+<data>
+  <system xmlns="urn:example:system">
+    <!-- comment -->
+    <hostname>s1-abc01</hostname>
+    <ssh/>
+  </system>
+</data>
+</rpc-reply>`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"system"}, Container: true, Children: []*Node{
+					{Words: []string{"hostname", "s1-abc01"}},
+					{Words: []string{"ssh"}},
+				}},
+			}},
+		},
+		{
+			name: "list-entries",
+			config: `<data><interfaces>
+  <interface><name>eth0</name><mtu>9000</mtu></interface>
+  <interface><name>eth1</name><mtu>1500</mtu></interface>
+</interfaces></data>`,
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"interfaces"}, Container: true, Children: []*Node{
+					{Words: []string{"interface", "eth0"}, Container: true, Children: []*Node{
+						{Words: []string{"name", "eth0"}},
+						{Words: []string{"mtu", "9000"}},
+					}},
+					{Words: []string{"interface", "eth1"}, Container: true, Children: []*Node{
+						{Words: []string{"name", "eth1"}},
+						{Words: []string{"mtu", "1500"}},
+					}},
+				}},
+			}},
+		},
+		{
+			name:   "malformed",
+			config: "<system><hostname>s1</hostname></x></y><ntp",
+			want: &Node{Container: true, Children: []*Node{
+				{Words: []string{"system"}, Container: true, Children: []*Node{
+					{Words: []string{"hostname", "s1"}},
+				}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseXML(tt.config); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseXML() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Package reload reloads parts of the configuration of the service, e.g. the
+// SSH credentials or the normalization rules, without restarting it.
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Results of a reload.
+const (
+	success = "success"
+	failure = "failure"
+)
+
+var (
+	reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "switch_monitoring_reloads_total",
+		Help: "Number of reloads of the configuration, by component and result",
+	}, []string{"component", "result"})
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "switch_monitoring_reload_last_success_timestamp_seconds",
+		Help: "Time of the last successful reload, by component",
+	}, []string{"component"})
+)
+
+// Component is a part of the configuration that can be reloaded.
+type Component struct {
+	// Name identifies the component in logs and metrics.
+	Name string
+	// Files, if set, returns the files the component is loaded from. The
+	// component is reloaded when any of them changes.
+	Files func() []string
+	// Load loads the component, e.g. reading its files or downloading it,
+	// and returns the function swapping it into the running service. If it
+	// fails, the component in use must be kept. Load may run concurrently
+	// with other loads, while the swaps never do.
+	Load func(ctx context.Context) (swap func(), err error)
+}
+
+// Reloader reloads components when their files change, when asked with
+// Reload or through the /admin/reload endpoint. Components are loaded
+// without holding its lock, so that a slow download does not delay the
+// other reloads, and swapped while holding it.
+type Reloader struct {
+	mu         sync.Mutex
+	components []*component
+}
+
+// component is the state of a Component.
+type component struct {
+	Component
+	// mtimes are the modification times of the files when the component
+	// was last loaded. Missing files have a zero time.
+	mtimes map[string]time.Time
+}
+
+// New returns a Reloader without any component.
+func New() *Reloader {
+	return &Reloader{}
+}
+
+// Add adds a component and loads it. It returns the error of the first load.
+func (r *Reloader) Add(ctx context.Context, c Component) error {
+	comp := &component{Component: c}
+	r.mu.Lock()
+	r.components = append(r.components, comp)
+	r.mu.Unlock()
+	return r.load(ctx, comp)
+}
+
+// Watch adds a component that is already loaded, without loading it again.
+func (r *Reloader) Watch(c Component) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	comp := &component{Component: c}
+	comp.mtimes = comp.stat()
+	r.components = append(r.components, comp)
+}
+
+// Reload loads every component again. It returns the errors of the
+// components that failed, which keep using their previous configuration.
+func (r *Reloader) Reload(ctx context.Context) error {
+	var errs []error
+	for _, c := range r.snapshot() {
+		if err := r.load(ctx, c); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run checks every interval whether the files of the components changed, and
+// reloads the ones that did, until the context is canceled. If the interval
+// is zero, the files are not checked. The components are also reloaded
+// whenever a signal is received, e.g. SIGHUP.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			log.WithField("signal", sig).Info("Reloading the configuration")
+			if err := r.Reload(ctx); err != nil {
+				log.WithError(err).Error("Cannot reload the configuration")
+			}
+		case <-tick:
+			r.poll(ctx)
+		}
+	}
+}
+
+// poll reloads the components whose files changed.
+func (r *Reloader) poll(ctx context.Context) {
+	for _, c := range r.snapshot() {
+		r.mu.Lock()
+		changed := c.changed()
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+		log.WithField("component", c.Name).Info("Files changed, reloading")
+		if err := r.load(ctx, c); err != nil {
+			log.WithField("component", c.Name).WithError(err).Error(
+				"Cannot reload")
+		}
+	}
+}
+
+// ServeReload handles POST requests to the /admin/reload endpoint,
+// reloading every component. It replies with the error of each component,
+// if any, and fails with 500 if any component failed.
+func (r *Reloader) ServeReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	type result struct {
+		Component string `json:"component"`
+		Error     string `json:"error,omitempty"`
+	}
+	components := r.snapshot()
+	results := make([]result, 0, len(components))
+	status := http.StatusOK
+	for _, c := range components {
+		res := result{Component: c.Name}
+		if err := r.load(req.Context(), c); err != nil {
+			log.WithField("component", c.Name).WithError(err).Error(
+				"Cannot reload")
+			res.Error = err.Error()
+			status = http.StatusInternalServerError
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Components []result `json:"components"`
+	}{results})
+}
+
+// snapshot returns the current components, so that they can be loaded
+// without holding the lock.
+func (r *Reloader) snapshot() []*component {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*component(nil), r.components...)
+}
+
+// load loads the component, swaps it in while holding the lock and records
+// the result. The modification times of its files are recorded even if it
+// fails, so that it's not reloaded until they change again. They are recorded
+// after swapping, since the files can depend on the loaded configuration.
+func (r *Reloader) load(ctx context.Context, c *component) error {
+	swap, err := c.Load(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		swap()
+	}
+	c.mtimes = c.stat()
+	if err != nil {
+		reloads.WithLabelValues(c.Name, failure).Inc()
+		return err
+	}
+	reloads.WithLabelValues(c.Name, success).Inc()
+	lastSuccess.WithLabelValues(c.Name).SetToCurrentTime()
+	return nil
+}
+
+// changed returns true if any file was added, removed or modified since the
+// component was last loaded.
+func (c *component) changed() bool {
+	mtimes := c.stat()
+	if len(mtimes) != len(c.mtimes) {
+		return true
+	}
+	for file, mtime := range mtimes {
+		if last, ok := c.mtimes[file]; !ok || !last.Equal(mtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// stat returns the modification times of the component's files.
+func (c *component) stat() map[string]time.Time {
+	if c.Files == nil {
+		return nil
+	}
+	mtimes := map[string]time.Time{}
+	for _, file := range c.Files() {
+		var mtime time.Time
+		if fi, err := os.Stat(file); err == nil {
+			mtime = fi.ModTime()
+		}
+		mtimes[file] = mtime
+	}
+	return mtimes
+}
@@ -0,0 +1,252 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+)
+
+// counter is a Component counting its loads and swaps, failing if err is
+// set. If block is set, loads wait until it's closed.
+type counter struct {
+	mu    sync.Mutex
+	files []string
+	loads int
+	swaps int
+	err   error
+	block chan struct{}
+}
+
+func (c *counter) component(name string) Component {
+	return Component{
+		Name: name,
+		Files: func() []string {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.files
+		},
+		Load: func(context.Context) (func(), error) {
+			if c.block != nil {
+				<-c.block
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.loads++
+			return func() {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				c.swaps++
+			}, c.err
+		},
+	}
+}
+
+func (c *counter) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loads
+}
+
+func (c *counter) swapped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.swaps
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ctx := context.Background()
+	ok := &counter{}
+	failing := &counter{err: errors.New("invalid")}
+	r := New()
+	if err := r.Add(ctx, ok.component("ok")); err != nil {
+		t.Errorf("Add() error = %v", err)
+	}
+	if err := r.Add(ctx, failing.component("failing")); err == nil {
+		t.Errorf("Add() did not return the error of the first load")
+	}
+
+	err := r.Reload(ctx)
+	if err == nil || err.Error() != "failing: invalid" {
+		t.Errorf("Reload() error = %v, want failing: invalid", err)
+	}
+	if ok.count() != 2 || failing.count() != 2 {
+		t.Errorf("Reload() loads = %d, %d, want 2, 2", ok.count(), failing.count())
+	}
+	// Components that fail to load are not swapped in.
+	if ok.swapped() != 2 || failing.swapped() != 0 {
+		t.Errorf("Reload() swaps = %d, %d, want 2, 0", ok.swapped(), failing.swapped())
+	}
+}
+
+func TestReloader_slowLoad(t *testing.T) {
+	ctx := context.Background()
+	slow := &counter{}
+	r := New()
+	rtx.Must(r.Add(ctx, slow.component("inventory")), "Cannot add component")
+
+	slow.block = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		r.ServeReload(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodPost, "/v1/admin/reload", nil))
+		close(done)
+	}()
+	// Other components are loaded while the slow one is loading.
+	other := &counter{}
+	rtx.Must(r.Add(ctx, other.component("rules")), "Cannot add component")
+	r.poll(ctx)
+	if other.swapped() != 1 {
+		t.Errorf("swaps while loading = %d, want 1", other.swapped())
+	}
+	close(slow.block)
+	<-done
+	if slow.swapped() != 2 {
+		t.Errorf("swaps after loading = %d, want 2", slow.swapped())
+	}
+}
+
+func TestReloader_poll(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "rules.json")
+	rtx.Must(ioutil.WriteFile(file, []byte("{}"), 0600), "Cannot write file")
+
+	c := &counter{files: []string{file}}
+	static := &counter{}
+	r := New()
+	rtx.Must(r.Add(ctx, c.component("rules")), "Cannot add component")
+	r.Watch(static.component("inventory"))
+
+	steps := []struct {
+		name   string
+		change func()
+		want   int
+	}{
+		{name: "unchanged", change: func() {}, want: 1},
+		{
+			name: "modified",
+			change: func() {
+				later := time.Now().Add(time.Minute)
+				rtx.Must(os.Chtimes(file, later, later), "Cannot touch file")
+			},
+			want: 2,
+		},
+		{name: "unchanged-after-reload", change: func() {}, want: 2},
+		{
+			name: "removed",
+			change: func() {
+				rtx.Must(os.Remove(file), "Cannot remove file")
+			},
+			want: 3,
+		},
+		{
+			name: "file-added",
+			change: func() {
+				c.mu.Lock()
+				c.files = append(c.files, filepath.Join(dir, "key"))
+				c.mu.Unlock()
+			},
+			want: 4,
+		},
+	}
+	for _, s := range steps {
+		s.change()
+		r.poll(ctx)
+		if got := c.count(); got != s.want {
+			t.Errorf("%s: loads = %d, want %d", s.name, got, s.want)
+		}
+	}
+	// Components without files are only reloaded when asked.
+	if static.count() != 0 {
+		t.Errorf("component without files loaded %d times, want 0", static.count())
+	}
+}
+
+func TestReloader_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &counter{}
+	r := New()
+	rtx.Must(r.Add(ctx, c.component("credentials")), "Cannot add component")
+
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, 0, signals)
+		close(done)
+	}()
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+	cancel()
+	<-done
+	if c.count() < 2 {
+		t.Errorf("Run() loads = %d, want at least 2", c.count())
+	}
+}
+
+func TestReloader_ServeReload(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		err    error
+		status int
+		want   []map[string]string
+	}{
+		{
+			name:   "success",
+			method: http.MethodPost,
+			status: http.StatusOK,
+			want:   []map[string]string{{"component": "ok"}, {"component": "other"}},
+		},
+		{
+			name:   "failure",
+			method: http.MethodPost,
+			err:    errors.New("invalid"),
+			status: http.StatusInternalServerError,
+			want: []map[string]string{
+				{"component": "ok"},
+				{"component": "other", "error": "invalid"},
+			},
+		},
+		{
+			name:   "method-not-allowed",
+			method: http.MethodGet,
+			status: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := New()
+			rtx.Must(r.Add(ctx, (&counter{}).component("ok")), "Cannot add component")
+			r.Add(ctx, (&counter{err: tt.err}).component("other"))
+
+			rr := httptest.NewRecorder()
+			r.ServeReload(rr, httptest.NewRequest(tt.method, "/v1/admin/reload", nil))
+			if rr.Code != tt.status {
+				t.Fatalf("ServeReload() status = %d, want %d", rr.Code, tt.status)
+			}
+			if tt.want == nil {
+				return
+			}
+			var got struct {
+				Components []map[string]string `json:"components"`
+			}
+			rtx.Must(json.Unmarshal(rr.Body.Bytes(), &got), "Cannot parse response")
+			if !reflect.DeepEqual(got.Components, tt.want) {
+				t.Errorf("ServeReload() = %v, want %v", got.Components, tt.want)
+			}
+		})
+	}
+}
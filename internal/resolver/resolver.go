@@ -0,0 +1,185 @@
+// Package resolver maps the hostname of a switch to the site it belongs to.
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrNoMatch is returned by a Resolver that does not recognize a hostname.
+var ErrNoMatch = errors.New("no match")
+
+// Resolver returns the site of a switch from its hostname.
+type Resolver interface {
+	// Name identifies the resolver in error messages.
+	Name() string
+	// Site returns the site of the switch. It returns an error wrapping
+	// ErrNoMatch if the hostname is not recognized.
+	Site(hostname string) (string, error)
+}
+
+// Error is returned when no resolver recognizes a hostname. It lists why
+// each resolver rejected it.
+type Error struct {
+	Hostname string
+	// Rejections are the errors of the resolvers, in the order they were
+	// tried.
+	Rejections []Rejection
+}
+
+// Rejection is the reason a resolver rejected a hostname.
+type Rejection struct {
+	Resolver string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	reasons := make([]string, len(e.Rejections))
+	for i, r := range e.Rejections {
+		reasons[i] = r.Resolver + ": " + r.Err.Error()
+	}
+	return fmt.Sprintf("cannot extract site from hostname: %s (%s)",
+		e.Hostname, strings.Join(reasons, "; "))
+}
+
+// Unwrap returns ErrNoMatch, so that errors.Is can recognize the error.
+func (e *Error) Unwrap() error {
+	return ErrNoMatch
+}
+
+// Chain tries each resolver in order and returns the first site found.
+type Chain []Resolver
+
+// Name returns the names of the resolvers in the chain.
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, r := range c {
+		names[i] = r.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Site returns the site found by the first resolver recognizing the
+// hostname. If none does, it returns an *Error.
+func (c Chain) Site(hostname string) (string, error) {
+	e := &Error{Hostname: hostname}
+	for _, r := range c {
+		site, err := r.Site(hostname)
+		if err == nil {
+			return site, nil
+		}
+		e.Rejections = append(e.Rejections, Rejection{Resolver: r.Name(), Err: err})
+	}
+	return "", e
+}
+
+// Pattern extracts the site from hostnames matching a regular expression.
+type Pattern struct {
+	name string
+	re   *regexp.Regexp
+	// group is the index of the submatch containing the site.
+	group int
+}
+
+// Built-in naming schemes of M-Lab switches. The index of the switch at the
+// site can be any number, e.g. s1, s2 or s3.
+var (
+	// V2 matches hostnames like s1-abc01.measurement-lab.org.
+	V2 = MustRegexp("v2", `^s[0-9]+-([a-z]{3}[0-9ct]{2})(\..*)?$`)
+	// V1 matches hostnames like s1.abc01.measurement-lab.org.
+	V1 = MustRegexp("v1", `^s[0-9]+\.([a-z]{3}[0-9ct]{2})(\..*)?$`)
+
+	// Default recognizes both naming schemes.
+	Default = Chain{V2, V1}
+)
+
+// NewRegexp returns a Pattern extracting the site from the submatch named
+// "site" of the expression, or from its first submatch if none is named
+// "site".
+func NewRegexp(name, expr string) (*Pattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resolver %s: %w", name, err)
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("invalid resolver %s: no submatch for the site in %q",
+			name, expr)
+	}
+	group := re.SubexpIndex("site")
+	if group < 0 {
+		group = 1
+	}
+	return &Pattern{name: name, re: re, group: group}, nil
+}
+
+// MustRegexp is like NewRegexp, but panics if the expression is invalid.
+func MustRegexp(name, expr string) *Pattern {
+	p, err := NewRegexp(name, expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// NewTemplate returns a Pattern matching the hostnames built from a
+// template, e.g. "sw{index}.{site}.example.org". The {site} placeholder is
+// the site name and {index} is the number of the switch at the site.
+func NewTemplate(name, template string) (*Pattern, error) {
+	if strings.Count(template, "{site}") != 1 {
+		return nil, fmt.Errorf("invalid resolver %s: %q must contain {site} once",
+			name, template)
+	}
+	expr := strings.NewReplacer(
+		regexp.QuoteMeta("{site}"), `(?P<site>[a-z0-9]+)`,
+		regexp.QuoteMeta("{index}"), `[0-9]+`,
+	).Replace(regexp.QuoteMeta(template))
+	return NewRegexp(name, "^"+expr+"$")
+}
+
+// Name returns the name of the Pattern.
+func (p *Pattern) Name() string {
+	return p.name
+}
+
+// Site returns the site extracted from the hostname.
+func (p *Pattern) Site(hostname string) (string, error) {
+	m := p.re.FindStringSubmatch(hostname)
+	if m == nil || m[p.group] == "" {
+		return "", ErrNoMatch
+	}
+	return m[p.group], nil
+}
+
+// SiteSource knows the site of some of the switches.
+type SiteSource interface {
+	// Site returns the site of the target, or false if it's unknown.
+	Site(target string) (string, bool)
+}
+
+// Table looks up the site of the switches in a SiteSource, e.g. the
+// inventory.
+type Table struct {
+	name   string
+	source SiteSource
+}
+
+// NewTable returns a Table looking up the sites in the provided source.
+func NewTable(name string, source SiteSource) *Table {
+	return &Table{name: name, source: source}
+}
+
+// Name returns the name of the Table.
+func (t *Table) Name() string {
+	return t.name
+}
+
+// Site returns the site of the hostname, as listed by the source.
+func (t *Table) Site(hostname string) (string, error) {
+	site, ok := t.source.Site(hostname)
+	if !ok {
+		return "", fmt.Errorf("unknown switch: %w", ErrNoMatch)
+	}
+	return site, nil
+}
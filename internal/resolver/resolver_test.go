@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"errors"
+	"testing"
+)
+
+type sites map[string]string
+
+func (s sites) Site(target string) (string, bool) {
+	site, ok := s[target]
+	return site, ok
+}
+
+func TestChain_Site(t *testing.T) {
+	template, err := NewTemplate("template", "sw{index}.{site}.example.org")
+	if err != nil {
+		t.Fatalf("NewTemplate() returned error: %v", err)
+	}
+	regexp, err := NewRegexp("regexp", `^(edge)-(?P<site>[a-z]+)$`)
+	if err != nil {
+		t.Fatalf("NewRegexp() returned error: %v", err)
+	}
+	table := NewTable("inventory", sites{"core.example.org": "xyz01"})
+	chain := Chain{table, template, regexp, V2, V1}
+
+	tests := []struct {
+		hostname string
+		want     string
+		wantErr  string
+	}{
+		{hostname: "s1-abc01.measurement-lab.org", want: "abc01"},
+		{hostname: "s1-abc01", want: "abc01"},
+		{hostname: "s2-lga0t.measurement-lab.org", want: "lga0t"},
+		{hostname: "s1.abc01.measurement-lab.org", want: "abc01"},
+		{hostname: "s3.abc01", want: "abc01"},
+		{hostname: "sw12.par03.example.org", want: "par03"},
+		{hostname: "edge-ams", want: "ams"},
+		{hostname: "core.example.org", want: "xyz01"},
+		{
+			hostname: "mlab1-abc01.measurement-lab.org",
+			wantErr: "cannot extract site from hostname: mlab1-abc01.measurement-lab.org " +
+				"(inventory: unknown switch: no match; template: no match; " +
+				"regexp: no match; v2: no match; v1: no match)",
+		},
+		{
+			hostname: "sw1.par03.example.org.evil",
+			wantErr: "cannot extract site from hostname: sw1.par03.example.org.evil " +
+				"(inventory: unknown switch: no match; template: no match; " +
+				"regexp: no match; v2: no match; v1: no match)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostname, func(t *testing.T) {
+			got, err := chain.Site(tt.hostname)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Site() error = %v, want %s", err, tt.wantErr)
+				}
+				if !errors.Is(err, ErrNoMatch) {
+					t.Errorf("Site() error is not ErrNoMatch")
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Site() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChain_Name(t *testing.T) {
+	if got := Default.Name(); got != "v2,v1" {
+		t.Errorf("Name() = %q, want v2,v1", got)
+	}
+}
+
+func TestNewRegexp(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "first-group", expr: `^sw-([a-z]+)$`},
+		{name: "named-group", expr: `^(sw|s)-(?P<site>[a-z]+)$`},
+		{name: "invalid", expr: `^sw-([a-z]+$`, wantErr: true},
+		{name: "no-group", expr: `^sw-[a-z]+$`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewRegexp(tt.name, tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRegexp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && p.Name() != tt.name {
+				t.Errorf("Name() = %q, want %q", p.Name(), tt.name)
+			}
+		})
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustRegexp() didn't panic")
+		}
+	}()
+	MustRegexp("invalid", `(`)
+}
+
+func TestNewTemplate(t *testing.T) {
+	for _, template := range []string{"sw{index}.example.org", "{site}.{site}"} {
+		if _, err := NewTemplate("template", template); err == nil {
+			t.Errorf("NewTemplate(%q) didn't return an error", template)
+		}
+	}
+}
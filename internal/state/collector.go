@@ -0,0 +1,202 @@
+// Package state collects the operational state of switches, such as the
+// status of their interfaces, chassis alarms and BGP sessions, as opposed to
+// their configuration.
+package state
+
+import (
+	"context"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rpcOK = prometheus.NewDesc("switch_monitoring_state_rpc_ok",
+		"Whether the operational RPC succeeded for this target",
+		[]string{"target", "rpc"}, nil)
+	interfaceUp = prometheus.NewDesc("switch_monitoring_interface_oper_up",
+		"Whether the physical interface is operationally up",
+		[]string{"target", "interface"}, nil)
+	inputErrors = prometheus.NewDesc(
+		"switch_monitoring_interface_input_errors_total",
+		"Input errors on the physical interface",
+		[]string{"target", "interface"}, nil)
+	inputDrops = prometheus.NewDesc(
+		"switch_monitoring_interface_input_drops_total",
+		"Input drops on the physical interface",
+		[]string{"target", "interface"}, nil)
+	outputErrors = prometheus.NewDesc(
+		"switch_monitoring_interface_output_errors_total",
+		"Output errors on the physical interface",
+		[]string{"target", "interface"}, nil)
+	outputDrops = prometheus.NewDesc(
+		"switch_monitoring_interface_output_drops_total",
+		"Output drops on the physical interface",
+		[]string{"target", "interface"}, nil)
+	chassisAlarms = prometheus.NewDesc("switch_monitoring_chassis_alarms",
+		"Number of active chassis alarms by class",
+		[]string{"target", "class"}, nil)
+	bgpPeerUp = prometheus.NewDesc("switch_monitoring_bgp_peer_up",
+		"Whether the BGP session with the peer is established",
+		[]string{"target", "peer"}, nil)
+	uptime = prometheus.NewDesc("switch_monitoring_uptime_seconds",
+		"Time since the switch booted",
+		[]string{"target"}, nil)
+)
+
+// probe is an operational RPC and the function turning its reply into
+// metrics.
+type probe struct {
+	name    string
+	rpc     string
+	collect func(target, reply string, ch chan<- prometheus.Metric) error
+}
+
+var probes = []probe{
+	{name: "interfaces", rpc: rpcInterfaces, collect: collectInterfaces},
+	{name: "alarms", rpc: rpcAlarms, collect: collectAlarms},
+	{name: "bgp", rpc: rpcBGP, collect: collectBGP},
+	{name: "uptime", rpc: rpcUptime, collect: collectUptime},
+}
+
+// Collector runs JunOS operational RPCs on a single target and exports the
+// results as metrics. Like the configuration collector, it is meant to be
+// used for a single request, whose context is provided when creating it.
+type Collector struct {
+	ctx    context.Context
+	target string
+	client internal.RPCClient
+}
+
+// New returns a Collector for the target.
+func New(ctx context.Context, target string, client internal.RPCClient) *Collector {
+	return &Collector{
+		ctx:    ctx,
+		target: target,
+		client: client,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rpcOK
+	ch <- interfaceUp
+	ch <- inputErrors
+	ch <- inputDrops
+	ch <- outputErrors
+	ch <- outputDrops
+	ch <- chassisAlarms
+	ch <- bgpPeerUp
+	ch <- uptime
+}
+
+// Collect runs every RPC in turn. A failed RPC does not prevent the others
+// from running, and is reported by switch_monitoring_state_rpc_ok.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, p := range probes {
+		ok := 1.0
+		if err := c.run(p, ch); err != nil {
+			log.WithFields(log.Fields{
+				"target": c.target,
+				"rpc":    p.name,
+			}).WithError(err).Error("Cannot collect operational state")
+			ok = 0
+		}
+		ch <- prometheus.MustNewConstMetric(rpcOK, prometheus.GaugeValue, ok,
+			c.target, p.name)
+	}
+}
+
+func (c *Collector) run(p probe, ch chan<- prometheus.Metric) error {
+	reply, err := c.client.Exec(c.ctx, c.target, p.rpc)
+	if err != nil {
+		// Switches without BGP sessions have no BGP state to report.
+		if p.rpc == rpcBGP && strings.Contains(err.Error(), "not running") {
+			return nil
+		}
+		return err
+	}
+	return p.collect(c.target, reply, ch)
+}
+
+func collectInterfaces(target, reply string, ch chan<- prometheus.Metric) error {
+	var info interfaceInformation
+	if err := parseReply(reply, &info); err != nil {
+		return err
+	}
+	for _, i := range info.Interfaces {
+		name := strings.TrimSpace(i.Name)
+		up := 0.0
+		if status(i.OperStatus) == "up" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(interfaceUp, prometheus.GaugeValue,
+			up, target, name)
+
+		for _, counter := range []struct {
+			desc  *prometheus.Desc
+			value string
+		}{
+			{inputErrors, i.InputErrors},
+			{inputDrops, i.InputDrops},
+			{outputErrors, i.OutputErrors},
+			{outputDrops, i.OutputDrops},
+		} {
+			if v, ok := number(counter.value); ok {
+				ch <- prometheus.MustNewConstMetric(counter.desc,
+					prometheus.CounterValue, v, target, name)
+			}
+		}
+	}
+	return nil
+}
+
+func collectAlarms(target, reply string, ch chan<- prometheus.Metric) error {
+	var info alarmInformation
+	if err := parseReply(reply, &info); err != nil {
+		return err
+	}
+	counts := map[string]float64{}
+	for _, class := range alarmClasses {
+		counts[class] = 0
+	}
+	for _, a := range info.Alarms {
+		counts[status(a.Class)]++
+	}
+	for class, n := range counts {
+		ch <- prometheus.MustNewConstMetric(chassisAlarms,
+			prometheus.GaugeValue, n, target, class)
+	}
+	return nil
+}
+
+func collectBGP(target, reply string, ch chan<- prometheus.Metric) error {
+	var info bgpInformation
+	if err := parseReply(reply, &info); err != nil {
+		return err
+	}
+	for _, p := range info.Peers {
+		up := 0.0
+		if status(p.State) == "established" {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(bgpPeerUp, prometheus.GaugeValue,
+			up, target, strings.TrimSpace(p.Address))
+	}
+	return nil
+}
+
+func collectUptime(target, reply string, ch chan<- prometheus.Metric) error {
+	var info uptimeInformation
+	if err := parseReply(reply, &info); err != nil {
+		return err
+	}
+	seconds, err := info.uptime()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(uptime, prometheus.GaugeValue,
+		seconds, target)
+	return nil
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// rpcProvider is a mock RPCClient returning the content of the testdata
+// file for each RPC.
+type rpcProvider struct {
+	files map[string]string
+	errs  map[string]error
+}
+
+func (p *rpcProvider) Exec(ctx context.Context, hostname, rpc string) (string, error) {
+	if err, ok := p.errs[rpc]; ok {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(p.files[rpc])
+	rtx.Must(err, "Cannot read test data")
+	return string(content), nil
+}
+
+func newRPCProvider() *rpcProvider {
+	return &rpcProvider{
+		files: map[string]string{
+			rpcInterfaces: "testdata/interfaces.xml",
+			rpcAlarms:     "testdata/alarms.xml",
+			rpcBGP:        "testdata/bgp.xml",
+			rpcUptime:     "testdata/uptime.xml",
+		},
+		errs: map[string]error{},
+	}
+}
+
+func TestCollector_Collect(t *testing.T) {
+	const target = "s1-abc01.measurement-lab.org"
+	tests := []struct {
+		name    string
+		errs    map[string]error
+		metrics []string
+		want    string
+	}{
+		{
+			name: "success",
+			want: `
+# HELP switch_monitoring_bgp_peer_up Whether the BGP session with the peer is established
+# TYPE switch_monitoring_bgp_peer_up gauge
+switch_monitoring_bgp_peer_up{peer="192.168.1.1",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_bgp_peer_up{peer="192.168.1.2",target="s1-abc01.measurement-lab.org"} 0
+# HELP switch_monitoring_chassis_alarms Number of active chassis alarms by class
+# TYPE switch_monitoring_chassis_alarms gauge
+switch_monitoring_chassis_alarms{class="major",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_chassis_alarms{class="minor",target="s1-abc01.measurement-lab.org"} 0
+# HELP switch_monitoring_interface_input_drops_total Input drops on the physical interface
+# TYPE switch_monitoring_interface_input_drops_total counter
+switch_monitoring_interface_input_drops_total{interface="xe-0/0/0",target="s1-abc01.measurement-lab.org"} 0
+# HELP switch_monitoring_interface_input_errors_total Input errors on the physical interface
+# TYPE switch_monitoring_interface_input_errors_total counter
+switch_monitoring_interface_input_errors_total{interface="xe-0/0/0",target="s1-abc01.measurement-lab.org"} 3
+# HELP switch_monitoring_interface_oper_up Whether the physical interface is operationally up
+# TYPE switch_monitoring_interface_oper_up gauge
+switch_monitoring_interface_oper_up{interface="xe-0/0/0",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_interface_oper_up{interface="xe-0/0/1",target="s1-abc01.measurement-lab.org"} 0
+# HELP switch_monitoring_interface_output_drops_total Output drops on the physical interface
+# TYPE switch_monitoring_interface_output_drops_total counter
+switch_monitoring_interface_output_drops_total{interface="xe-0/0/0",target="s1-abc01.measurement-lab.org"} 7
+# HELP switch_monitoring_interface_output_errors_total Output errors on the physical interface
+# TYPE switch_monitoring_interface_output_errors_total counter
+switch_monitoring_interface_output_errors_total{interface="xe-0/0/0",target="s1-abc01.measurement-lab.org"} 0
+# HELP switch_monitoring_state_rpc_ok Whether the operational RPC succeeded for this target
+# TYPE switch_monitoring_state_rpc_ok gauge
+switch_monitoring_state_rpc_ok{rpc="alarms",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="bgp",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="interfaces",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="uptime",target="s1-abc01.measurement-lab.org"} 1
+# HELP switch_monitoring_uptime_seconds Time since the switch booted
+# TYPE switch_monitoring_uptime_seconds gauge
+switch_monitoring_uptime_seconds{target="s1-abc01.measurement-lab.org"} 86400
+`,
+		},
+		{
+			name: "bgp-not-running",
+			errs: map[string]error{
+				rpcBGP: errors.New("netconf rpc [error] 'BGP is not running'"),
+			},
+			metrics: []string{"switch_monitoring_bgp_peer_up",
+				"switch_monitoring_state_rpc_ok"},
+			want: `
+# HELP switch_monitoring_state_rpc_ok Whether the operational RPC succeeded for this target
+# TYPE switch_monitoring_state_rpc_ok gauge
+switch_monitoring_state_rpc_ok{rpc="alarms",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="bgp",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="interfaces",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="uptime",target="s1-abc01.measurement-lab.org"} 1
+`,
+		},
+		{
+			name: "rpc-failures",
+			errs: map[string]error{
+				rpcInterfaces: errors.New("connection refused"),
+				rpcUptime:     errors.New("connection refused"),
+			},
+			metrics: []string{"switch_monitoring_interface_oper_up",
+				"switch_monitoring_state_rpc_ok",
+				"switch_monitoring_uptime_seconds"},
+			want: `
+# HELP switch_monitoring_state_rpc_ok Whether the operational RPC succeeded for this target
+# TYPE switch_monitoring_state_rpc_ok gauge
+switch_monitoring_state_rpc_ok{rpc="alarms",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="bgp",target="s1-abc01.measurement-lab.org"} 1
+switch_monitoring_state_rpc_ok{rpc="interfaces",target="s1-abc01.measurement-lab.org"} 0
+switch_monitoring_state_rpc_ok{rpc="uptime",target="s1-abc01.measurement-lab.org"} 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newRPCProvider()
+			for rpc, err := range tt.errs {
+				client.errs[rpc] = err
+			}
+			c := New(context.Background(), target, client)
+			err := testutil.CollectAndCompare(c, strings.NewReader(tt.want),
+				tt.metrics...)
+			if err != nil {
+				t.Errorf("Collect() returned err: %v", err)
+			}
+		})
+	}
+}
+
+func TestCollector_invalidReplies(t *testing.T) {
+	client := newRPCProvider()
+	for rpc := range client.files {
+		client.files[rpc] = "testdata/invalid.xml"
+	}
+	c := New(context.Background(), "s1-abc01.measurement-lab.org", client)
+	want := `
+# HELP switch_monitoring_state_rpc_ok Whether the operational RPC succeeded for this target
+# TYPE switch_monitoring_state_rpc_ok gauge
+switch_monitoring_state_rpc_ok{rpc="alarms",target="s1-abc01.measurement-lab.org"} 0
+switch_monitoring_state_rpc_ok{rpc="bgp",target="s1-abc01.measurement-lab.org"} 0
+switch_monitoring_state_rpc_ok{rpc="interfaces",target="s1-abc01.measurement-lab.org"} 0
+switch_monitoring_state_rpc_ok{rpc="uptime",target="s1-abc01.measurement-lab.org"} 0
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(want),
+		"switch_monitoring_state_rpc_ok")
+	if err != nil {
+		t.Errorf("Collect() returned err: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/apex/log"
+	"github.com/m-lab/switch-monitoring/internal"
+	"github.com/m-lab/switch-monitoring/internal/collector"
+	"github.com/m-lab/switch-monitoring/internal/driver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler is the HTTP handler for /state.
+type Handler struct {
+	// Inventory, if set, restricts the checks to the switches it contains.
+	Inventory collector.Inventory
+
+	drivers *driver.Registry
+}
+
+// NewHandler returns a Handler running the RPCs with the NETCONF client of
+// each target's driver. Operational RPCs are vendor-specific, so only
+// Juniper switches are supported.
+func NewHandler(drivers *driver.Registry) *Handler {
+	return &Handler{drivers: drivers}
+}
+
+// ServeHTTP handles GET requests to the /state endpoint, parsing the target
+// parameter and delegating writing the actual response to promhttp.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	if len(target) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("URL parameter 'target' is missing"))
+		log.Info("URL parameter 'target' is missing")
+		return
+	}
+
+	client, status, err := h.clientFor(target)
+	if err != nil {
+		w.WriteHeader(status)
+		w.Write([]byte(err.Error()))
+		log.WithError(err).Error("Error while processing request")
+		return
+	}
+
+	ctx, cancel := collector.ScrapeContext(r)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(New(ctx, target, client))
+
+	promHandler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	promHandler.ServeHTTP(w, r)
+}
+
+// clientFor returns the client to run RPCs on the target. If it fails, the
+// returned status is the HTTP status code describing the failure.
+func (h *Handler) clientFor(target string) (internal.RPCClient, int, error) {
+	if h.Inventory != nil {
+		ok, err := h.Inventory.Contains(target)
+		if err != nil {
+			return nil, http.StatusServiceUnavailable, err
+		}
+		if !ok {
+			return nil, http.StatusNotFound,
+				fmt.Errorf("unknown target: %s", target)
+		}
+	}
+
+	if vendor := h.drivers.Vendor(target); vendor != driver.Juniper {
+		return nil, http.StatusNotImplemented,
+			fmt.Errorf("operational state is not supported for vendor %q of %s",
+				vendor, target)
+	}
+	d, err := h.drivers.ForTarget(target)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	client, ok := d.Netconf.(internal.RPCClient)
+	if !ok {
+		return nil, http.StatusNotImplemented,
+			fmt.Errorf("the driver of %s cannot run RPCs", target)
+	}
+	return client, http.StatusOK, nil
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m-lab/switch-monitoring/internal/driver"
+)
+
+// netconfProvider is a mock NetconfClient that can also run RPCs.
+type netconfProvider struct {
+	*rpcProvider
+}
+
+func (n *netconfProvider) GetConfig(context.Context, string, ...string) (string, error) {
+	return "", nil
+}
+
+// configOnlyProvider is a mock NetconfClient that cannot run RPCs.
+type configOnlyProvider struct{}
+
+func (configOnlyProvider) GetConfig(context.Context, string, ...string) (string, error) {
+	return "", nil
+}
+
+type mockInventory struct {
+	targets map[string]bool
+	err     error
+}
+
+func (i *mockInventory) Contains(target string) (bool, error) {
+	return i.targets[target], i.err
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	drivers := driver.NewRegistry(driver.Juniper)
+	drivers.Register(driver.Juniper, driver.Driver{
+		Netconf: &netconfProvider{newRPCProvider()},
+	})
+	drivers.Register(driver.Generic, driver.Driver{
+		Netconf: &netconfProvider{newRPCProvider()},
+	})
+	drivers.Register("norpc", driver.Driver{Netconf: configOnlyProvider{}})
+	drivers.Overrides = map[string]string{
+		"s1-gen01": driver.Generic,
+		"s1-rpc01": "norpc",
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		inventory *mockInventory
+		status    int
+		want      string
+	}{
+		{
+			name:   "success",
+			method: "GET",
+			url:    "/v1/state?target=s1-abc01",
+			status: http.StatusOK,
+			want:   `switch_monitoring_uptime_seconds{target="s1-abc01"} 86400`,
+		},
+		{
+			name:   "method-not-allowed",
+			method: "POST",
+			url:    "/v1/state?target=s1-abc01",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "missing-target",
+			method: "GET",
+			url:    "/v1/state",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unsupported-vendor",
+			method: "GET",
+			url:    "/v1/state?target=s1-gen01",
+			status: http.StatusNotImplemented,
+		},
+		{
+			name:   "driver-without-rpcs",
+			method: "GET",
+			url:    "/v1/state?target=s1-rpc01",
+			status: http.StatusNotImplemented,
+		},
+		{
+			name:      "unknown-target",
+			method:    "GET",
+			url:       "/v1/state?target=s1-xyz01",
+			inventory: &mockInventory{targets: map[string]bool{"s1-abc01": true}},
+			status:    http.StatusNotFound,
+		},
+		{
+			name:      "inventory-not-loaded",
+			method:    "GET",
+			url:       "/v1/state?target=s1-abc01",
+			inventory: &mockInventory{err: errors.New("not loaded")},
+			status:    http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(drivers)
+			if tt.inventory != nil {
+				h.Inventory = tt.inventory
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.url, nil))
+			if rr.Code != tt.status {
+				t.Errorf("ServeHTTP() returned %d, want %d", rr.Code, tt.status)
+			}
+			if !strings.Contains(rr.Body.String(), tt.want) {
+				t.Errorf("ServeHTTP() = %s, want %s", rr.Body.String(), tt.want)
+			}
+		})
+	}
+}
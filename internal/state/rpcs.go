@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// JunOS operational RPCs.
+const (
+	rpcInterfaces = "<get-interface-information><extensive/></get-interface-information>"
+	rpcAlarms     = "<get-alarm-information/>"
+	rpcBGP        = "<get-bgp-summary-information/>"
+	rpcUptime     = "<get-system-uptime-information/>"
+)
+
+// errNoUptime is returned if the uptime reply does not contain the current
+// and boot times.
+var errNoUptime = errors.New("no uptime information in reply")
+
+// alarmClasses are the classes of chassis alarms always reported, even when
+// there are no active alarms.
+var alarmClasses = []string{"major", "minor"}
+
+// interfaceInformation is the reply to <get-interface-information>.
+type interfaceInformation struct {
+	Interfaces []physicalInterface `xml:"physical-interface"`
+}
+
+type physicalInterface struct {
+	Name         string `xml:"name"`
+	OperStatus   string `xml:"oper-status"`
+	InputErrors  string `xml:"input-error-list>input-errors"`
+	InputDrops   string `xml:"input-error-list>input-drops"`
+	OutputErrors string `xml:"output-error-list>output-errors"`
+	OutputDrops  string `xml:"output-error-list>output-drops"`
+}
+
+// alarmInformation is the reply to <get-alarm-information>.
+type alarmInformation struct {
+	Alarms []struct {
+		Class string `xml:"alarm-class"`
+	} `xml:"alarm-detail"`
+}
+
+// bgpInformation is the reply to <get-bgp-summary-information>.
+type bgpInformation struct {
+	Peers []struct {
+		Address string `xml:"peer-address"`
+		State   string `xml:"peer-state"`
+	} `xml:"bgp-peer"`
+}
+
+// uptimeInformation is the reply to <get-system-uptime-information>.
+type uptimeInformation struct {
+	Current struct {
+		Seconds int64 `xml:"seconds,attr"`
+	} `xml:"current-time>date-time"`
+	Booted struct {
+		Seconds int64 `xml:"seconds,attr"`
+	} `xml:"system-booted-time>date-time"`
+}
+
+// uptime returns the number of seconds since the switch booted.
+func (u *uptimeInformation) uptime() (float64, error) {
+	if u.Current.Seconds == 0 || u.Booted.Seconds == 0 {
+		return 0, errNoUptime
+	}
+	return float64(u.Current.Seconds - u.Booted.Seconds), nil
+}
+
+// parseReply decodes the content of an RPC reply into v.
+func parseReply(reply string, v interface{}) error {
+	return xml.Unmarshal([]byte(reply), v)
+}
+
+// number parses a counter value, which JunOS surrounds with newlines. It
+// returns false if the value is missing or invalid.
+func number(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+// status returns the trimmed, lowercase value of a status field.
+func status(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
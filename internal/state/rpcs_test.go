@@ -0,0 +1,39 @@
+package state
+
+import "testing"
+
+func Test_number(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+		ok   bool
+	}{
+		{s: "\n42\n", want: 42, ok: true},
+		{s: "0", want: 0, ok: true},
+		{s: "", ok: false},
+		{s: "n/a", ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := number(tt.s)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("number(%q) = %v, %v, want %v, %v", tt.s, got, ok,
+				tt.want, tt.ok)
+		}
+	}
+}
+
+func Test_uptimeInformation_uptime(t *testing.T) {
+	var u uptimeInformation
+	if _, err := u.uptime(); err != errNoUptime {
+		t.Errorf("uptime() returned %v, want %v", err, errNoUptime)
+	}
+	if err := parseReply(`<system-uptime-information>
+<current-time><date-time junos:seconds="100">now</date-time></current-time>
+<system-booted-time><date-time junos:seconds="40">then</date-time></system-booted-time>
+</system-uptime-information>`, &u); err != nil {
+		t.Fatalf("parseReply() returned err: %v", err)
+	}
+	if got, err := u.uptime(); got != 60 || err != nil {
+		t.Errorf("uptime() = %v, %v, want 60", got, err)
+	}
+}